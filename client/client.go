@@ -0,0 +1,213 @@
+// Package client lets other Mattermost plugins manage scheduled messages of the scheduler plugin.
+//
+// The requests are sent using the PluginHTTP method of the plugin API, so they never leave the server:
+//
+//	scheduler := client.NewClient(p.API)
+//	schedule, err := scheduler.CreateSchedule(botUserID, client.ScheduleRequest{
+//		ChannelID: channelID,
+//		Cron:      "0 0 9 * * MON-FRI",
+//		Timezone:  "Europe/Berlin",
+//		Message:   "Time for the standup!",
+//	})
+//
+// Every request is made on behalf of the given user, which becomes the owner of created schedules. Pass the ID of
+// your plugins bot to own the schedules yourself, or the ID of a user to act for them. The same permissions apply
+// as for the slash commands of the scheduler, e.g. the owner has to be allowed to post into the channel.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// PluginID is the ID of the scheduler plugin
+	PluginID = "com.nilsbrinkmann.scheduler"
+
+	routeSchedules = "/" + PluginID + "/api/v1/schedules"
+)
+
+// States of a schedule
+const (
+	StateActive   = "active"
+	StatePaused   = "paused"
+	StateDisabled = "disabled"
+	StatePending  = "pending"
+)
+
+// PluginAPI is the part of the plugin API the client needs, usually this is the API of your plugin
+type PluginAPI interface {
+	PluginHTTP(request *http.Request) *http.Response
+}
+
+// Schedule is a scheduled message
+type Schedule struct {
+	ID        string `json:"id"`
+	Creator   string `json:"creator"`
+	ChannelID string `json:"channel_id"`
+	RootID    string `json:"root_id,omitempty"`
+	Cron      string `json:"cron"`
+	Message   string `json:"message"`
+	State     string `json:"state"`
+	Reason    string `json:"reason,omitempty"`
+	NextRun   int64  `json:"next_run,omitempty"` //time in millis of the next post, zero if the schedule does not post
+}
+
+// ScheduleRequest describes a schedule that is created or changed. ChannelID and RootID are ignored when a schedule
+// is changed.
+type ScheduleRequest struct {
+	ChannelID string `json:"channel_id,omitempty"`
+	RootID    string `json:"root_id,omitempty"` //set to post as a reply into a thread
+	Cron      string `json:"cron"`              //cron-syntax with seconds, or a descriptor like @every 1h
+	Timezone  string `json:"timezone,omitempty"`
+	Message   string `json:"message"`
+}
+
+// Error is returned when the scheduler rejects a request
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("scheduler: %s (status %d)", e.Message, e.StatusCode)
+}
+
+// Client sends requests to the scheduler plugin
+type Client struct {
+	api PluginAPI
+}
+
+// NewClient returns a client sending its requests using the given plugin API
+func NewClient(api PluginAPI) *Client {
+	return &Client{api: api}
+}
+
+// ListSchedules returns the schedules the given user can see, limited to the given channel unless it is empty
+func (c *Client) ListSchedules(userID string, channelID string) ([]Schedule, error) {
+	path := routeSchedules
+	if channelID != "" {
+		path = path + "?channel_id=" + url.QueryEscape(channelID)
+	}
+	schedules := []Schedule{}
+	if err := c.do(userID, http.MethodGet, path, nil, &schedules); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// GetSchedule returns the schedule with the given ID
+func (c *Client) GetSchedule(userID string, scheduleID string) (*Schedule, error) {
+	schedule := &Schedule{}
+	if err := c.do(userID, http.MethodGet, schedulePath(scheduleID, ""), nil, schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// CreateSchedule creates a new schedule owned by the given user
+func (c *Client) CreateSchedule(userID string, request ScheduleRequest) (*Schedule, error) {
+	schedule := &Schedule{}
+	if err := c.do(userID, http.MethodPost, routeSchedules, request, schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// UpdateSchedule changes the cron, timezone and message of the schedule with the given ID
+func (c *Client) UpdateSchedule(userID string, scheduleID string, request ScheduleRequest) (*Schedule, error) {
+	schedule := &Schedule{}
+	if err := c.do(userID, http.MethodPut, schedulePath(scheduleID, ""), request, schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// CancelSchedule removes the schedule with the given ID
+func (c *Client) CancelSchedule(userID string, scheduleID string) error {
+	return c.do(userID, http.MethodDelete, schedulePath(scheduleID, ""), nil, nil)
+}
+
+// PauseSchedule stops an active schedule from posting until it is resumed
+func (c *Client) PauseSchedule(userID string, scheduleID string) (*Schedule, error) {
+	schedule := &Schedule{}
+	if err := c.do(userID, http.MethodPost, schedulePath(scheduleID, "pause"), nil, schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// ResumeSchedule makes a paused schedule post again
+func (c *Client) ResumeSchedule(userID string, scheduleID string) (*Schedule, error) {
+	schedule := &Schedule{}
+	if err := c.do(userID, http.MethodPost, schedulePath(scheduleID, "resume"), nil, schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// RunSchedule posts the schedule with the given ID right now and returns the ID of the created post
+func (c *Client) RunSchedule(userID string, scheduleID string) (string, error) {
+	response := struct {
+		PostID string `json:"post_id"`
+	}{}
+	if err := c.do(userID, http.MethodPost, schedulePath(scheduleID, "run"), nil, &response); err != nil {
+		return "", err
+	}
+	return response.PostID, nil
+}
+
+func schedulePath(scheduleID string, action string) string {
+	path := routeSchedules + "/" + url.PathEscape(scheduleID)
+	if action != "" {
+		path = path + "/" + action
+	}
+	return path
+}
+
+// do sends a request with the given body encoded as JSON and decodes the response into result, if it is not nil
+func (c *Client) do(userID string, method string, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(content)
+	}
+	request, err := http.NewRequest(method, path, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Mattermost-User-Id", userID)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response := c.api.PluginHTTP(request)
+	if response == nil {
+		return &Error{StatusCode: http.StatusBadGateway, Message: "no response from the scheduler plugin"}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		content, _ := ioutil.ReadAll(response.Body)
+		apiError := struct {
+			Error string `json:"error"`
+		}{}
+		if json.Unmarshal(content, &apiError) != nil || apiError.Error == "" {
+			//the scheduler plugin may not be installed, in this case the server answers with plain text
+			apiError.Error = string(content)
+		}
+		return &Error{StatusCode: response.StatusCode, Message: apiError.Error}
+	}
+	if result == nil || response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAPI answers the requests of the client using the given handler, like the server would do with the plugin
+type fakeAPI struct {
+	handler  http.HandlerFunc
+	requests []*http.Request
+}
+
+func (api *fakeAPI) PluginHTTP(request *http.Request) *http.Response {
+	api.requests = append(api.requests, request)
+	w := httptest.NewRecorder()
+	api.handler(w, request)
+	return w.Result()
+}
+
+func TestClient(t *testing.T) {
+	t.Run("Create schedule", func(t *testing.T) {
+		api := &fakeAPI{handler: func(w http.ResponseWriter, r *http.Request) {
+			request := ScheduleRequest{}
+			json.NewDecoder(r.Body).Decode(&request)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(Schedule{ID: "schedule1", Creator: r.Header.Get("Mattermost-User-Id"), ChannelID: request.ChannelID, Cron: request.Cron, Message: request.Message, State: StateActive})
+		}}
+
+		schedule, err := NewClient(api).CreateSchedule("BotUser", ScheduleRequest{ChannelID: "TestChannel", Cron: "@daily", Message: "Hello"})
+		assert.NoError(t, err)
+		assert.Equal(t, &Schedule{ID: "schedule1", Creator: "BotUser", ChannelID: "TestChannel", Cron: "@daily", Message: "Hello", State: StateActive}, schedule)
+		assert.Equal(t, http.MethodPost, api.requests[0].Method)
+		assert.Equal(t, "/com.nilsbrinkmann.scheduler/api/v1/schedules", api.requests[0].URL.Path)
+	})
+	t.Run("Cancel schedule", func(t *testing.T) {
+		api := &fakeAPI{handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}}
+
+		assert.NoError(t, NewClient(api).CancelSchedule("BotUser", "schedule1"))
+		assert.Equal(t, http.MethodDelete, api.requests[0].Method)
+		assert.Equal(t, "/com.nilsbrinkmann.scheduler/api/v1/schedules/schedule1", api.requests[0].URL.Path)
+	})
+	t.Run("List schedules of a channel", func(t *testing.T) {
+		api := &fakeAPI{handler: func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode([]Schedule{{ID: "schedule1", ChannelID: r.URL.Query().Get("channel_id")}})
+		}}
+
+		schedules, err := NewClient(api).ListSchedules("BotUser", "TestChannel")
+		assert.NoError(t, err)
+		assert.Equal(t, []Schedule{{ID: "schedule1", ChannelID: "TestChannel"}}, schedules)
+	})
+	t.Run("Errors of the scheduler", func(t *testing.T) {
+		api := &fakeAPI{handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"You are not allowed to post in this channel"}`))
+		}}
+
+		_, err := NewClient(api).RunSchedule("BotUser", "schedule1")
+		assert.Equal(t, &Error{StatusCode: http.StatusForbidden, Message: "You are not allowed to post in this channel"}, err)
+	})
+	t.Run("Scheduler not installed", func(t *testing.T) {
+		api := &fakeAPI{handler: func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		}}
+
+		_, err := NewClient(api).GetSchedule("BotUser", "schedule1")
+		if assert.IsType(t, &Error{}, err) {
+			assert.Equal(t, http.StatusNotFound, err.(*Error).StatusCode)
+		}
+	})
+}
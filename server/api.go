@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	//routeAPI is the prefix of all routes of the REST API, the version is increased on incompatible changes
+	routeAPI          = "/api/v1"
+	routeAPISchedules = routeAPI + "/schedules"
+
+	apiActionPause  = "pause"
+	apiActionResume = "resume"
+	apiActionRun    = "run"
+)
+
+// apiSchedule is a schedule as it is returned by the REST API
+type apiSchedule struct {
+	ID        string `json:"id"`
+	Creator   string `json:"creator"`
+	ChannelID string `json:"channel_id"`
+	RootID    string `json:"root_id,omitempty"`
+	Cron      string `json:"cron"`
+	Message   string `json:"message"`
+	State     string `json:"state"`
+	Reason    string `json:"reason,omitempty"`
+	NextRun   int64  `json:"next_run,omitempty"` //time in millis of the next post, omitted if the schedule does not post
+}
+
+// apiScheduleRequest is the body of the requests creating or changing a schedule
+type apiScheduleRequest struct {
+	ChannelID string `json:"channel_id"`
+	RootID    string `json:"root_id"`
+	Cron      string `json:"cron"`
+	Timezone  string `json:"timezone"`
+	Message   string `json:"message"`
+}
+
+// apiRunResponse is returned when a schedule has been posted using the REST API
+type apiRunResponse struct {
+	PostID string `json:"post_id"`
+}
+
+// apiError is returned by the REST API whenever a request fails
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func newAPISchedule(msg ScheduledMessage) apiSchedule {
+	schedule := apiSchedule{
+		ID:        msg.ID,
+		Creator:   msg.Creator,
+		ChannelID: msg.ChannelID,
+		RootID:    msg.TeamID,
+		Cron:      msg.Cron,
+		Message:   msg.Message,
+		State:     msg.GetState(),
+		Reason:    msg.Reason,
+	}
+	if msg.GetState() == stateActive {
+		if runs, err := nextRuns(msg.Cron, time.Now(), 1); err == nil && len(runs) == 1 {
+			schedule.NextRun = toMillis(runs[0])
+		}
+	}
+	return schedule
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+// handleAPI serves the REST API. Requests are made on behalf of the user given by the Mattermost-User-Id header,
+// which is set by the Mattermost server for authenticated requests, so the same permissions as for the slash
+// commands apply.
+func (p *Plugin) handleAPI(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		writeAPIError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+
+	//the remaining path is empty, <id> or <id>/<action>
+	path := strings.TrimPrefix(r.URL.Path, routeAPISchedules)
+	if path != "" && !strings.HasPrefix(path, "/") {
+		writeAPIError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case path == "" || path == "/":
+		switch r.Method {
+		case http.MethodGet:
+			p.handleAPIListSchedules(w, r, userID)
+		case http.MethodPost:
+			p.handleAPICreateSchedule(w, r, userID)
+		default:
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet, http.MethodPut, http.MethodDelete:
+			p.handleAPISchedule(w, r, userID, parts[0])
+		default:
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	case len(parts) == 2 && containsString([]string{apiActionPause, apiActionResume, apiActionRun}, parts[1]):
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		p.handleAPIScheduleAction(w, userID, parts[0], parts[1])
+	default:
+		writeAPIError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// handleAPIListSchedules returns all schedules the user is allowed to see, optionally filtered by channel and state
+func (p *Plugin) handleAPIListSchedules(w http.ResponseWriter, r *http.Request, userID string) {
+	channelID := r.URL.Query().Get("channel_id")
+	state := r.URL.Query().Get("state")
+
+	data := p.ReadFromStorage()
+	visible := map[string]bool{}
+	schedules := []apiSchedule{}
+	for _, msg := range data.ScheduledMessages {
+		if channelID != "" && msg.ChannelID != channelID {
+			continue
+		}
+		if state != "" && msg.GetState() != state {
+			continue
+		}
+		if msg.Creator != userID {
+			if _, ok := visible[msg.ChannelID]; !ok {
+				visible[msg.ChannelID] = p.canView(userID, ScheduledMessage{ChannelID: msg.ChannelID})
+			}
+			if !visible[msg.ChannelID] {
+				continue
+			}
+		}
+		schedules = append(schedules, newAPISchedule(msg))
+	}
+	writeJSON(w, http.StatusOK, schedules)
+}
+
+// readAPIScheduleRequest reads and checks the body of a request creating or changing a schedule
+func readAPIScheduleRequest(r *http.Request) (*apiScheduleRequest, *scheduleError) {
+	request := &apiScheduleRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		return nil, newScheduleError(http.StatusBadRequest, "Invalid request body")
+	}
+	request.Cron = strings.TrimSpace(request.Cron)
+	if request.Cron == "" {
+		return nil, newScheduleError(http.StatusBadRequest, "Missing cron")
+	}
+	if strings.TrimSpace(request.Message) == "" {
+		return nil, newScheduleError(http.StatusBadRequest, "Missing message")
+	}
+	if !utf8.ValidString(request.Message) {
+		return nil, newScheduleError(http.StatusBadRequest, "Invalid characters in message")
+	}
+	if request.Timezone != "" {
+		cron, err := applyTimezone(request.Cron, request.Timezone)
+		if err != nil {
+			return nil, newScheduleError(http.StatusBadRequest, "Invalid timezone: %s", err.Error())
+		}
+		request.Cron = cron
+	}
+	return request, nil
+}
+
+func (p *Plugin) handleAPICreateSchedule(w http.ResponseWriter, r *http.Request, userID string) {
+	request, scheduleErr := readAPIScheduleRequest(r)
+	if scheduleErr == nil && request.ChannelID == "" {
+		scheduleErr = newScheduleError(http.StatusBadRequest, "Missing channel_id")
+	}
+	if scheduleErr != nil {
+		writeAPIError(w, scheduleErr.Status, scheduleErr.Message)
+		return
+	}
+
+	newMessage, scheduleErr := p.createSchedule(userID, request.ChannelID, request.RootID, request.Cron, request.Message)
+	if scheduleErr != nil {
+		writeAPIError(w, scheduleErr.Status, scheduleErr.Message)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newAPISchedule(*newMessage))
+}
+
+// handleAPISchedule returns, changes or removes a single schedule
+func (p *Plugin) handleAPISchedule(w http.ResponseWriter, r *http.Request, userID string, scheduleID string) {
+	data := p.ReadFromStorage()
+	index, scheduleErr := p.findPermittedSchedule(userID, scheduleID, data.ScheduledMessages, r.Method != http.MethodGet)
+	if scheduleErr != nil {
+		writeAPIError(w, scheduleErr.Status, scheduleErr.Message)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, newAPISchedule(data.ScheduledMessages[index]))
+	case http.MethodPut:
+		request, scheduleErr := readAPIScheduleRequest(r)
+		if scheduleErr != nil {
+			writeAPIError(w, scheduleErr.Status, scheduleErr.Message)
+			return
+		}
+		scheduledMsg, scheduleErr := p.updateSchedule(userID, scheduleID, request.Cron, request.Message)
+		if scheduleErr != nil {
+			writeAPIError(w, scheduleErr.Status, scheduleErr.Message)
+			return
+		}
+		writeJSON(w, http.StatusOK, newAPISchedule(*scheduledMsg))
+	case http.MethodDelete:
+		if scheduleErr := p.removeSchedule(userID, scheduleID); scheduleErr != nil {
+			writeAPIError(w, scheduleErr.Status, scheduleErr.Message)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleAPIScheduleAction pauses, resumes or runs a single schedule
+func (p *Plugin) handleAPIScheduleAction(w http.ResponseWriter, userID string, scheduleID string, action string) {
+	data := p.ReadFromStorage()
+	index, scheduleErr := p.findPermittedSchedule(userID, scheduleID, data.ScheduledMessages, true)
+	if scheduleErr != nil {
+		writeAPIError(w, scheduleErr.Status, scheduleErr.Message)
+		return
+	}
+
+	if action == apiActionRun {
+		scheduledMsg := data.ScheduledMessages[index]
+		if scheduleErr := checkRunnable(scheduledMsg); scheduleErr != nil {
+			writeAPIError(w, scheduleErr.Status, scheduleErr.Message)
+			return
+		}
+		createdPost, errResponse := p.postMessage(scheduledMsg, time.Now(), triggerManual)
+		if errResponse != nil {
+			writeAPIError(w, http.StatusBadGateway, strings.TrimPrefix(errResponse.Text, "Error: "))
+			return
+		}
+		writeJSON(w, http.StatusOK, apiRunResponse{PostID: createdPost.Id})
+		return
+	}
+
+	scheduledMsg, scheduleErr := p.setSchedulePaused(userID, scheduleID, action == apiActionPause)
+	if scheduleErr != nil {
+		writeAPIError(w, scheduleErr.Status, scheduleErr.Message)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPISchedule(*scheduledMsg))
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/client"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAPI(t *testing.T) {
+	schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+		ScheduledMessage{ID: "own", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@daily", Message: "Hello"},
+		ScheduledMessage{ID: "other", Creator: "OtherUser", ChannelID: "TestChannel", Cron: "@weekly", Message: "Weekly", State: statePaused},
+		ScheduledMessage{ID: "hidden", Creator: "OtherUser", ChannelID: "PrivateChannel", Cron: "@daily", Message: "Secret"},
+	}}
+	reqBodyBytes := new(bytes.Buffer)
+	json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+	setupPlugin := func() (*Plugin, *plugintest.API) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		expectSchedulerStorage(api)
+		api.On("KVSetWithOptions", KVKEY, mock.Anything, mock.Anything).Return(true, nil)
+		expectAudit(api)
+		api.On("HasPermissionTo", mock.AnythingOfType("string"), model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		api.On("HasPermissionToChannel", "TestUser", "TestChannel", model.PERMISSION_CREATE_POST).Return(true)
+		api.On("HasPermissionToChannel", "TestUser", "PrivateChannel", model.PERMISSION_CREATE_POST).Return(false)
+		api.On("HasPermissionToChannel", "TestUser", mock.AnythingOfType("string"), model.PERMISSION_MANAGE_CHANNEL_ROLES).Return(false)
+		api.On("GetChannelMember", "TestChannel", "TestUser").Return(&model.ChannelMember{}, nil)
+		api.On("GetChannelMember", "PrivateChannel", "TestUser").Return(nil, &model.AppError{StatusCode: http.StatusNotFound})
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", Name: "town-square", Type: model.CHANNEL_OPEN}, nil)
+		plugin.SetAPI(api)
+		return plugin, api
+	}
+	request := func(plugin *Plugin, userID string, method string, path string, body interface{}) *httptest.ResponseRecorder {
+		var reader *bytes.Reader
+		if body != nil {
+			content, _ := json.Marshal(body)
+			reader = bytes.NewReader(content)
+		} else {
+			reader = bytes.NewReader(nil)
+		}
+		r := httptest.NewRequest(method, path, reader)
+		if userID != "" {
+			r.Header.Set("Mattermost-User-Id", userID)
+		}
+		w := httptest.NewRecorder()
+		plugin.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		w := request(plugin, "", http.MethodGet, routeAPISchedules, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+	t.Run("List only visible schedules", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		w := request(plugin, "TestUser", http.MethodGet, routeAPISchedules, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		schedules := []apiSchedule{}
+		json.NewDecoder(w.Body).Decode(&schedules)
+		if assert.Len(t, schedules, 2) {
+			assert.Equal(t, "own", schedules[0].ID)
+			assert.NotZero(t, schedules[0].NextRun)
+			assert.Equal(t, statePaused, schedules[1].State)
+			assert.Zero(t, schedules[1].NextRun)
+		}
+
+		w = request(plugin, "TestUser", http.MethodGet, routeAPISchedules+"?state=paused", nil)
+		json.NewDecoder(w.Body).Decode(&schedules)
+		assert.Len(t, schedules, 1)
+	})
+	t.Run("Get hidden schedule", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		w := request(plugin, "TestUser", http.MethodGet, routeAPISchedules+"/hidden", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+	t.Run("Create", func(t *testing.T) {
+		plugin, api := setupPlugin()
+		w := request(plugin, "TestUser", http.MethodPost, routeAPISchedules, apiScheduleRequest{
+			ChannelID: "TestChannel", Cron: "0 0 9 * * MON", Timezone: "Europe/Berlin", Message: "Good morning",
+		})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		schedule := apiSchedule{}
+		json.NewDecoder(w.Body).Decode(&schedule)
+		assert.Equal(t, "CRON_TZ=Europe/Berlin 0 0 9 * * MON", schedule.Cron)
+		assert.Equal(t, "TestUser", schedule.Creator)
+		//the new schedule is registered together with the stored active ones
+		assert.Contains(t, plugin.registry, schedule.ID)
+		assert.Len(t, plugin.registry, 3)
+		api.AssertCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
+	})
+	t.Run("Create in channel without post permission", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		w := request(plugin, "TestUser", http.MethodPost, routeAPISchedules, apiScheduleRequest{
+			ChannelID: "PrivateChannel", Cron: "@daily", Message: "Hello",
+		})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "You are not allowed to post in this channel")
+	})
+	t.Run("Create with invalid cron", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		w := request(plugin, "TestUser", http.MethodPost, routeAPISchedules, apiScheduleRequest{
+			ChannelID: "TestChannel", Cron: "every monday", Message: "Hello",
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("Update", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		w := request(plugin, "TestUser", http.MethodPut, routeAPISchedules+"/own", apiScheduleRequest{Cron: "@hourly", Message: "Changed"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		schedule := apiSchedule{}
+		json.NewDecoder(w.Body).Decode(&schedule)
+		assert.Equal(t, "@hourly", schedule.Cron)
+		assert.Equal(t, "Changed", schedule.Message)
+	})
+	t.Run("Cannot change schedules of others", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		w := request(plugin, "TestUser", http.MethodDelete, routeAPISchedules+"/other", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w = request(plugin, "TestUser", http.MethodPost, routeAPISchedules+"/other/resume", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+	t.Run("Delete", func(t *testing.T) {
+		plugin, api := setupPlugin()
+		api.On("KVDelete", HISTORYKEYPREFIX+"own").Return(nil)
+		w := request(plugin, "TestUser", http.MethodDelete, routeAPISchedules+"/own", nil)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		api.AssertCalled(t, "KVDelete", HISTORYKEYPREFIX+"own")
+	})
+	t.Run("Pause and resume", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		w := request(plugin, "TestUser", http.MethodPost, routeAPISchedules+"/own/pause", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"state":"paused"`)
+
+		//the stored schedule is still active, so it cannot be resumed
+		w = request(plugin, "TestUser", http.MethodPost, routeAPISchedules+"/own/resume", nil)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+	t.Run("Run", func(t *testing.T) {
+		plugin, api := setupPlugin()
+		api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{Id: "CreatedPost"}, nil)
+		api.On("KVGet", HISTORYKEYPREFIX+"own").Return(nil, nil)
+		api.On("KVSetWithOptions", HISTORYKEYPREFIX+"own", mock.Anything, mock.Anything).Return(true, nil)
+		w := request(plugin, "TestUser", http.MethodPost, routeAPISchedules+"/own/run", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `{"post_id":"CreatedPost"}`, strings.TrimSpace(w.Body.String()))
+	})
+	t.Run("Unknown routes", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		assert.Equal(t, http.StatusNotFound, request(plugin, "TestUser", http.MethodPost, routeAPISchedules+"/own/rename", nil).Code)
+		assert.Equal(t, http.StatusMethodNotAllowed, request(plugin, "TestUser", http.MethodPatch, routeAPISchedules, nil).Code)
+		assert.Equal(t, http.StatusNotFound, request(plugin, "TestUser", http.MethodGet, routeAPI+"/other", nil).Code)
+	})
+}
+
+// interPluginAPI forwards requests to the given plugin like the PluginHTTP method of the server does
+type interPluginAPI struct {
+	plugin *Plugin
+}
+
+func (api *interPluginAPI) PluginHTTP(request *http.Request) *http.Response {
+	request.URL.Path = strings.TrimPrefix(request.URL.Path, "/"+manifest.Id)
+	w := httptest.NewRecorder()
+	api.plugin.ServeHTTP(&plugin.Context{SourcePluginId: "com.example.standup"}, w, request)
+	return w.Result()
+}
+
+func TestInterPluginAPI(t *testing.T) {
+	schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{}}
+	reqBodyBytes := new(bytes.Buffer)
+	json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+	p := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+	api := &plugintest.API{}
+	api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+	expectSchedulerStorage(api)
+	api.On("KVSetWithOptions", KVKEY, mock.Anything, mock.Anything).Return(true, nil)
+	expectAudit(api)
+	api.On("HasPermissionToChannel", "StandupBot", "TestChannel", model.PERMISSION_CREATE_POST).Return(true)
+	api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", Name: "town-square", Type: model.CHANNEL_OPEN}, nil)
+	p.SetAPI(api)
+
+	scheduler := client.NewClient(&interPluginAPI{plugin: p})
+	schedule, err := scheduler.CreateSchedule("StandupBot", client.ScheduleRequest{ChannelID: "TestChannel", Cron: "0 0 9 * * MON-FRI", Message: "Standup!"})
+	assert.NoError(t, err)
+	assert.Equal(t, "StandupBot", schedule.Creator)
+	assert.Equal(t, client.StateActive, schedule.State)
+	assert.NotZero(t, schedule.NextRun)
+
+	_, err = scheduler.CreateSchedule("StandupBot", client.ScheduleRequest{ChannelID: "TestChannel", Cron: "0 0 9 * * MON-FRI"})
+	assert.Equal(t, &client.Error{StatusCode: http.StatusBadRequest, Message: "Missing message"}, err)
+}
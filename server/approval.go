@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	routeApproval = "/approval"
+
+	approvalActionApprove = "approve"
+	approvalActionReject  = "reject"
+
+	//reasonPendingApproval is shown for schedules waiting for the approval of a channel admin
+	reasonPendingApproval = "Waiting for the approval of a channel admin"
+
+	//noApproversMessage is returned if a schedule needs an approval, but there is nobody to ask for it
+	noApproversMessage = "There is nobody who could approve schedules of this channel, please ask a system admin for help"
+)
+
+// isChannelAdmin tells whether the given user is an admin of the given channel, which includes team and system admins
+func (p *Plugin) isChannelAdmin(userID string, channelID string) bool {
+	return p.isSystemAdmin(userID) || p.API.HasPermissionToChannel(userID, channelID, model.PERMISSION_MANAGE_CHANNEL_ROLES)
+}
+
+// requiresApproval tells whether new or changed schedules of the given channel have to be approved by a channel admin
+func (p *Plugin) requiresApproval(channelID string) bool {
+	approvalChannels := splitList(p.getConfiguration().ApprovalChannels)
+	if len(approvalChannels) == 0 {
+		return false
+	}
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return false
+	}
+	return containsString(approvalChannels, strings.ToLower(channel.Name))
+}
+
+// needsApproval tells whether a schedule of the given channel created or changed by the given user has to be approved
+func (p *Plugin) needsApproval(userID string, channelID string) bool {
+	return p.requiresApproval(channelID) && !p.isChannelAdmin(userID, channelID)
+}
+
+// approvalRevision identifies the content of the given schedule an approval request has been sent for, so a click on
+// the request is rejected once the schedule has been changed
+func approvalRevision(msg ScheduledMessage) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(msg.ChannelID+"\n"+msg.Cron+"\n"+msg.Message)))
+}
+
+// findApprovers returns the IDs of the users who are asked to approve schedules of the given channel. These are the
+// channel admins, the team admins if the channel has none and the system admins if the team has none either.
+func (p *Plugin) findApprovers(channelID string) []string {
+	if admins := p.findChannelAdmins(channelID); len(admins) > 0 {
+		return admins
+	}
+
+	if channel, appErr := p.API.GetChannel(channelID); appErr == nil && channel.TeamId != "" {
+		admins := []string{}
+		for page := 0; ; page++ {
+			members, appErr := p.API.GetTeamMembers(channel.TeamId, page, channelMembersPerPage)
+			if appErr != nil {
+				break
+			}
+			for _, member := range members {
+				if !member.SchemeAdmin {
+					continue
+				}
+				if user, appErr := p.API.GetUser(member.UserId); appErr == nil && user.DeleteAt == 0 && !user.IsBot {
+					admins = append(admins, member.UserId)
+				}
+			}
+			if len(members) < channelMembersPerPage {
+				break
+			}
+		}
+		if len(admins) > 0 {
+			return admins
+		}
+	}
+
+	admins := []string{}
+	for page := 0; ; page++ {
+		users, appErr := p.API.GetUsers(&model.UserGetOptions{Role: model.SYSTEM_ADMIN_ROLE_ID, Page: page, PerPage: channelMembersPerPage})
+		if appErr != nil {
+			break
+		}
+		for _, user := range users {
+			if user.DeleteAt == 0 && !user.IsBot {
+				admins = append(admins, user.Id)
+			}
+		}
+		if len(users) < channelMembersPerPage {
+			break
+		}
+	}
+	return admins
+}
+
+// requestApproval asks the given approvers to approve or reject the given schedule
+func (p *Plugin) requestApproval(msg ScheduledMessage, approvers []string) {
+	actionURL := "/plugins/" + manifest.Id + routeApproval
+	if config := p.API.GetConfig(); config != nil && config.ServiceSettings.SiteURL != nil {
+		actionURL = strings.TrimSuffix(*config.ServiceSettings.SiteURL, "/") + actionURL
+	}
+	newAction := func(name string, action string) *model.PostAction {
+		return &model.PostAction{
+			Name: name,
+			Type: model.POST_ACTION_TYPE_BUTTON,
+			Integration: &model.PostActionIntegration{
+				URL: actionURL,
+				Context: map[string]interface{}{
+					"action":      action,
+					"schedule_id": msg.ID,
+					"revision":    approvalRevision(msg),
+				},
+			},
+		}
+	}
+
+	channelName := msg.ChannelID
+	if channel, appErr := p.API.GetChannel(msg.ChannelID); appErr == nil {
+		channelName = "~" + channel.Name
+	}
+	text := fmt.Sprintf("@%s wants to schedule a message in %s.\n* **Cron:** `%s`\n* **Recurrence:** %s\n\n**Message preview:**\n> %s",
+		p.getUsername(msg.Creator), channelName, msg.Cron, describeCron(msg.Cron), strings.Replace(msg.Message, "\n", "\n> ", -1))
+
+	for _, admin := range approvers {
+		channel, appErr := p.API.GetDirectChannel(admin, p.botUserID)
+		if appErr != nil {
+			p.API.LogError("Failed to get direct channel", "user", admin, "err", appErr.Error())
+			continue
+		}
+		post := &model.Post{
+			ChannelId: channel.Id,
+			UserId:    p.botUserID,
+		}
+		model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+			Title:   fmt.Sprintf("Approval requested for scheduled message %s", msg.ID),
+			Text:    text,
+			Actions: []*model.PostAction{newAction("Approve", approvalActionApprove), newAction("Reject", approvalActionReject)},
+		}})
+		if _, appErr := p.API.CreatePost(post); appErr != nil {
+			p.API.LogError("Failed to request approval", "user", admin, "err", appErr.Error())
+		}
+	}
+}
+
+// handleApproval is called when a channel admin clicks on one of the buttons of an approval request
+func (p *Plugin) handleApproval(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	request := model.PostActionIntegrationRequestFromJson(r.Body)
+	if userID == "" || request == nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	action, _ := request.Context["action"].(string)
+	scheduleID, _ := request.Context["schedule_id"].(string)
+	revision, _ := request.Context["revision"].(string)
+
+	respond := func(text string) {
+		response := &model.PostActionIntegrationResponse{EphemeralText: text}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response.ToJson())
+	}
+
+	if action != approvalActionApprove && action != approvalActionReject {
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+
+	username := p.getUsername(userID)
+	var before, scheduledMsg ScheduledMessage
+	var result string
+	scheduleErr := p.changeSchedule(scheduleID, func(data *SchedulerData, index int) *scheduleError {
+		scheduledMsg = data.ScheduledMessages[index]
+		before = scheduledMsg
+		if !p.isChannelAdmin(userID, scheduledMsg.ChannelID) {
+			return newScheduleError(http.StatusForbidden, "Only channel admins can approve scheduled messages.")
+		}
+		if scheduledMsg.GetState() != statePending {
+			return newScheduleError(http.StatusConflict, "The scheduled message %s is not waiting for approval anymore.", scheduleID)
+		}
+		//the schedule has been edited after this request was sent, a new request has been sent for the changed content
+		if revision != approvalRevision(scheduledMsg) {
+			return newScheduleError(http.StatusConflict, "The scheduled message %s has been changed since this approval was requested, please use the latest request.", scheduleID)
+		}
+
+		if action == approvalActionApprove {
+			//the restrictions may have changed while the schedule was waiting
+			if err := p.checkDestination(scheduledMsg.ChannelID); err != nil {
+				return newScheduleError(http.StatusForbidden, "The scheduled message %s cannot be approved, %s.", scheduleID, err.Error())
+			}
+			if _, err := parseRecurrence(scheduledMsg.Cron); err != nil {
+				return newScheduleError(http.StatusBadRequest, "The scheduled message %s cannot be approved, its cron-syntax is invalid.", scheduleID)
+			}
+			scheduledMsg.State = stateActive
+			scheduledMsg.Reason = ""
+			result = fmt.Sprintf("approved by @%s", username)
+		} else {
+			scheduledMsg.State = stateDisabled
+			scheduledMsg.Reason = fmt.Sprintf("Rejected by @%s", username)
+			result = fmt.Sprintf("rejected by @%s", username)
+		}
+		data.ScheduledMessages[index] = scheduledMsg
+		return nil
+	})
+	if scheduleErr != nil {
+		if scheduleErr.Status == http.StatusNotFound {
+			respond(fmt.Sprintf("The scheduled message %s does not exist anymore.", scheduleID))
+			return
+		}
+		respond(scheduleErr.Message)
+		return
+	}
+	if action == approvalActionApprove {
+		p.recordAudit(userID, auditActionApprove, &before, &scheduledMsg, "")
+	} else {
+		p.recordAudit(userID, auditActionReject, &before, &scheduledMsg, "")
+	}
+	p.notifyUsers([]string{scheduledMsg.Creator}, fmt.Sprintf("Your scheduled message %s has been %s.", scheduleID, result))
+
+	//replace the buttons with the decision, other admins are told when they click on their buttons
+	update := &model.Post{}
+	model.ParseSlackAttachment(update, []*model.SlackAttachment{{
+		Title: fmt.Sprintf("Scheduled message %s has been %s", scheduleID, result),
+	}})
+	response := &model.PostActionIntegrationResponse{Update: update}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response.ToJson())
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestApproval(t *testing.T) {
+	setupAPI := func(schedulerData *SchedulerData) *plugintest.API {
+		reqBodyBytes := new(bytes.Buffer)
+		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("GetChannel", "ModeratedChannel").Return(&model.Channel{Id: "ModeratedChannel", Name: "announcements", Type: model.CHANNEL_OPEN}, nil)
+		api.On("HasPermissionTo", mock.AnythingOfType("string"), model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		api.On("HasPermissionToChannel", "TestUser", "ModeratedChannel", model.PERMISSION_CREATE_POST).Return(true)
+		api.On("HasPermissionToChannel", "TestUser", "ModeratedChannel", model.PERMISSION_MANAGE_CHANNEL_ROLES).Return(false)
+		api.On("HasPermissionToChannel", "AdminUser", "ModeratedChannel", model.PERMISSION_MANAGE_CHANNEL_ROLES).Return(true)
+		api.On("GetUser", "TestUser").Return(&model.User{Id: "TestUser", Username: "tester"}, nil)
+		api.On("GetUser", "AdminUser").Return(&model.User{Id: "AdminUser", Username: "admin"}, nil)
+		api.On("GetDirectChannel", mock.AnythingOfType("string"), "BotUser").Return(&model.Channel{Id: "DirectChannel"}, nil)
+		api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+		return api
+	}
+	newPlugin := func(api *plugintest.API) *Plugin {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds()), botUserID: "BotUser"}
+		plugin.SetAPI(api)
+		plugin.setConfiguration(&configuration{ApprovalChannels: "announcements, ~Releases"})
+		return plugin
+	}
+	clickRevision := func(plugin *Plugin, userID string, action string, revision string) *model.PostActionIntegrationResponse {
+		request := &model.PostActionIntegrationRequest{
+			UserId:  userID,
+			Context: map[string]interface{}{"action": action, "schedule_id": "pending", "revision": revision},
+		}
+		r := httptest.NewRequest(http.MethodPost, routeApproval, bytes.NewReader(request.ToJson()))
+		r.Header.Set("Mattermost-User-Id", userID)
+		w := httptest.NewRecorder()
+		plugin.ServeHTTP(nil, w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		response := &model.PostActionIntegrationResponse{}
+		json.NewDecoder(w.Body).Decode(response)
+		return response
+	}
+	pendingData := func() *SchedulerData {
+		return &SchedulerData{ScheduledMessages: []ScheduledMessage{
+			ScheduledMessage{ID: "pending", Creator: "TestUser", ChannelID: "ModeratedChannel", Cron: "@daily", Message: "Hello", State: statePending, Reason: reasonPendingApproval},
+		}}
+	}
+	click := func(plugin *Plugin, userID string, action string) *model.PostActionIntegrationResponse {
+		return clickRevision(plugin, userID, action, approvalRevision(pendingData().ScheduledMessages[0]))
+	}
+
+	t.Run("Adding to a moderated channel requests approval", func(t *testing.T) {
+		api := setupAPI(&SchedulerData{ScheduledMessages: []ScheduledMessage{}})
+		api.On("GetConfig").Return(&model.Config{})
+		api.On("GetChannelMembers", "ModeratedChannel", 0, channelMembersPerPage).Return(&model.ChannelMembers{
+			model.ChannelMember{UserId: "TestUser"},
+			model.ChannelMember{UserId: "AdminUser", SchemeAdmin: true},
+		}, nil)
+		expectAudit(api)
+		expectSchedulerStorage(api)
+		api.On("KVSetWithOptions", KVKEY, mock.MatchedBy(func(value []byte) bool {
+			data := SchedulerData{}
+			json.Unmarshal(value, &data)
+			return len(data.ScheduledMessages) == 1 && data.ScheduledMessages[0].GetState() == statePending
+		}), mock.Anything).Return(true, nil)
+		plugin := newPlugin(api)
+
+		args := &model.CommandArgs{
+			Command:   "/scheduler add @daily: Hello",
+			ChannelId: "ModeratedChannel",
+			UserId:    "TestUser",
+		}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Contains(t, result.Text, "It is posted once a channel admin approves it.")
+		assert.Empty(t, plugin.registry)
+		api.AssertCalled(t, "GetDirectChannel", "AdminUser", "BotUser")
+		api.AssertCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
+	})
+	t.Run("Adding fails if nobody can approve", func(t *testing.T) {
+		api := setupAPI(&SchedulerData{ScheduledMessages: []ScheduledMessage{}})
+		api.On("GetChannelMembers", "ModeratedChannel", 0, channelMembersPerPage).Return(&model.ChannelMembers{
+			model.ChannelMember{UserId: "TestUser"},
+		}, nil)
+		api.On("GetUsers", mock.AnythingOfType("*model.UserGetOptions")).Return([]*model.User{}, nil)
+		plugin := newPlugin(api)
+
+		args := &model.CommandArgs{
+			Command:   "/scheduler add @daily: Hello",
+			ChannelId: "ModeratedChannel",
+			UserId:    "TestUser",
+		}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Error: "+noApproversMessage, result.Text)
+		api.AssertNotCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+	})
+	t.Run("Approve", func(t *testing.T) {
+		api := setupAPI(pendingData())
+		expectAudit(api)
+		expectSchedulerStorage(api)
+		api.On("KVSetWithOptions", KVKEY, mock.MatchedBy(func(value []byte) bool {
+			data := SchedulerData{}
+			json.Unmarshal(value, &data)
+			return data.ScheduledMessages[0].GetState() == stateActive && data.ScheduledMessages[0].Reason == ""
+		}), mock.Anything).Return(true, nil)
+		plugin := newPlugin(api)
+
+		response := click(plugin, "AdminUser", approvalActionApprove)
+		assert.NotNil(t, response.Update)
+		assert.Len(t, plugin.registry, 1)
+		api.AssertCalled(t, "GetDirectChannel", "TestUser", "BotUser")
+		api.AssertCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
+	})
+	t.Run("Reject", func(t *testing.T) {
+		api := setupAPI(pendingData())
+		expectAudit(api)
+		expectSchedulerStorage(api)
+		api.On("KVSetWithOptions", KVKEY, mock.MatchedBy(func(value []byte) bool {
+			data := SchedulerData{}
+			json.Unmarshal(value, &data)
+			return data.ScheduledMessages[0].GetState() == stateDisabled && data.ScheduledMessages[0].Reason == "Rejected by @admin"
+		}), mock.Anything).Return(true, nil)
+		plugin := newPlugin(api)
+
+		click(plugin, "AdminUser", approvalActionReject)
+		assert.Empty(t, plugin.registry)
+		api.AssertCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
+	})
+	t.Run("Only channel admins can approve", func(t *testing.T) {
+		api := setupAPI(pendingData())
+		plugin := newPlugin(api)
+
+		response := click(plugin, "TestUser", approvalActionApprove)
+		assert.Equal(t, "Only channel admins can approve scheduled messages.", response.EphemeralText)
+		api.AssertNotCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
+	})
+	t.Run("Requests for changed schedules cannot be approved", func(t *testing.T) {
+		api := setupAPI(pendingData())
+		plugin := newPlugin(api)
+
+		before := ScheduledMessage{ID: "pending", ChannelID: "ModeratedChannel", Cron: "@daily", Message: "Hello before the edit"}
+		response := clickRevision(plugin, "AdminUser", approvalActionApprove, approvalRevision(before))
+		assert.Equal(t, "The scheduled message pending has been changed since this approval was requested, please use the latest request.", response.EphemeralText)
+		assert.Empty(t, plugin.registry)
+		api.AssertNotCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
+	})
+	t.Run("Editing requests a new approval", func(t *testing.T) {
+		api := setupAPI(pendingData())
+		api.On("GetConfig").Return(&model.Config{})
+		api.On("GetChannelMember", "ModeratedChannel", "TestUser").Return(&model.ChannelMember{}, nil)
+		api.On("GetChannelMembers", "ModeratedChannel", 0, channelMembersPerPage).Return(&model.ChannelMembers{
+			model.ChannelMember{UserId: "AdminUser", SchemeAdmin: true},
+		}, nil)
+		expectAudit(api)
+		expectSchedulerStorage(api)
+		api.On("KVSetWithOptions", KVKEY, mock.Anything, mock.Anything).Return(true, nil)
+		plugin := newPlugin(api)
+
+		args := &model.CommandArgs{Command: "/scheduler edit pending @hourly: Changed", ChannelId: "ModeratedChannel", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Changed the scheduled message pending! It is posted once a channel admin approves the changes.", result.Text)
+		changed := ScheduledMessage{ID: "pending", ChannelID: "ModeratedChannel", Cron: "@hourly", Message: "Changed"}
+		api.AssertCalled(t, "CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			attachments := post.Attachments()
+			if len(attachments) != 1 || len(attachments[0].Actions) != 2 {
+				return false
+			}
+			return attachments[0].Actions[0].Integration.Context["revision"] == approvalRevision(changed)
+		}))
+	})
+	t.Run("Edits by channel admins clear the pending approval", func(t *testing.T) {
+		api := setupAPI(pendingData())
+		api.On("GetChannelMember", "ModeratedChannel", "AdminUser").Return(&model.ChannelMember{}, nil)
+		expectAudit(api)
+		expectSchedulerStorage(api)
+		api.On("KVSetWithOptions", KVKEY, mock.MatchedBy(func(value []byte) bool {
+			data := SchedulerData{}
+			json.Unmarshal(value, &data)
+			return data.ScheduledMessages[0].GetState() == stateActive && data.ScheduledMessages[0].Reason == ""
+		}), mock.Anything).Return(true, nil)
+		plugin := newPlugin(api)
+
+		args := &model.CommandArgs{Command: "/scheduler edit pending @hourly: Changed", ChannelId: "ModeratedChannel", UserId: "AdminUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Changed the scheduled message pending!", result.Text)
+		assert.Len(t, plugin.registry, 1)
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+	})
+}
+
+func TestFindApprovers(t *testing.T) {
+	setupAPI := func(channelAdmin bool, teamAdmin bool) *plugintest.API {
+		api := &plugintest.API{}
+		api.On("GetChannelMembers", "TeamChannel", 0, channelMembersPerPage).Return(&model.ChannelMembers{
+			model.ChannelMember{UserId: "TestUser"},
+			model.ChannelMember{UserId: "ChannelAdmin", SchemeAdmin: channelAdmin},
+		}, nil)
+		api.On("GetChannel", "TeamChannel").Return(&model.Channel{Id: "TeamChannel", TeamId: "TestTeam"}, nil)
+		api.On("GetTeamMembers", "TestTeam", 0, channelMembersPerPage).Return([]*model.TeamMember{
+			&model.TeamMember{UserId: "TestUser"},
+			&model.TeamMember{UserId: "TeamAdmin", SchemeAdmin: teamAdmin},
+		}, nil)
+		api.On("GetUser", mock.AnythingOfType("string")).Return(func(userID string) *model.User {
+			return &model.User{Id: userID}
+		}, nil)
+		api.On("GetUsers", &model.UserGetOptions{Role: model.SYSTEM_ADMIN_ROLE_ID, Page: 0, PerPage: channelMembersPerPage}).Return([]*model.User{
+			&model.User{Id: "SystemAdmin"},
+			&model.User{Id: "DeactivatedAdmin", DeleteAt: 1},
+		}, nil)
+		return api
+	}
+
+	t.Run("Channel admins", func(t *testing.T) {
+		plugin := &Plugin{}
+		plugin.SetAPI(setupAPI(true, true))
+		assert.Equal(t, []string{"ChannelAdmin"}, plugin.findApprovers("TeamChannel"))
+	})
+	t.Run("Team admins if the channel has no admins", func(t *testing.T) {
+		plugin := &Plugin{}
+		plugin.SetAPI(setupAPI(false, true))
+		assert.Equal(t, []string{"TeamAdmin"}, plugin.findApprovers("TeamChannel"))
+	})
+	t.Run("System admins if the team has no admins either", func(t *testing.T) {
+		plugin := &Plugin{}
+		plugin.SetAPI(setupAPI(false, false))
+		assert.Equal(t, []string{"SystemAdmin"}, plugin.findApprovers("TeamChannel"))
+	})
+}
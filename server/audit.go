@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	auditActionCreate   = "create"
+	auditActionEdit     = "edit"
+	auditActionRemove   = "remove"
+	auditActionTransfer = "transfer"
+	auditActionPause    = "pause"
+	auditActionResume   = "resume"
+	auditActionApprove  = "approve"
+	auditActionReject   = "reject"
+	auditActionDisable  = "disable"
+	auditActionDelete   = "delete"
+
+	//auditActorSystem is recorded as the actor of changes the plugin makes on its own, e.g. during the integrity sweep
+	auditActorSystem = "system"
+
+	optionID      = "id"
+	optionActor   = "actor"
+	optionAction  = "action"
+	optionChannel = "channel"
+	optionSince   = "since"
+	optionFormat  = "format"
+
+	auditFormatCSV  = "csv"
+	auditFormatJSON = "json"
+
+	//auditDateFormat is used for the --since option of the audit command
+	auditDateFormat = "2006-01-02"
+)
+
+var auditActions = []string{auditActionCreate, auditActionEdit, auditActionRemove, auditActionTransfer,
+	auditActionPause, auditActionResume, auditActionApprove, auditActionReject, auditActionDisable, auditActionDelete}
+
+// AuditEntry records a single change of a schedule. Entries are never changed or removed once they are written.
+type AuditEntry struct {
+	Timestamp  int64             `json:"timestamp"` //time in millis the change happened
+	ActorID    string            `json:"actorID"`   //user who made the change, or auditActorSystem
+	Action     string            `json:"action"`
+	ScheduleID string            `json:"scheduleID"`
+	ChannelID  string            `json:"channelID"`
+	Before     *ScheduledMessage `json:"before,omitempty"` //nil if the schedule has been created
+	After      *ScheduledMessage `json:"after,omitempty"`  //nil if the schedule has been removed
+	Note       string            `json:"note,omitempty"`
+}
+
+// auditFilter describes which entries the audit command shows
+type auditFilter struct {
+	ScheduleID string
+	ActorID    string
+	Action     string
+	ChannelID  string
+	Since      time.Time
+	Format     string
+	Page       int
+}
+
+// recordAudit adds an entry to the audit log. Pass nil as before when a schedule is created and nil as after when
+// it is removed.
+func (p *Plugin) recordAudit(actorID string, action string, before *ScheduledMessage, after *ScheduledMessage, note string) {
+	entry := AuditEntry{
+		Timestamp: toMillis(time.Now()),
+		ActorID:   actorID,
+		Action:    action,
+		Note:      note,
+	}
+	//copy the schedules, so later changes by the caller are not recorded
+	if before != nil {
+		beforeCopy := *before
+		entry.Before = &beforeCopy
+		entry.ScheduleID = before.ID
+		entry.ChannelID = before.ChannelID
+	}
+	if after != nil {
+		afterCopy := *after
+		entry.After = &afterCopy
+		entry.ScheduleID = after.ID
+		entry.ChannelID = after.ChannelID
+	}
+	if err := p.WriteAuditEntryToStorage(entry); err != nil {
+		p.API.LogError("Failed to record audit entry", "id", entry.ScheduleID, "action", action, "err", err.Error())
+	}
+}
+
+// parseAuditFilter reads the filter from the arguments given to the audit command
+func (p *Plugin) parseAuditFilter(text string, teamID string) (*auditFilter, error) {
+	arguments, options, err := parseArguments(text, optionID, optionActor, optionAction, optionChannel, optionSince, optionFormat, optionPage)
+	if err != nil {
+		return nil, err
+	}
+	if len(arguments) > 0 {
+		return nil, errors.Errorf("unexpected argument %s", arguments[0])
+	}
+
+	filter := &auditFilter{ScheduleID: options[optionID], Page: 1}
+	if actor, ok := options[optionActor]; ok {
+		if actor == auditActorSystem {
+			filter.ActorID = auditActorSystem
+		} else {
+			user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(actor, "@"))
+			if appErr != nil {
+				return nil, errors.Errorf("unknown user %s", actor)
+			}
+			filter.ActorID = user.Id
+		}
+	}
+	if action, ok := options[optionAction]; ok {
+		if !containsString(auditActions, action) {
+			return nil, errors.Errorf("unknown action %s", action)
+		}
+		filter.Action = action
+	}
+	if channelName, ok := options[optionChannel]; ok {
+		channel, appErr := p.API.GetChannelByName(teamID, strings.ToLower(strings.TrimPrefix(channelName, "~")), false)
+		if appErr != nil {
+			return nil, errors.Errorf("unknown channel %s", channelName)
+		}
+		filter.ChannelID = channel.Id
+	}
+	if since, ok := options[optionSince]; ok {
+		filter.Since, err = time.Parse(auditDateFormat, since)
+		if err != nil {
+			return nil, errors.Errorf("invalid date %s, please use the format YYYY-MM-DD", since)
+		}
+	}
+	if format, ok := options[optionFormat]; ok {
+		if format != auditFormatCSV && format != auditFormatJSON {
+			return nil, errors.Errorf("unknown format %s", format)
+		}
+		filter.Format = format
+	}
+	if page, ok := options[optionPage]; ok {
+		filter.Page, err = strconv.Atoi(page)
+		if err != nil || filter.Page < 1 {
+			return nil, errors.Errorf("invalid page %s", page)
+		}
+	}
+
+	return filter, nil
+}
+
+// matchesAuditFilter tells whether the given entry matches the given filter, apart from its date
+func matchesAuditFilter(entry AuditEntry, filter *auditFilter) bool {
+	return (filter.ScheduleID == "" || entry.ScheduleID == filter.ScheduleID) &&
+		(filter.ActorID == "" || entry.ActorID == filter.ActorID) &&
+		(filter.Action == "" || entry.Action == filter.Action) &&
+		(filter.ChannelID == "" || entry.ChannelID == filter.ChannelID)
+}
+
+// readAuditEntries returns the entries matching the given filter, the most recent entry first. It stops reading once
+// it found the given number of entries, 0 reads all of them, and tells whether there are more matching entries.
+func (p *Plugin) readAuditEntries(filter *auditFilter, limit int) ([]AuditEntry, bool) {
+	since := toMillis(filter.Since)
+	result := []AuditEntry{}
+	more := false
+	p.ReadAuditFromStorage(func(entry AuditEntry) bool {
+		//the entries are read in the order they have been recorded, so all following entries are older
+		if !filter.Since.IsZero() && entry.Timestamp < since {
+			return false
+		}
+		if !matchesAuditFilter(entry, filter) {
+			return true
+		}
+		if limit > 0 && len(result) == limit {
+			more = true
+			return false
+		}
+		result = append(result, entry)
+		return true
+	})
+	return result, more
+}
+
+// describeChanges lists the fields that differ between the given versions of a schedule, each value is passed
+// through the given format function
+func (p *Plugin) describeChanges(before *ScheduledMessage, after *ScheduledMessage, format func(string) string) []string {
+	fields := func(msg *ScheduledMessage) map[string]string {
+		if msg == nil {
+			return map[string]string{}
+		}
+		return map[string]string{
+			"owner":   "@" + p.getUsername(msg.Creator),
+			"cron":    msg.Cron,
+			"message": msg.Message,
+			"state":   msg.GetState(),
+		}
+	}
+	beforeFields := fields(before)
+	afterFields := fields(after)
+
+	changes := []string{}
+	for _, name := range []string{"owner", "cron", "message", "state"} {
+		oldValue, newValue := beforeFields[name], afterFields[name]
+		switch {
+		case oldValue == newValue:
+			continue
+		case before == nil:
+			changes = append(changes, fmt.Sprintf("%s: %s", name, format(newValue)))
+		case after == nil:
+			changes = append(changes, fmt.Sprintf("%s: %s", name, format(oldValue)))
+		default:
+			changes = append(changes, fmt.Sprintf("%s: %s → %s", name, format(oldValue), format(newValue)))
+		}
+	}
+	return changes
+}
+
+// getAuditActorName returns the name shown for the actor of an audit entry
+func (p *Plugin) getAuditActorName(actorID string) string {
+	if actorID == auditActorSystem {
+		return auditActorSystem
+	}
+	return "@" + p.getUsername(actorID)
+}
+
+// exportAudit renders the given entries in the given format
+func (p *Plugin) exportAudit(entries []AuditEntry, format string) ([]byte, error) {
+	if format == auditFormatJSON {
+		return json.MarshalIndent(entries, "", "  ")
+	}
+
+	channelNames := map[string]string{}
+	buffer := new(bytes.Buffer)
+	writer := csv.NewWriter(buffer)
+	writer.Write([]string{"time", "actor", "action", "schedule", "channel", "changes", "note"})
+	for _, entry := range entries {
+		if _, ok := channelNames[entry.ChannelID]; !ok {
+			channelNames[entry.ChannelID] = entry.ChannelID
+			if channel, appErr := p.API.GetChannel(entry.ChannelID); appErr == nil {
+				channelNames[entry.ChannelID] = channel.Name
+			}
+		}
+		writer.Write([]string{
+			fromMillis(entry.Timestamp).UTC().Format(time.RFC3339),
+			p.getAuditActorName(entry.ActorID),
+			entry.Action,
+			entry.ScheduleID,
+			channelNames[entry.ChannelID],
+			strings.Join(p.describeChanges(entry.Before, entry.After, func(value string) string { return value }), "\n"),
+			entry.Note,
+		})
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+// sendAuditExport sends the exported audit log to the given user as a file in a direct message from the plugins bot
+func (p *Plugin) sendAuditExport(userID string, content []byte, format string) error {
+	fileName := fmt.Sprintf("scheduler-audit-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	return p.sendFile(userID, fileName, content, "Here is the exported audit log of the scheduled messages.")
+}
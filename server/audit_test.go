@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func mustMarshal(value interface{}) []byte {
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// expectAudit makes the given API accept audit entries and returns the entries recorded so far
+func expectAudit(api *plugintest.API) func() []AuditEntry {
+	entries := []AuditEntry{}
+	count := 0
+	api.On("KVGet", AUDITCOUNTKEY).Return(func(key string) []byte {
+		return mustMarshal(count)
+	}, nil)
+	api.On("KVSetWithOptions", AUDITCOUNTKEY, mock.Anything, mock.Anything).Return(func(key string, value []byte, options model.PluginKVSetOptions) bool {
+		json.Unmarshal(value, &count)
+		return true
+	}, nil)
+	api.On("KVSet", mock.MatchedBy(func(key string) bool {
+		return strings.HasPrefix(key, AUDITKEYPREFIX)
+	}), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		entry := AuditEntry{}
+		json.Unmarshal(args.Get(1).([]byte), &entry)
+		entries = append(entries, entry)
+	})
+	return func() []AuditEntry {
+		return entries
+	}
+}
+
+func TestAudit(t *testing.T) {
+	before := ScheduledMessage{ID: "schedule1", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@daily", Message: "Hello"}
+	after := before
+	after.Cron = "@weekly"
+	entries := []AuditEntry{
+		AuditEntry{Timestamp: 1000, ActorID: "TestUser", Action: auditActionCreate, ScheduleID: "schedule1", ChannelID: "TestChannel", After: &before},
+		AuditEntry{Timestamp: 2000, ActorID: "TestUser", Action: auditActionEdit, ScheduleID: "schedule1", ChannelID: "TestChannel", Before: &before, After: &after},
+		AuditEntry{Timestamp: 3000, ActorID: auditActorSystem, Action: auditActionDisable, ScheduleID: "schedule2", ChannelID: "OtherChannel", Note: "The channel has been deleted"},
+	}
+	setupAPI := func() *plugintest.API {
+		api := &plugintest.API{}
+		api.On("KVGet", AUDITCOUNTKEY).Return(mustMarshal(len(entries)), nil)
+		for index, entry := range entries {
+			api.On("KVGet", auditKey(int64(index))).Return(mustMarshal(entry), nil)
+		}
+		api.On("HasPermissionTo", "AdminUser", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+		api.On("HasPermissionTo", "TestUser", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		api.On("GetUser", "TestUser").Return(&model.User{Id: "TestUser", Username: "tester"}, nil)
+		api.On("GetUser", "AdminUser").Return(&model.User{Id: "AdminUser", Username: "admin"}, nil)
+		api.On("GetUserByUsername", "tester").Return(&model.User{Id: "TestUser", Username: "tester"}, nil)
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", Name: "town-square", DisplayName: "Town Square"}, nil)
+		api.On("GetChannel", "OtherChannel").Return(nil, &model.AppError{})
+		return api
+	}
+
+	t.Run("Only system admins", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := setupAPI()
+		plugin.SetAPI(api)
+
+		result, _ := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler audit", UserId: "TestUser"})
+		assert.Equal(t, "Error: Only system admins can see the audit log", result.Text)
+	})
+	t.Run("Filtered by actor", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := setupAPI()
+		plugin.SetAPI(api)
+
+		result, _ := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler audit --actor=@tester", UserId: "AdminUser"})
+		lines := strings.Split(strings.TrimSpace(result.Text), "\n")
+		assert.Len(t, lines, 5)
+		//the most recent change is shown first
+		assert.Contains(t, lines[3], "| @tester | edit | schedule1 | Town Square | cron: @daily → @weekly |")
+		assert.Contains(t, lines[4], "| @tester | create | schedule1 | Town Square | owner: @tester, cron: @daily, message: Hello, state: active |")
+	})
+	t.Run("Unknown action", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := setupAPI()
+		plugin.SetAPI(api)
+
+		result, _ := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler audit --action=rename", UserId: "AdminUser"})
+		assert.True(t, strings.HasSuffix(result.Text, "(unknown action rename)"))
+	})
+	t.Run("Export as CSV", func(t *testing.T) {
+		plugin := &Plugin{botUserID: "BotUser"}
+		api := setupAPI()
+		api.On("GetDirectChannel", "AdminUser", "BotUser").Return(&model.Channel{Id: "DirectChannel"}, nil)
+		var exported []byte
+		api.On("UploadFile", mock.Anything, "DirectChannel", mock.AnythingOfType("string")).Return(&model.FileInfo{Id: "File"}, nil).Run(func(args mock.Arguments) {
+			exported = args.Get(0).([]byte)
+		})
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return len(post.FileIds) == 1 && post.FileIds[0] == "File"
+		})).Return(&model.Post{}, nil)
+		plugin.SetAPI(api)
+
+		result, _ := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler audit --format=csv", UserId: "AdminUser"})
+		assert.Equal(t, "Sent you 3 changes of the audit log as a direct message", result.Text)
+		records, err := csv.NewReader(bytes.NewReader(exported)).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, records, 4)
+		assert.Equal(t, []string{"1970-01-01T00:00:03Z", "system", "disable", "schedule2", "OtherChannel", "", "The channel has been deleted"}, records[1])
+		api.AssertNumberOfCalls(t, "CreatePost", 1)
+	})
+}
+
+func TestAuditRecorded(t *testing.T) {
+	schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+		ScheduledMessage{ID: "schedule1", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@daily", Message: "Hello"},
+	}}
+	reqBodyBytes := new(bytes.Buffer)
+	json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+	plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+	api := &plugintest.API{}
+	api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+	expectSchedulerStorage(api)
+	api.On("KVSetWithOptions", KVKEY, mock.Anything, mock.Anything).Return(true, nil)
+	api.On("KVDelete", HISTORYKEYPREFIX+"schedule1").Return(nil)
+	api.On("GetChannelMember", "TestChannel", "TestUser").Return(&model.ChannelMember{}, nil)
+	recorded := expectAudit(api)
+	plugin.SetAPI(api)
+
+	plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler remove schedule1", UserId: "TestUser"})
+	if assert.Len(t, recorded(), 1) {
+		entry := recorded()[0]
+		assert.Equal(t, "TestUser", entry.ActorID)
+		assert.Equal(t, auditActionRemove, entry.Action)
+		assert.Equal(t, "schedule1", entry.ScheduleID)
+		assert.Equal(t, "Hello", entry.Before.Message)
+		assert.Nil(t, entry.After)
+	}
+}
+
+func TestAuditPaging(t *testing.T) {
+	plugin := &Plugin{}
+	api := &plugintest.API{}
+	total := listPageSize*2 + 5
+	api.On("KVGet", AUDITCOUNTKEY).Return(mustMarshal(total), nil)
+	for index := 0; index < total; index++ {
+		entry := AuditEntry{Timestamp: int64(1000 * (index + 1)), ActorID: auditActorSystem, Action: auditActionDisable, ScheduleID: "schedule1", ChannelID: "TestChannel"}
+		api.On("KVGet", auditKey(int64(index))).Return(mustMarshal(entry), nil)
+	}
+	api.On("HasPermissionTo", "AdminUser", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+	api.On("GetUser", "AdminUser").Return(&model.User{Id: "AdminUser", Username: "admin"}, nil)
+	api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", Name: "town-square", DisplayName: "Town Square"}, nil)
+	plugin.SetAPI(api)
+
+	result, _ := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler audit", UserId: "AdminUser"})
+	assert.True(t, strings.HasSuffix(result.Text, "Page 1. Add `--page=2` to see the next page."))
+	//only the most recent entries and the one telling there is a next page are read
+	api.AssertNumberOfCalls(t, "KVGet", listPageSize+2)
+	api.AssertNotCalled(t, "KVGet", auditKey(0))
+
+	result, _ = plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler audit --page=3", UserId: "AdminUser"})
+	assert.True(t, strings.HasSuffix(result.Text, "Page 3 of 3."))
+	assert.Contains(t, result.Text, "| system | disable | schedule1 | Town Square |")
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	csvColumnChannel  = "channel"
+	csvColumnSchedule = "schedule"
+	csvColumnTimezone = "timezone"
+	csvColumnMessage  = "message"
+	csvColumnOwner    = "owner"
+
+	//bulkMaxRows limits the number of schedules imported at once
+	bulkMaxRows = 1000
+
+	bulkImportHint = "[--dry-run]"
+)
+
+var (
+	csvColumns = []string{csvColumnChannel, csvColumnSchedule, csvColumnTimezone, csvColumnMessage, csvColumnOwner}
+	//csvRequiredColumns have to be given in every file, the other columns are optional
+	csvRequiredColumns = []string{csvColumnChannel, csvColumnSchedule, csvColumnMessage}
+	//csvColumnAliases are other common names of the columns
+	csvColumnAliases = map[string]string{"datetime": csvColumnSchedule, "recurrence": csvColumnSchedule, "cron": csvColumnSchedule, "tz": csvColumnTimezone}
+	//bulkExtensions are the extensions of the files accepted by the CSV import
+	bulkExtensions = map[string]string{"csv": "csv"}
+	//bulkTimeFormats are the formats spreadsheets use for times, which are accepted besides the ones of @at
+	bulkTimeFormats = []string{"2006-01-02 15:04:05", "2006-01-02 15:04"}
+)
+
+// ImportBatch records the schedules created by a bulk import, so they can be removed together
+type ImportBatch struct {
+	ID           string   `json:"id"`
+	ActorID      string   `json:"actorID"`
+	FileName     string   `json:"fileName"`
+	CreatedAt    int64    `json:"createdAt"`
+	ScheduleIDs  []string `json:"scheduleIDs"`
+	RolledBackAt int64    `json:"rolledBackAt,omitempty"` //zero if the batch has not been rolled back
+}
+
+// bulkRow is a single row of an imported CSV file
+type bulkRow struct {
+	Row      int //row in the spreadsheet, the header is row 1
+	Fields   map[string]string
+	Schedule ScheduledMessage //the schedule to create, set once the row is valid
+	Errors   []string
+}
+
+// parseBulkCSV reads the rows of a CSV file. The first row names the columns, which can be given in any order.
+func parseBulkCSV(content []byte) ([]bulkRow, error) {
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid CSV")
+	}
+	if len(records) == 0 {
+		return nil, errors.New("the file is empty")
+	}
+	if len(records)-1 > bulkMaxRows {
+		return nil, errors.Errorf("the file has more than %d rows", bulkMaxRows)
+	}
+
+	columns := []string{}
+	for index, name := range records[0] {
+		column := strings.ToLower(strings.TrimSpace(name))
+		//spreadsheets often start the file with a byte order mark
+		if index == 0 {
+			column = strings.TrimPrefix(column, "\ufeff")
+		}
+		if alias, ok := csvColumnAliases[column]; ok {
+			column = alias
+		}
+		if !containsString(csvColumns, column) {
+			return nil, errors.Errorf("unknown column %s", name)
+		}
+		if containsString(columns, column) {
+			return nil, errors.Errorf("the column %s is given twice", column)
+		}
+		columns = append(columns, column)
+	}
+	for _, column := range csvRequiredColumns {
+		if !containsString(columns, column) {
+			return nil, errors.Errorf("missing column %s", column)
+		}
+	}
+
+	rows := []bulkRow{}
+	for index, record := range records[1:] {
+		row := bulkRow{Row: index + 2, Fields: map[string]string{}}
+		empty := true
+		for column, value := range record {
+			row.Fields[columns[column]] = value
+			empty = empty && strings.TrimSpace(value) == ""
+		}
+		if !empty {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// parseBulkOptions reads the options given to the import-csv command and tells whether it is a dry run
+func parseBulkOptions(text string) (bool, error) {
+	arguments, options, err := parseArguments(text, optionDryRun)
+	if err != nil {
+		return false, err
+	}
+	if len(arguments) > 0 {
+		return false, errors.Errorf("unexpected argument %s", arguments[0])
+	}
+	return parseDryRun(options)
+}
+
+// bulkSchedule converts the schedule and timezone of a row into the cron-syntax of the schedule. Times are turned
+// into schedules posting only once.
+func bulkSchedule(value string, timezone string) (string, error) {
+	spec := strings.TrimSpace(value)
+	for _, format := range append(bulkTimeFormats, oneShotFormats...) {
+		if _, err := time.Parse(format, spec); err == nil {
+			spec = oneShotPrefix + strings.Replace(spec, " ", "T", 1)
+			break
+		}
+	}
+	if timezone = strings.TrimSpace(timezone); timezone != "" {
+		var err error
+		if spec, err = applyTimezone(spec, timezone); err != nil {
+			return "", err
+		}
+	}
+
+	schedule, err := parseRecurrence(spec)
+	if err != nil {
+		return "", errors.New("neither a time nor a valid cron-syntax")
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return "", errors.New("it never posts, is the time in the past?")
+	}
+	return spec, nil
+}
+
+// validateBulkRows checks every row of an imported CSV file and records all problems of a row in its errors.
+// Channels are given as team/channel or as the name of a channel of the given team. Rows without an owner are
+// imported for the given actor.
+func (p *Plugin) validateBulkRows(actorID string, teamID string, rows []bulkRow, existing []ScheduledMessage) {
+	teams := map[string]*model.Team{}
+	getTeam := func(name string) *model.Team {
+		if _, ok := teams[name]; !ok {
+			team, appErr := p.API.GetTeamByName(name)
+			if appErr != nil {
+				team = nil
+			}
+			teams[name] = team
+		}
+		return teams[name]
+	}
+	users := map[string]*model.User{}
+	getUser := func(username string) *model.User {
+		if _, ok := users[username]; !ok {
+			user, appErr := p.API.GetUserByUsername(username)
+			if appErr != nil || user.DeleteAt != 0 {
+				user = nil
+			}
+			users[username] = user
+		}
+		return users[username]
+	}
+	approvers := map[string][]string{}
+	getApprovers := func(channelID string) []string {
+		if _, ok := approvers[channelID]; !ok {
+			approvers[channelID] = p.findApprovers(channelID)
+		}
+		return approvers[channelID]
+	}
+	//the schedules that exist once the valid rows have been created, to check the limits and duplicates
+	planned := append([]ScheduledMessage{}, existing...)
+
+	for index := range rows {
+		row := &rows[index]
+		fail := func(format string, args ...interface{}) {
+			row.Errors = append(row.Errors, fmt.Sprintf(format, args...))
+		}
+
+		var channel *model.Channel
+		channelName := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(row.Fields[csvColumnChannel]), "~"))
+		channelTeamID := teamID
+		if fields := strings.SplitN(channelName, "/", 2); len(fields) == 2 {
+			channelName = strings.TrimPrefix(fields[1], "~")
+			channelTeamID = ""
+			if team := getTeam(fields[0]); team != nil {
+				channelTeamID = team.Id
+			} else {
+				fail("There is no team %s", fields[0])
+			}
+		}
+		if channelName == "" {
+			fail("The channel is missing")
+		} else if channelTeamID != "" {
+			found, appErr := p.API.GetChannelByName(channelTeamID, channelName, false)
+			if appErr != nil {
+				fail("There is no channel ~%s", channelName)
+			} else {
+				channel = found
+			}
+		}
+
+		ownerID := actorID
+		if ownerName := strings.TrimPrefix(strings.TrimSpace(row.Fields[csvColumnOwner]), "@"); ownerName != "" {
+			if owner := getUser(ownerName); owner != nil {
+				ownerID = owner.Id
+			} else {
+				fail("There is no active user @%s", ownerName)
+			}
+		}
+
+		spec := ""
+		if value := strings.TrimSpace(row.Fields[csvColumnSchedule]); value == "" {
+			fail("The schedule is missing")
+		} else if converted, err := bulkSchedule(value, row.Fields[csvColumnTimezone]); err != nil {
+			fail("Invalid schedule %s: %s", value, err.Error())
+		} else {
+			spec = converted
+		}
+		message := strings.TrimSpace(row.Fields[csvColumnMessage])
+		if message == "" {
+			fail("The message is missing")
+		}
+		if len(row.Errors) > 0 {
+			continue
+		}
+
+		msg := ScheduledMessage{Creator: ownerID, ChannelID: channel.Id, Cron: spec, Message: message}
+		if !p.canPostIn(ownerID, channel.Id) {
+			fail("@%s is not allowed to post in ~%s", p.getUsername(ownerID), channel.Name)
+			continue
+		}
+		if err := p.checkDestination(channel.Id); err != nil {
+			fail("Violates the restrictions set by the admins: %s", err.Error())
+			continue
+		}
+		if err := p.checkPolicy(msg, planned); err != nil {
+			fail("Violates the limits set by the admins: %s", err.Error())
+			continue
+		}
+		if p.needsApproval(ownerID, channel.Id) && len(getApprovers(channel.Id)) == 0 {
+			fail(noApproversMessage)
+			continue
+		}
+		duplicate := false
+		for _, other := range planned {
+			duplicate = duplicate || (other.ChannelID == msg.ChannelID && other.Cron == msg.Cron && other.Message == msg.Message)
+		}
+		if duplicate {
+			fail("The same message is already scheduled in ~%s at the same time", channel.Name)
+			continue
+		}
+
+		row.Schedule = msg
+		planned = append(planned, msg)
+	}
+}
+
+// createBatch creates the schedules of the given valid rows at once and records them as a batch, which can be rolled
+// back as a unit. Like single schedules, schedules of channels requiring an approval are stored as pending and the
+// approvers are asked to approve them.
+func (p *Plugin) createBatch(actorID string, fileName string, rows []bulkRow) (*ImportBatch, error) {
+	batch := &ImportBatch{ID: model.NewId(), ActorID: actorID, FileName: fileName, CreatedAt: toMillis(time.Now())}
+	for range rows {
+		batch.ScheduleIDs = append(batch.ScheduleIDs, model.NewId())
+	}
+	//the batch is stored first, so all of its schedules can be rolled back even if the plugin stops in between
+	if err := p.WriteBatchToStorage(batch); err != nil {
+		return nil, errors.Wrap(err, "failed to store the batch")
+	}
+
+	approvers := map[string][]string{}
+	var created, pending []ScheduledMessage
+	err := p.updateStorage(func(data *SchedulerData) error {
+		created = []ScheduledMessage{}
+		pending = []ScheduledMessage{}
+		for index, row := range rows {
+			msg := row.Schedule
+			msg.ID = batch.ScheduleIDs[index]
+			//other schedules may have been created since the rows were validated
+			if err := p.checkPolicy(msg, data.ScheduledMessages); err != nil {
+				return newScheduleError(http.StatusBadRequest, "row %d violates the limits set by the admins: %s", row.Row, err.Error())
+			}
+			if p.needsApproval(msg.Creator, msg.ChannelID) {
+				if _, ok := approvers[msg.ChannelID]; !ok {
+					approvers[msg.ChannelID] = p.findApprovers(msg.ChannelID)
+				}
+				if len(approvers[msg.ChannelID]) == 0 {
+					return newScheduleError(http.StatusConflict, "row %d cannot be approved by anybody", row.Row)
+				}
+				msg.State = statePending
+				msg.Reason = reasonPendingApproval
+				pending = append(pending, msg)
+			}
+			data.ScheduledMessages = append(data.ScheduledMessages, msg)
+			created = append(created, msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to store the schedules")
+	}
+	note := fmt.Sprintf("Imported with the batch %s", batch.ID)
+	for index := range created {
+		p.recordAudit(actorID, auditActionCreate, nil, &created[index], note)
+	}
+	for _, msg := range pending {
+		p.requestApproval(msg, approvers[msg.ChannelID])
+	}
+	return batch, nil
+}
+
+// rollbackBatch removes all schedules created by the given batch, which have not been removed already. It returns
+// the number of removed schedules.
+func (p *Plugin) rollbackBatch(actorID string, batchID string) (int, *scheduleError) {
+	batch := p.ReadBatchFromStorage(batchID)
+	if batch == nil {
+		return 0, newScheduleError(http.StatusNotFound, "There is no batch with the ID %s", batchID)
+	}
+	if batch.RolledBackAt != 0 {
+		return 0, newScheduleError(http.StatusConflict, "The batch %s has already been rolled back", batchID)
+	}
+
+	removed := []ScheduledMessage{}
+	err := p.updateStorage(func(data *SchedulerData) error {
+		removed = []ScheduledMessage{}
+		kept := []ScheduledMessage{}
+		for _, msg := range data.ScheduledMessages {
+			if containsString(batch.ScheduleIDs, msg.ID) {
+				removed = append(removed, msg)
+				continue
+			}
+			kept = append(kept, msg)
+		}
+		if len(removed) == 0 {
+			return errStorageUnchanged
+		}
+		data.ScheduledMessages = kept
+		return nil
+	})
+	if scheduleErr := toScheduleError(err); scheduleErr != nil {
+		return 0, scheduleErr
+	}
+	note := fmt.Sprintf("Rolled back the batch %s", batch.ID)
+	for index := range removed {
+		p.ClearHistoryFromStorage(removed[index].ID)
+		p.recordAudit(actorID, auditActionRemove, &removed[index], nil, note)
+	}
+
+	batch.RolledBackAt = toMillis(time.Now())
+	if err := p.WriteBatchToStorage(batch); err != nil {
+		p.API.LogError("Failed to store the rolled back batch", "id", batch.ID, "err", err.Error())
+	}
+	return len(removed), nil
+}
+
+// renderBulkRows shows the given rows as a table, which is shortened to fit into a single post. Only the errors
+// are shown if any row is invalid.
+func (p *Plugin) renderBulkRows(header string, rows []bulkRow, location *time.Location) string {
+	invalid := 0
+	for _, row := range rows {
+		if len(row.Errors) > 0 {
+			invalid++
+		}
+	}
+
+	lines := []string{}
+	for _, row := range rows {
+		channel := strings.Replace(strings.TrimSpace(row.Fields[csvColumnChannel]), "|", "\\|", -1)
+		if invalid > 0 {
+			if len(row.Errors) > 0 {
+				lines = append(lines, fmt.Sprintf("| %d | %s | %s |\n", row.Row, channel, strings.Replace(strings.Join(row.Errors, ", "), "|", "\\|", -1)))
+			}
+			continue
+		}
+		nextRun := "-"
+		if schedule, err := parseRecurrence(row.Schedule.Cron); err == nil {
+			if next := schedule.Next(time.Now()); !next.IsZero() {
+				nextRun = next.In(location).Format(timeFormat)
+			}
+		}
+		lines = append(lines, fmt.Sprintf("| %d | %s | %s | %s | %s | %s |\n", row.Row, channel,
+			p.getUsername(row.Schedule.Creator), row.Schedule.Cron, nextRun, shortenMessage(row.Schedule.Message)))
+	}
+
+	if invalid > 0 {
+		header = header + "| Row | Channel | Errors |\n"
+		header = header + "| :-- | :------ | :----- |\n"
+	} else {
+		header = header + "| Row | Channel | Owner | Cron | Next run | Message |\n"
+		header = header + "| :-- | :------ | :---- | :--- | :------- | :------ |\n"
+	}
+	pages := paginateRows(lines, len(header)+200)
+	if len(pages) == 0 {
+		return header
+	}
+	message := header + strings.Join(pages[0], "")
+	if len(pages) > 1 {
+		message = message + fmt.Sprintf("\nShowing the first %d of %d rows.", len(pages[0]), len(lines))
+	}
+	return message
+}
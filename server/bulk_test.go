@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParseBulkCSV(t *testing.T) {
+	t.Run("Columns in any order", func(t *testing.T) {
+		rows, err := parseBulkCSV([]byte("\ufeffMessage,Channel,Datetime\nHello,town-square,2099-01-02 09:00\n,,\n\"Hello, again\",off-topic,@daily\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, []bulkRow{
+			bulkRow{Row: 2, Fields: map[string]string{csvColumnMessage: "Hello", csvColumnChannel: "town-square", csvColumnSchedule: "2099-01-02 09:00"}},
+			bulkRow{Row: 4, Fields: map[string]string{csvColumnMessage: "Hello, again", csvColumnChannel: "off-topic", csvColumnSchedule: "@daily"}},
+		}, rows)
+	})
+	t.Run("Unknown column", func(t *testing.T) {
+		_, err := parseBulkCSV([]byte("channel,schedule,message,priority\n"))
+		assert.EqualError(t, err, "unknown column priority")
+	})
+	t.Run("Missing column", func(t *testing.T) {
+		_, err := parseBulkCSV([]byte("channel,schedule\n"))
+		assert.EqualError(t, err, "missing column message")
+	})
+	t.Run("Column given twice", func(t *testing.T) {
+		_, err := parseBulkCSV([]byte("channel,cron,recurrence,message\n"))
+		assert.EqualError(t, err, "the column schedule is given twice")
+	})
+}
+
+func TestBulkSchedule(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		timezone string
+		expected string
+	}{
+		{value: "2099-01-02 09:00", expected: "@at 2099-01-02T09:00"},
+		{value: "2099-01-02T09:00:30", timezone: "Europe/Berlin", expected: "CRON_TZ=Europe/Berlin @at 2099-01-02T09:00:30"},
+		{value: "@at 2099-01-02T09:00", expected: "@at 2099-01-02T09:00"},
+		{value: "0 0 9 * * MON", timezone: "America/New_York", expected: "CRON_TZ=America/New_York 0 0 9 * * MON"},
+		{value: "RRULE:FREQ=MONTHLY;BYDAY=-1FR;BYHOUR=16", expected: "RRULE:FREQ=MONTHLY;BYDAY=-1FR;BYHOUR=16"},
+	} {
+		t.Run(test.value, func(t *testing.T) {
+			spec, err := bulkSchedule(test.value, test.timezone)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, spec)
+		})
+	}
+
+	_, err := bulkSchedule("2001-01-02 09:00", "")
+	assert.EqualError(t, err, "it never posts, is the time in the past?")
+	_, err = bulkSchedule("next monday", "")
+	assert.EqualError(t, err, "neither a time nor a valid cron-syntax")
+	_, err = bulkSchedule("@daily", "Mars/Olympus")
+	assert.EqualError(t, err, "unknown timezone Mars/Olympus")
+}
+
+func TestImportCSVCommand(t *testing.T) {
+	schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+		ScheduledMessage{ID: "schedule1", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@daily", Message: "Hello"},
+	}}
+	reqBodyBytes := new(bytes.Buffer)
+	json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+	valid := "channel,schedule,timezone,message,owner\ntown-square,2099-01-02 09:00,Europe/Berlin,Happy new year,\nproduction/town-square,@weekly,,Weekly report,@tester\n"
+
+	//storedSchedules returns the schedules read from the storage, tests can change them while the file is imported
+	var storedSchedules func() []byte
+	setupPlugin := func(content string) (*Plugin, *plugintest.API) {
+		storedSchedules = func() []byte { return reqBodyBytes.Bytes() }
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds()), botUserID: "BotUser"}
+		api := &plugintest.API{}
+		api.On("GetPostsSince", "TestChannel", mock.AnythingOfType("int64")).Return(&model.PostList{Posts: map[string]*model.Post{
+			"post1": &model.Post{Id: "post1", UserId: "AdminUser", FileIds: []string{"file1"}},
+		}}, nil)
+		api.On("GetFileInfo", "file1").Return(&model.FileInfo{Id: "file1", Name: "plan.csv", Extension: "csv"}, nil)
+		api.On("GetFile", "file1").Return([]byte(content), nil)
+		api.On("KVGet", KVKEY).Return(func(string) []byte { return storedSchedules() }, nil)
+		api.On("HasPermissionTo", "AdminUser", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+		api.On("HasPermissionTo", "TestUser", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		api.On("HasPermissionToChannel", mock.AnythingOfType("string"), "TestChannel", mock.Anything).Return(func(userID string, channelID string, permission *model.Permission) bool {
+			return userID != "TestUser" || permission != model.PERMISSION_MANAGE_CHANNEL_ROLES
+		})
+		api.On("GetTeamByName", "production").Return(&model.Team{Id: "TestTeam", Name: "production"}, nil)
+		api.On("GetTeamByName", mock.AnythingOfType("string")).Return(nil, &model.AppError{Message: "not found"})
+		api.On("GetChannelByName", "TestTeam", "town-square", false).Return(&model.Channel{Id: "TestChannel", TeamId: "TestTeam", Name: "town-square"}, nil)
+		api.On("GetChannelByName", "TestTeam", mock.AnythingOfType("string"), false).Return(nil, &model.AppError{Message: "not found"})
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", TeamId: "TestTeam", Name: "town-square"}, nil)
+		api.On("GetUserByUsername", "tester").Return(&model.User{Id: "TestUser", Username: "tester"}, nil)
+		api.On("GetUserByUsername", mock.AnythingOfType("string")).Return(nil, &model.AppError{Message: "not found"})
+		api.On("GetUser", "AdminUser").Return(&model.User{Id: "AdminUser", Username: "admin"}, nil)
+		api.On("GetUser", "TestUser").Return(&model.User{Id: "TestUser", Username: "tester"}, nil)
+		plugin.SetAPI(api)
+		plugin.setConfiguration(&configuration{})
+		return plugin, api
+	}
+	args := &model.CommandArgs{Command: "/scheduler import-csv", UserId: "AdminUser", TeamId: "TestTeam", ChannelId: "TestChannel"}
+
+	t.Run("Only system admins", func(t *testing.T) {
+		plugin, _ := setupPlugin(valid)
+
+		result, _ := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler import-csv", UserId: "TestUser", ChannelId: "TestChannel"})
+		assert.Equal(t, "Error: Only system admins can import CSV files", result.Text)
+	})
+	t.Run("Reports all invalid rows", func(t *testing.T) {
+		plugin, api := setupPlugin(valid + "staging/town-square,,,,\nrandom,2001-01-02 09:00,,Too late,@nobody\ntown-square,@daily,,Hello,@tester\n")
+
+		result, _ := plugin.ExecuteCommand(nil, args)
+		lines := strings.Split(strings.TrimSpace(result.Text), "\n")
+		assert.Equal(t, "Error: 3 of 5 rows of plan.csv are invalid, nothing has been imported. Fix them and upload the file again.", lines[0])
+		assert.Len(t, lines, 6)
+		assert.Equal(t, "| 4 | staging/town-square | There is no team staging, The schedule is missing, The message is missing |", lines[3])
+		assert.Equal(t, "| 5 | random | There is no channel ~random, There is no active user @nobody, Invalid schedule 2001-01-02 09:00: it never posts, is the time in the past? |", lines[4])
+		assert.Equal(t, "| 6 | town-square | The same message is already scheduled in ~town-square at the same time |", lines[5])
+		api.AssertNotCalled(t, "KVSet", mock.Anything, mock.Anything)
+		api.AssertNotCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
+	})
+	t.Run("Dry run", func(t *testing.T) {
+		plugin, api := setupPlugin(valid)
+
+		result, _ := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler import-csv --dry-run", UserId: "AdminUser", TeamId: "TestTeam", ChannelId: "TestChannel"})
+		lines := strings.Split(result.Text, "\n")
+		assert.Equal(t, "All rows of plan.csv are valid, importing it creates 2 scheduled messages:", lines[0])
+		assert.True(t, strings.HasPrefix(lines[3], "| 2 | town-square | admin | CRON_TZ=Europe/Berlin @at 2099-01-02T09:00 | "))
+		assert.True(t, strings.HasPrefix(lines[4], "| 3 | production/town-square | tester | @weekly | "))
+		api.AssertNotCalled(t, "KVSet", mock.Anything, mock.Anything)
+		api.AssertNotCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
+	})
+	t.Run("Checks the limits again when storing", func(t *testing.T) {
+		plugin, api := setupPlugin(valid)
+		plugin.setConfiguration(&configuration{MaxSchedulesPerChannel: 3})
+		api.On("KVSet", mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, BATCHKEYPREFIX) }), mock.Anything).Return(nil)
+		//another schedule is added to the channel after the rows have been validated
+		reads := 0
+		storedSchedules = func() []byte {
+			reads++
+			if reads == 1 {
+				return reqBodyBytes.Bytes()
+			}
+			return mustMarshal(&SchedulerData{ScheduledMessages: append([]ScheduledMessage{
+				ScheduledMessage{ID: "schedule2", Creator: "AdminUser", ChannelID: "TestChannel", Cron: "@hourly", Message: "Added"},
+			}, schedulerData.ScheduledMessages...)})
+		}
+
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Error: Cannot import the scheduled messages, row 3 violates the limits set by the admins: this channel already has 3 scheduled messages, which is the maximum. Nothing has been imported.", result.Text)
+		api.AssertNotCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
+	})
+	t.Run("Schedules of moderated channels wait for an approval", func(t *testing.T) {
+		plugin, api := setupPlugin(valid)
+		plugin.setConfiguration(&configuration{ApprovalChannels: "town-square"})
+		var stored []byte
+		api.On("KVSetWithOptions", KVKEY, mock.Anything, mock.Anything).Return(true, nil).Run(func(args mock.Arguments) {
+			stored = args.Get(1).([]byte)
+		})
+		api.On("KVSet", mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, BATCHKEYPREFIX) }), mock.Anything).Return(nil)
+		expectSchedulerStorage(api)
+		expectAudit(api)
+		api.On("GetChannelMembers", "TestChannel", 0, channelMembersPerPage).Return(&model.ChannelMembers{
+			model.ChannelMember{UserId: "TestUser"},
+			model.ChannelMember{UserId: "AdminUser", SchemeAdmin: true},
+		}, nil)
+		api.On("GetConfig").Return(&model.Config{})
+		api.On("GetDirectChannel", "AdminUser", "BotUser").Return(&model.Channel{Id: "DirectChannel"}, nil)
+		api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.True(t, strings.HasPrefix(result.Text, "Imported 2 scheduled messages of plan.csv"))
+		data := SchedulerData{}
+		json.Unmarshal(stored, &data)
+		if assert.Len(t, data.ScheduledMessages, 3) {
+			assert.Equal(t, stateActive, data.ScheduledMessages[1].GetState(), "the admin does not need an approval")
+			assert.Equal(t, statePending, data.ScheduledMessages[2].GetState())
+			assert.Equal(t, reasonPendingApproval, data.ScheduledMessages[2].Reason)
+			assert.NotContains(t, plugin.registry, data.ScheduledMessages[2].ID, "pending schedules are not posted")
+		}
+		api.AssertNumberOfCalls(t, "CreatePost", 1)
+	})
+	t.Run("Creates and rolls back the batch", func(t *testing.T) {
+		plugin, api := setupPlugin(valid)
+		var stored []byte
+		api.On("KVSetWithOptions", KVKEY, mock.Anything, mock.Anything).Return(true, nil).Run(func(args mock.Arguments) {
+			stored = args.Get(1).([]byte)
+		})
+		var storedBatch []byte
+		api.On("KVSet", mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, BATCHKEYPREFIX) }), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			storedBatch = args.Get(1).([]byte)
+		})
+		expectSchedulerStorage(api)
+		recorded := expectAudit(api)
+
+		result, _ := plugin.ExecuteCommand(nil, args)
+		batch := ImportBatch{}
+		json.Unmarshal(storedBatch, &batch)
+		assert.Equal(t, "Imported 2 scheduled messages of plan.csv as the batch "+batch.ID+". Use `/scheduler rollback "+batch.ID+"` to remove all of them.", strings.Split(result.Text, "\n")[0])
+		api.AssertNumberOfCalls(t, "KVSet", 1+1+2) //batch, revision and audit
+		api.AssertCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
+		data := SchedulerData{}
+		json.Unmarshal(stored, &data)
+		if assert.Len(t, data.ScheduledMessages, 3) {
+			assert.Equal(t, []string{data.ScheduledMessages[1].ID, data.ScheduledMessages[2].ID}, batch.ScheduleIDs)
+			assert.Equal(t, ScheduledMessage{ID: batch.ScheduleIDs[0], Creator: "AdminUser", ChannelID: "TestChannel", Cron: "CRON_TZ=Europe/Berlin @at 2099-01-02T09:00", Message: "Happy new year"}, data.ScheduledMessages[1])
+			assert.Equal(t, "TestUser", data.ScheduledMessages[2].Creator)
+		}
+		assert.Len(t, recorded(), 2)
+
+		rollback := func() string {
+			plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+			rollbackAPI := &plugintest.API{}
+			rollbackAPI.On("HasPermissionTo", "AdminUser", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+			rollbackAPI.On("KVGet", BATCHKEYPREFIX+batch.ID).Return(storedBatch, nil)
+			rollbackAPI.On("KVGet", KVKEY).Return(stored, nil)
+			rollbackAPI.On("KVSetWithOptions", KVKEY, mock.Anything, mock.Anything).Return(true, nil).Run(func(args mock.Arguments) {
+				stored = args.Get(1).([]byte)
+			})
+			rollbackAPI.On("KVSet", BATCHKEYPREFIX+batch.ID, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				storedBatch = args.Get(1).([]byte)
+			})
+			rollbackAPI.On("KVDelete", mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, HISTORYKEYPREFIX) })).Return(nil)
+			expectSchedulerStorage(rollbackAPI)
+			recorded = expectAudit(rollbackAPI)
+			plugin.SetAPI(rollbackAPI)
+
+			result, _ := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler rollback " + batch.ID, UserId: "AdminUser"})
+			return result.Text
+		}
+
+		assert.Equal(t, "Rolled back the batch "+batch.ID+", removed 2 scheduled messages", rollback())
+		data = SchedulerData{}
+		json.Unmarshal(stored, &data)
+		assert.Equal(t, schedulerData.ScheduledMessages, data.ScheduledMessages)
+		json.Unmarshal(storedBatch, &batch)
+		assert.NotZero(t, batch.RolledBackAt)
+		if assert.Len(t, recorded(), 2) {
+			assert.Equal(t, auditActionRemove, recorded()[0].Action)
+			assert.Equal(t, "Rolled back the batch "+batch.ID, recorded()[0].Note)
+		}
+
+		assert.Equal(t, "Error: The batch "+batch.ID+" has already been rolled back", rollback())
+	})
+}
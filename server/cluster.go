@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	//changePollSchedule is the cron-syntax of the job looking for changes to the schedules made by other nodes
+	changePollSchedule = "@every 5s"
+)
+
+// scheduleChange is the event published to the other nodes of a cluster whenever the schedules are changed.
+// Plugin cluster events are not available in the Mattermost versions we support, so the latest event is stored in
+// the KVStore and every node polls it. Reading this small value is much cheaper than reading all schedules.
+type scheduleChange struct {
+	Revision string `json:"revision"` //random ID, changed on every change of the schedules
+	NodeID   string `json:"nodeID"`   //ID of the plugin instance that made the change
+	Time     int64  `json:"time"`     //time in millis
+}
+
+// publishScheduleChange tells the other nodes that the stored schedules have changed, so they reconcile their
+// cron-instances with the storage
+func (p *Plugin) publishScheduleChange() {
+	change := scheduleChange{Revision: model.NewId(), NodeID: p.nodeID, Time: toMillis(time.Now())}
+	value, _ := json.Marshal(change)
+	if appErr := p.API.KVSet(REVISIONKEY, value); appErr != nil {
+		p.recordError("Failed to publish the change of the schedules: %s", appErr.Error())
+		return
+	}
+	//this node is up to date already
+	p.setSeenRevision(change.Revision)
+}
+
+// pollScheduleChanges reconciles our cron-instance with the storage if another node changed the schedules. Missed
+// changes are picked up by the periodic reconciliation.
+func (p *Plugin) pollScheduleChanges() {
+	kvData, appErr := p.API.KVGet(REVISIONKEY)
+	if appErr != nil || kvData == nil {
+		return
+	}
+	change := scheduleChange{}
+	if err := json.Unmarshal(kvData, &change); err != nil {
+		return
+	}
+
+	p.clusterLock.Lock()
+	seen := change.Revision == p.seenRevision
+	p.seenRevision = change.Revision
+	p.clusterLock.Unlock()
+	if !seen {
+		p.reconcileFromStorage()
+	}
+}
+
+func (p *Plugin) setSeenRevision(revision string) {
+	p.clusterLock.Lock()
+	defer p.clusterLock.Unlock()
+	p.seenRevision = revision
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestScheduleChanges(t *testing.T) {
+	schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+		ScheduledMessage{ID: "schedule1", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@daily", Message: "Hello"},
+	}}
+	reqBodyBytes := new(bytes.Buffer)
+	json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+	t.Run("Publishes every write", func(t *testing.T) {
+		plugin := &Plugin{nodeID: "node1", pluginCron: cron.New(cron.WithSeconds())}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(nil, nil)
+		api.On("KVSetWithOptions", KVKEY, mock.Anything, model.PluginKVSetOptions{Atomic: true}).Return(true, nil)
+		api.On("KVGet", DUEKEYPREFIX+"schedule1").Return(nil, nil)
+		api.On("KVSetWithOptions", DUEKEYPREFIX+"schedule1", mock.Anything, mock.Anything).Return(true, nil)
+		api.On("KVSet", REVISIONKEY, mock.MatchedBy(func(value []byte) bool {
+			change := scheduleChange{}
+			json.Unmarshal(value, &change)
+			return change.NodeID == "node1" && change.Revision != ""
+		})).Return(nil)
+		plugin.SetAPI(api)
+
+		err := plugin.updateStorage(func(data *SchedulerData) error {
+			data.ScheduledMessages = schedulerData.ScheduledMessages
+			return nil
+		})
+		assert.NoError(t, err)
+		api.AssertExpectations(t)
+		assert.NotEmpty(t, plugin.seenRevision)
+	})
+	t.Run("Changes the schedules again after a concurrent write", func(t *testing.T) {
+		plugin := &Plugin{nodeID: "node1", pluginCron: cron.New(cron.WithSeconds())}
+		//another node adds a schedule between the first read and write of this node
+		concurrent := &SchedulerData{ScheduledMessages: append([]ScheduledMessage{
+			ScheduledMessage{ID: "schedule0", Creator: "OtherUser", ChannelID: "TestChannel", Cron: "@hourly", Message: "Hi"},
+		}, schedulerData.ScheduledMessages...)}
+		concurrentBytes := mustMarshal(concurrent)
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil).Once()
+		api.On("KVGet", KVKEY).Return(concurrentBytes, nil).Once()
+		api.On("KVSetWithOptions", KVKEY, mock.Anything, model.PluginKVSetOptions{Atomic: true, OldValue: reqBodyBytes.Bytes()}).Return(false, nil).Once()
+		var stored SchedulerData
+		api.On("KVSetWithOptions", KVKEY, mock.Anything, model.PluginKVSetOptions{Atomic: true, OldValue: concurrentBytes}).Return(true, nil).Once().Run(func(args mock.Arguments) {
+			json.Unmarshal(args.Get(1).([]byte), &stored)
+		})
+		expectSchedulerStorage(api)
+		plugin.SetAPI(api)
+
+		calls := 0
+		err := plugin.updateStorage(func(data *SchedulerData) error {
+			calls++
+			data.ScheduledMessages[len(data.ScheduledMessages)-1].State = statePaused
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, calls)
+		api.AssertExpectations(t)
+		if assert.Len(t, stored.ScheduledMessages, 2) {
+			assert.Equal(t, "schedule0", stored.ScheduledMessages[0].ID)
+			assert.Equal(t, statePaused, stored.ScheduledMessages[1].GetState())
+		}
+	})
+	t.Run("Gives up when the schedules keep changing", func(t *testing.T) {
+		plugin := &Plugin{nodeID: "node1", pluginCron: cron.New(cron.WithSeconds())}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("KVSetWithOptions", KVKEY, mock.Anything, mock.Anything).Return(false, nil)
+		plugin.SetAPI(api)
+
+		err := plugin.updateStorage(func(data *SchedulerData) error {
+			return nil
+		})
+		assert.Error(t, err)
+		api.AssertNumberOfCalls(t, "KVSetWithOptions", kvCompareAttempts)
+		api.AssertNotCalled(t, "KVSet", REVISIONKEY, mock.Anything)
+	})
+	t.Run("Applies changes of other nodes", func(t *testing.T) {
+		plugin := &Plugin{nodeID: "node1", pluginCron: cron.New(cron.WithSeconds())}
+		change, _ := json.Marshal(scheduleChange{Revision: "revision2", NodeID: "node2"})
+		api := &plugintest.API{}
+		api.On("KVGet", REVISIONKEY).Return(change, nil)
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("KVGet", DUEKEYPREFIX+"schedule1").Return(nil, nil)
+		api.On("KVSetWithOptions", DUEKEYPREFIX+"schedule1", mock.Anything, mock.Anything).Return(true, nil)
+		plugin.SetAPI(api)
+
+		plugin.pollScheduleChanges()
+		assert.Contains(t, plugin.registry, "schedule1")
+		assert.Equal(t, "revision2", plugin.seenRevision)
+
+		//the same change is only applied once
+		plugin.pollScheduleChanges()
+		api.AssertNumberOfCalls(t, "KVGet", 4)
+	})
+	t.Run("Ignores changes already applied", func(t *testing.T) {
+		plugin := &Plugin{nodeID: "node1", pluginCron: cron.New(cron.WithSeconds()), seenRevision: "revision1"}
+		change, _ := json.Marshal(scheduleChange{Revision: "revision1", NodeID: "node1"})
+		api := &plugintest.API{}
+		api.On("KVGet", REVISIONKEY).Return(change, nil)
+		plugin.SetAPI(api)
+
+		plugin.pollScheduleChanges()
+		api.AssertNotCalled(t, "KVGet", KVKEY)
+		assert.Empty(t, plugin.registry)
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
@@ -10,10 +11,28 @@ import (
 )
 
 const (
-	commandScheduler       = "scheduler"
-	commandSchedulerAdd    = commandScheduler + " add"
-	commandSchedulerList   = commandScheduler + " list"
-	commandSchedulerRemove = commandScheduler + " remove"
+	commandScheduler            = "scheduler"
+	commandSchedulerAdd         = commandScheduler + " add"
+	commandSchedulerList        = commandScheduler + " list"
+	commandSchedulerRemove      = commandScheduler + " remove"
+	commandSchedulerHistory     = commandScheduler + " history"
+	commandSchedulerShow        = commandScheduler + " show"
+	commandSchedulerRun         = commandScheduler + " run"
+	commandSchedulerDryRun      = commandScheduler + " dryrun"
+	commandSchedulerEdit        = commandScheduler + " edit"
+	commandSchedulerTransfer    = commandScheduler + " transfer"
+	commandSchedulerTransferAll = commandScheduler + " transfer-all"
+	commandSchedulerAudit       = commandScheduler + " audit"
+	commandSchedulerStatus      = commandScheduler + " status"
+	commandSchedulerExport      = commandScheduler + " export"
+	commandSchedulerImport      = commandScheduler + " import"
+	commandSchedulerImportCSV   = commandScheduler + " import-csv"
+	commandSchedulerRollback    = commandScheduler + " rollback"
+
+	auditHint = "[--id=<id>] [--actor=@user|system] [--action=<action>] [--channel=~channel] [--since=YYYY-MM-DD] [--format=csv|json] [--page=<page>]"
+
+	//nextRunsShown is the number of upcoming runs listed by the show command
+	nextRunsShown = 3
 )
 
 func (p *Plugin) registerCommands() error {
@@ -26,20 +45,98 @@ func (p *Plugin) registerCommands() error {
 		model.Command{
 			Trigger:          commandSchedulerAdd,
 			AutoComplete:     true,
-			AutoCompleteHint: "<cron>: <message>",
+			AutoCompleteHint: "[--tz=<timezone>] <cron>: <message>",
 			AutoCompleteDesc: "Add a new scheduled message",
 		},
 		model.Command{
 			Trigger:          commandSchedulerList,
 			AutoComplete:     true,
-			AutoCompleteDesc: "List all the schedules that have been made",
+			AutoCompleteHint: "[here|team|mine|all] [--owner=@user] [--state=active|paused|disabled|pending] [--text=<text>] [--page=<page>]",
+			AutoCompleteDesc: "List the schedules that have been made, by default the ones of the current channel",
 		},
 		model.Command{
 			Trigger:          commandSchedulerRemove,
 			AutoComplete:     true,
-			AutoCompleteHint: "<index>",
+			AutoCompleteHint: "<id>",
 			AutoCompleteDesc: "Remove a scheduled message",
 		},
+		model.Command{
+			Trigger:          commandSchedulerHistory,
+			AutoComplete:     true,
+			AutoCompleteHint: "<id>",
+			AutoCompleteDesc: "Show the recent runs of a scheduled message",
+		},
+		model.Command{
+			Trigger:          commandSchedulerShow,
+			AutoComplete:     true,
+			AutoCompleteHint: "<id>",
+			AutoCompleteDesc: "Show all details of a scheduled message",
+		},
+		model.Command{
+			Trigger:          commandSchedulerRun,
+			AutoComplete:     true,
+			AutoCompleteHint: "<id>",
+			AutoCompleteDesc: "Post a scheduled message right now",
+		},
+		model.Command{
+			Trigger:          commandSchedulerDryRun,
+			AutoComplete:     true,
+			AutoCompleteHint: "<id>",
+			AutoCompleteDesc: "Show what a scheduled message would post, without posting it",
+		},
+		model.Command{
+			Trigger:          commandSchedulerEdit,
+			AutoComplete:     true,
+			AutoCompleteHint: "<id> [--tz=<timezone>] <cron>: <message>",
+			AutoCompleteDesc: "Change the cron and message of a scheduled message",
+		},
+		model.Command{
+			Trigger:          commandSchedulerTransfer,
+			AutoComplete:     true,
+			AutoCompleteHint: "<id> @newowner",
+			AutoCompleteDesc: "Make another user the owner of a scheduled message",
+		},
+		model.Command{
+			Trigger:          commandSchedulerTransferAll,
+			AutoComplete:     true,
+			AutoCompleteHint: "@from @to",
+			AutoCompleteDesc: "Make another user the owner of all scheduled messages of a user (system admins only)",
+		},
+		model.Command{
+			Trigger:          commandSchedulerAudit,
+			AutoComplete:     true,
+			AutoCompleteHint: auditHint,
+			AutoCompleteDesc: "Show who changed scheduled messages, or export the changes as CSV or JSON (system admins only)",
+		},
+		model.Command{
+			Trigger:          commandSchedulerStatus,
+			AutoComplete:     true,
+			AutoCompleteDesc: "Show whether the scheduler is running and the scheduled messages are registered (system admins only)",
+		},
+		model.Command{
+			Trigger:          commandSchedulerExport,
+			AutoComplete:     true,
+			AutoCompleteHint: exportHint,
+			AutoCompleteDesc: "Send yourself the scheduled messages as a JSON or YAML file, by default the ones of the current channel",
+		},
+		model.Command{
+			Trigger:          commandSchedulerImport,
+			AutoComplete:     true,
+			AutoCompleteHint: importHint,
+			AutoCompleteDesc: "Import the scheduled messages of the JSON or YAML file you recently uploaded into the current channel",
+		},
+		model.Command{
+			Trigger:          commandSchedulerImportCSV,
+			AutoComplete:     true,
+			AutoCompleteHint: bulkImportHint,
+			AutoCompleteDesc: "Create the scheduled messages of the CSV file you recently uploaded into the current channel at once (system admins only)",
+		},
+		model.Command{
+			Trigger:          commandSchedulerRollback,
+			AutoComplete:     true,
+			AutoCompleteHint: "<batch>",
+			AutoCompleteDesc: "Remove all scheduled messages created by a CSV import (system admins only)",
+		},
 	}
 
 	for _, command := range commands {
@@ -64,13 +161,54 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 		commandSchedulerAdd: func(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
 			return p.executeCommandSchedulerAdd(args), nil
 		},
+		commandSchedulerHistory: func(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+			return p.executeCommandSchedulerHistory(args), nil
+		},
+		commandSchedulerShow: func(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+			return p.executeCommandSchedulerShow(args), nil
+		},
+		commandSchedulerRun: func(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+			return p.executeCommandSchedulerRun(args), nil
+		},
+		commandSchedulerDryRun: func(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+			return p.executeCommandSchedulerDryRun(args), nil
+		},
+		commandSchedulerEdit: func(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+			return p.executeCommandSchedulerEdit(args), nil
+		},
+		commandSchedulerTransfer: func(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+			return p.executeCommandSchedulerTransfer(args), nil
+		},
+		commandSchedulerTransferAll: func(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+			return p.executeCommandSchedulerTransferAll(args), nil
+		},
+		commandSchedulerAudit: func(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+			return p.executeCommandSchedulerAudit(args), nil
+		},
+		commandSchedulerStatus: func(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+			return p.executeCommandSchedulerStatus(args), nil
+		},
+		commandSchedulerExport: func(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+			return p.executeCommandSchedulerExport(args), nil
+		},
+		commandSchedulerImport: func(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+			return p.executeCommandSchedulerImport(args), nil
+		},
+		commandSchedulerImportCSV: func(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+			return p.executeCommandSchedulerImportCSV(args), nil
+		},
+		commandSchedulerRollback: func(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+			return p.executeCommandSchedulerRollback(args), nil
+		},
 	}
 
 	trigger := strings.TrimPrefix(args.Command, "/")
 	trigger = strings.TrimSuffix(trigger, " ")
 
 	for key, value := range userCommands {
-		if strings.HasPrefix(trigger, key) {
+		//commands like transfer and transfer-all share a prefix, so the trigger has to match the complete word
+		if trigger == key || strings.HasPrefix(trigger, key+" ") {
+			defer p.metrics.observeCommand(strings.TrimPrefix(key, commandScheduler+" "), time.Now())
 			return value(args)
 		}
 	}
@@ -88,23 +226,24 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 func (p *Plugin) executeCommandScheduler(args *model.CommandArgs) *model.CommandResponse {
 	return &model.CommandResponse{
 		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
-		Text:         "This plugin schedules messages. Add a new one by calling `/scheduler add <cron>:<message>`",
+		Text:         "This plugin schedules messages. Add a new one by calling `/scheduler add <cron>: <message>`",
 	}
 }
 
 func (p *Plugin) executeCommandSchedulerRemove(args *model.CommandArgs) *model.CommandResponse {
 	data := p.ReadFromStorage()
 
-	index, errResponse := getIndex(args.Command, data.ScheduledMessages)
+	index, errResponse := p.getPermittedScheduleIndex(args, commandSchedulerRemove, data.ScheduledMessages, true)
 	if errResponse != nil {
 		return errResponse
 	}
 
-	p.pluginCron.Remove(data.ScheduledMessages[index].CronID)
-
-	//from https://stackoverflow.com/a/37335777/199513
-	data.ScheduledMessages = append(data.ScheduledMessages[:index], data.ScheduledMessages[index+1:]...)
-	p.WriteToStorage(&data)
+	if scheduleErr := p.removeSchedule(args.UserId, data.ScheduledMessages[index].ID); scheduleErr != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: " + scheduleErr.Message,
+		}
+	}
 
 	return &model.CommandResponse{
 		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
@@ -113,19 +252,34 @@ func (p *Plugin) executeCommandSchedulerRemove(args *model.CommandArgs) *model.C
 }
 
 func (p *Plugin) executeCommandSchedulerList(args *model.CommandArgs) *model.CommandResponse {
-	data := p.ReadFromStorage()
+	givenText := strings.TrimPrefix(args.Command, fmt.Sprintf("/%s", commandSchedulerList))
+	filter, err := p.parseListFilter(givenText)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Please give the list command in the format [here|team|mine|all] [--owner=@user] [--state=active|paused|disabled|pending] [--text=<text>] [--page=<page>] (%s)", err.Error()),
+		}
+	}
+	if filter.Scope == listScopeAll && !p.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: Only system admins can list all scheduled messages",
+		}
+	}
 
-	if len(data.ScheduledMessages) == 0 {
+	data := p.ReadFromStorage()
+	entries := p.filterSchedules(data.ScheduledMessages, filter, args)
+	if len(entries) == 0 {
 		return &model.CommandResponse{
 			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
 			Text:         "There are no scheduled messages...",
 		}
 	}
 
-	message := "Scheduled Messages:\n"
-	message = message + "| Index | TeamID | ChannelID | Author | Cron | Message |\n"
-	message = message + "| :---- | :----- | :-------- | :----- | :--- | :------ |\n"
-	for index, scheduledMsg := range data.ScheduledMessages {
+	location := p.getUserLocation(args.UserId)
+	rows := []string{}
+	for _, entry := range entries {
+		scheduledMsg := entry.Message
 		creator := scheduledMsg.Creator
 		user, err := p.API.GetUser(creator)
 		if err == nil {
@@ -136,9 +290,34 @@ func (p *Plugin) executeCommandSchedulerList(args *model.CommandArgs) *model.Com
 		if err == nil {
 			channelName = channel.DisplayName
 		}
+		nextRun := "-"
+		if !entry.NextRun.IsZero() {
+			nextRun = entry.NextRun.In(location).Format(timeFormat)
+		}
+
+		rows = append(rows, fmt.Sprintf("| %d | %s | %s | %s | %s | %s | %s | %s |\n", entry.Index, scheduledMsg.ID, channelName, creator, scheduledMsg.Cron, nextRun, scheduledMsg.GetState(), shortenMessage(scheduledMsg.Message)))
+	}
+
+	header := "Scheduled Messages:\n"
+	header = header + "| Index | ID | Channel | Author | Cron | Next run | State | Message |\n"
+	header = header + "| :---- | :- | :------ | :----- | :--- | :------- | :---- | :------ |\n"
+	//leave enough room for the header and the footer
+	pages := paginateRows(rows, len(header)+200)
+	if filter.Page > len(pages) {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: There are only %d pages of scheduled messages", len(pages)),
+		}
+	}
 
-		message = message + fmt.Sprintf("| %d | %s | %s | %s | %s | %s |\n", index, scheduledMsg.TeamID, channelName, creator, scheduledMsg.Cron, scheduledMsg.Message)
+	message := header + strings.Join(pages[filter.Page-1], "")
+	if len(pages) > 1 {
+		message = message + fmt.Sprintf("\nPage %d of %d.", filter.Page, len(pages))
+		if filter.Page < len(pages) {
+			message = message + fmt.Sprintf(" Add `--page=%d` to see the next page.", filter.Page+1)
+		}
 	}
+	message = message + fmt.Sprintf("\nUse `/%s <id>` to see all details of a scheduled message.", commandSchedulerShow)
 
 	return &model.CommandResponse{
 		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
@@ -149,39 +328,704 @@ func (p *Plugin) executeCommandSchedulerList(args *model.CommandArgs) *model.Com
 func (p *Plugin) executeCommandSchedulerAdd(args *model.CommandArgs) *model.CommandResponse {
 	//check the user input and extract cron and message from it
 	givenText := strings.TrimPrefix(args.Command, fmt.Sprintf("/%s", commandSchedulerAdd))
-	givenText = strings.TrimPrefix(givenText, " ")
-	fields := strings.Split(givenText, ":")
+	arguments, err := parseAddArguments(givenText, optionTimezone)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Please give your schedule message in the format [--tz=<timezone>] <cron>: <message> (%s)", err.Error()),
+		}
+	}
+
+	newMessage, scheduleErr := p.createSchedule(args.UserId, args.ChannelId, args.RootId, arguments.Cron, arguments.Message)
+	if scheduleErr != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: " + scheduleErr.Message,
+		}
+	}
+
+	message := fmt.Sprintf("Added your message with the ID %s!", newMessage.ID)
+	if newMessage.GetState() == statePending {
+		message = message + " It is posted once a channel admin approves it."
+	}
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         message,
+	}
+}
+
+func (p *Plugin) executeCommandSchedulerHistory(args *model.CommandArgs) *model.CommandResponse {
+	data := p.ReadFromStorage()
+
+	index, errResponse := p.getPermittedScheduleIndex(args, commandSchedulerHistory, data.ScheduledMessages, false)
+	if errResponse != nil {
+		return errResponse
+	}
+	scheduledMsg := data.ScheduledMessages[index]
+
+	history := p.ReadHistoryFromStorage(scheduledMsg.ID)
+	if len(history) == 0 {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("The scheduled message %s has not run yet...", scheduledMsg.ID),
+		}
+	}
+
+	//posts in direct messages do not belong to a team, so we link them using the team the command has been called from
+	teamID := args.TeamId
+	if channel, err := p.API.GetChannel(scheduledMsg.ChannelID); err == nil && channel.TeamId != "" {
+		teamID = channel.TeamId
+	}
+	location := p.getUserLocation(args.UserId)
+
+	message := fmt.Sprintf("Recent runs of scheduled message %s:\n", scheduledMsg.ID)
+	message = message + "| Scheduled | Executed | Node | Trigger | Outcome | Details |\n"
+	message = message + "| :-------- | :------- | :--- | :------ | :------ | :------ |\n"
+	//show the most recent run first
+	for index := len(history) - 1; index >= 0; index-- {
+		record := history[index]
+		post := record.Error
+		if record.Note != "" {
+			post = record.Note
+		}
+		if record.PostID != "" {
+			post = record.PostID
+			if permalink := p.getPermalink(teamID, record.PostID); permalink != "" {
+				post = fmt.Sprintf("[Permalink](%s)", permalink)
+			}
+		}
+		message = message + fmt.Sprintf("| %s | %s | %s | %s | %s | %s |\n",
+			fromMillis(record.ScheduledAt).In(location).Format(timeFormat),
+			fromMillis(record.ExecutedAt).In(location).Format(timeFormat),
+			record.Node, record.Trigger, record.Outcome, post)
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         message,
+	}
+}
+
+func (p *Plugin) executeCommandSchedulerShow(args *model.CommandArgs) *model.CommandResponse {
+	data := p.ReadFromStorage()
+
+	index, errResponse := p.getPermittedScheduleIndex(args, commandSchedulerShow, data.ScheduledMessages, false)
+	if errResponse != nil {
+		return errResponse
+	}
+	scheduledMsg := data.ScheduledMessages[index]
+	location := p.getUserLocation(args.UserId)
+
+	creator := scheduledMsg.Creator
+	if user, err := p.API.GetUser(scheduledMsg.Creator); err == nil {
+		creator = "@" + user.Username
+	}
+	channelName := scheduledMsg.ChannelID
+	teamID := args.TeamId
+	if channel, err := p.API.GetChannel(scheduledMsg.ChannelID); err == nil {
+		channelName = "~" + channel.Name
+		if channel.TeamId != "" {
+			teamID = channel.TeamId
+		}
+	}
+
+	timezone, _ := splitCronTimezone(scheduledMsg.Cron)
+	if timezone == "" {
+		timezone = fmt.Sprintf("Server timezone (%s)", time.Local.String())
+	}
+
+	state := scheduledMsg.GetState()
+	if scheduledMsg.Reason != "" {
+		state = fmt.Sprintf("%s (%s)", state, scheduledMsg.Reason)
+	}
+
+	message := fmt.Sprintf("#### Scheduled message %s\n", scheduledMsg.ID)
+	message = message + fmt.Sprintf("* **Channel:** %s\n", channelName)
+	if scheduledMsg.TeamID != "" {
+		thread := scheduledMsg.TeamID
+		if permalink := p.getPermalink(teamID, scheduledMsg.TeamID); permalink != "" {
+			thread = fmt.Sprintf("[%s](%s)", scheduledMsg.TeamID, permalink)
+		}
+		message = message + fmt.Sprintf("* **Thread:** %s\n", thread)
+	}
+	message = message + fmt.Sprintf("* **Owner:** %s\n", creator)
+	message = message + fmt.Sprintf("* **Cron:** `%s`\n", scheduledMsg.Cron)
+	message = message + fmt.Sprintf("* **Recurrence:** %s\n", describeCron(scheduledMsg.Cron))
+	message = message + fmt.Sprintf("* **Timezone:** %s\n", timezone)
+	message = message + fmt.Sprintf("* **State:** %s\n", state)
+
+	if scheduledMsg.GetState() == stateActive {
+		runs, err := nextRuns(scheduledMsg.Cron, time.Now(), nextRunsShown)
+		if err != nil {
+			message = message + fmt.Sprintf("* **Next runs:** Invalid cron-syntax: %s\n", err.Error())
+		} else {
+			message = message + "* **Next runs:**\n"
+			for _, run := range runs {
+				message = message + fmt.Sprintf("  * %s\n", run.In(location).Format(timeFormat))
+			}
+		}
+	}
+
+	history := p.ReadHistoryFromStorage(scheduledMsg.ID)
+	if len(history) == 0 {
+		message = message + "* **Last run:** Not run yet\n"
+	} else {
+		record := history[len(history)-1]
+		lastRun := fmt.Sprintf("%s at %s", record.Outcome, fromMillis(record.ExecutedAt).In(location).Format(timeFormat))
+		if record.Error != "" {
+			lastRun = lastRun + fmt.Sprintf(" (%s)", record.Error)
+		}
+		message = message + fmt.Sprintf("* **Last run:** %s\n", lastRun)
+	}
+
+	message = message + "\n**Message preview:**\n"
+	for _, line := range strings.Split(scheduledMsg.Message, "\n") {
+		message = message + "> " + line + "\n"
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         message,
+	}
+}
+
+func (p *Plugin) executeCommandSchedulerRun(args *model.CommandArgs) *model.CommandResponse {
+	data := p.ReadFromStorage()
+
+	index, errResponse := p.getPermittedScheduleIndex(args, commandSchedulerRun, data.ScheduledMessages, true)
+	if errResponse != nil {
+		return errResponse
+	}
+	scheduledMsg := data.ScheduledMessages[index]
+	if scheduleErr := checkRunnable(scheduledMsg); scheduleErr != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: " + scheduleErr.Message,
+		}
+	}
+
+	createdPost, errResponse := p.postMessage(scheduledMsg, time.Now(), triggerManual)
+	if errResponse != nil {
+		return errResponse
+	}
+
+	message := fmt.Sprintf("Posted scheduled message %s", scheduledMsg.ID)
+	teamID := args.TeamId
+	if channel, err := p.API.GetChannel(createdPost.ChannelId); err == nil && channel.TeamId != "" {
+		teamID = channel.TeamId
+	}
+	if permalink := p.getPermalink(teamID, createdPost.Id); permalink != "" {
+		message = message + fmt.Sprintf(": [Permalink](%s)", permalink)
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         message,
+	}
+}
+
+func (p *Plugin) executeCommandSchedulerDryRun(args *model.CommandArgs) *model.CommandResponse {
+	data := p.ReadFromStorage()
+
+	index, errResponse := p.getPermittedScheduleIndex(args, commandSchedulerDryRun, data.ScheduledMessages, false)
+	if errResponse != nil {
+		return errResponse
+	}
+	scheduledMsg := data.ScheduledMessages[index]
+	post := buildPost(scheduledMsg)
+
+	channelName := post.ChannelId
+	if channel, err := p.API.GetChannel(post.ChannelId); err == nil {
+		channelName = "~" + channel.Name
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         fmt.Sprintf("Scheduled message %s would post the following to %s:\n\n---\n%s", scheduledMsg.ID, channelName, post.Message),
+	}
+}
+
+func (p *Plugin) executeCommandSchedulerEdit(args *model.CommandArgs) *model.CommandResponse {
+	data := p.ReadFromStorage()
+
+	index, errResponse := p.getPermittedScheduleIndex(args, commandSchedulerEdit, data.ScheduledMessages, true)
+	if errResponse != nil {
+		return errResponse
+	}
+	scheduleID := data.ScheduledMessages[index].ID
+
+	//everything after the ID are the same arguments the add command takes
+	givenText := strings.TrimPrefix(args.Command, fmt.Sprintf("/%s", commandSchedulerEdit))
+	givenText = strings.TrimPrefix(strings.TrimLeft(givenText, " "), scheduleID)
+	arguments, err := parseAddArguments(givenText, optionTimezone)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Please give your changes in the format <id> [--tz=<timezone>] <cron>: <message> (%s)", err.Error()),
+		}
+	}
+	scheduledMsg, scheduleErr := p.updateSchedule(args.UserId, scheduleID, arguments.Cron, arguments.Message)
+	if scheduleErr != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: " + scheduleErr.Message,
+		}
+	}
+
+	message := fmt.Sprintf("Changed the scheduled message %s!", scheduledMsg.ID)
+	if scheduledMsg.GetState() == statePending {
+		message = message + " It is posted once a channel admin approves the changes."
+	}
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         message,
+	}
+}
+
+func (p *Plugin) executeCommandSchedulerTransfer(args *model.CommandArgs) *model.CommandResponse {
+	data := p.ReadFromStorage()
+
+	index, errResponse := p.getPermittedScheduleIndex(args, commandSchedulerTransfer, data.ScheduledMessages, true)
+	if errResponse != nil {
+		return errResponse
+	}
+	scheduledMsg := data.ScheduledMessages[index]
+
+	fields := strings.Fields(strings.TrimPrefix(args.Command, fmt.Sprintf("/%s", commandSchedulerTransfer)))
+	if len(fields) != 2 {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: Please give the transfer in the format <id> @newowner",
+		}
+	}
+	newOwner, err := p.getActiveUserByUsername(fields[1])
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Cannot transfer the scheduled message, %s", err.Error()),
+		}
+	}
+	if !p.canPostIn(newOwner.Id, scheduledMsg.ChannelID) {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Cannot transfer the scheduled message, @%s is not allowed to post in its channel", newOwner.Username),
+		}
+	}
+
+	var before ScheduledMessage
+	var note string
+	scheduleErr := p.changeSchedule(scheduledMsg.ID, func(data *SchedulerData, index int) *scheduleError {
+		before = data.ScheduledMessages[index]
+		note = describeTransfer(p.getUsername(before.Creator), newOwner.Username, p.getUsername(args.UserId))
+		data.ScheduledMessages[index].Creator = newOwner.Id
+		scheduledMsg = data.ScheduledMessages[index]
+		return nil
+	})
+	if scheduleErr != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: " + scheduleErr.Message,
+		}
+	}
+	p.recordTransfer(scheduledMsg.ID, note)
+	p.recordAudit(args.UserId, auditActionTransfer, &before, &scheduledMsg, note)
+
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         fmt.Sprintf("Transferred the scheduled message %s to @%s", scheduledMsg.ID, newOwner.Username),
+	}
+}
+
+func (p *Plugin) executeCommandSchedulerTransferAll(args *model.CommandArgs) *model.CommandResponse {
+	if !p.isSystemAdmin(args.UserId) {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: Only system admins can transfer all scheduled messages of a user",
+		}
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(args.Command, fmt.Sprintf("/%s", commandSchedulerTransferAll)))
+	if len(fields) != 2 {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: Please give the transfer in the format @from @to",
+		}
+	}
+	//the previous owner is usually deactivated already, so it is not required to be active
+	previousOwner, appErr := p.API.GetUserByUsername(strings.TrimPrefix(fields[0], "@"))
+	if appErr != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Cannot transfer the scheduled messages, there is no user %s", fields[0]),
+		}
+	}
+	newOwner, err := p.getActiveUserByUsername(fields[1])
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Cannot transfer the scheduled messages, %s", err.Error()),
+		}
+	}
 
-	if len(fields) < 2 {
+	transferred := []ScheduledMessage{}
+	skipped := []string{}
+	note := describeTransfer(previousOwner.Username, newOwner.Username, p.getUsername(args.UserId))
+	err = p.updateStorage(func(data *SchedulerData) error {
+		transferred = []ScheduledMessage{}
+		skipped = []string{}
+		for index := range data.ScheduledMessages {
+			scheduledMsg := &data.ScheduledMessages[index]
+			if scheduledMsg.Creator != previousOwner.Id {
+				continue
+			}
+			if !p.canPostIn(newOwner.Id, scheduledMsg.ChannelID) {
+				skipped = append(skipped, scheduledMsg.ID)
+				continue
+			}
+			transferred = append(transferred, *scheduledMsg)
+			scheduledMsg.Creator = newOwner.Id
+		}
+		if len(transferred) == 0 {
+			return errStorageUnchanged
+		}
+		return nil
+	})
+	if err != nil {
 		return &model.CommandResponse{
 			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
-			Text:         "Error: Please give your schedule message in the format <cron>: <message>",
+			Text:         "Error: Cannot store the scheduled messages, please try again",
 		}
 	}
+	for _, before := range transferred {
+		after := before
+		after.Creator = newOwner.Id
+		p.recordTransfer(before.ID, note)
+		p.recordAudit(args.UserId, auditActionTransfer, &before, &after, note)
+	}
+
+	message := fmt.Sprintf("Transferred %d scheduled messages from @%s to @%s", len(transferred), previousOwner.Username, newOwner.Username)
+	if len(skipped) > 0 {
+		message = message + fmt.Sprintf(". @%s is not allowed to post in the channels of these scheduled messages: %s", newOwner.Username, strings.Join(skipped, ", "))
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         message,
+	}
+}
 
-	newMessage := ScheduledMessage{
-		Creator:   args.UserId,
-		ChannelID: args.ChannelId,
-		TeamID:    args.RootId,
-		Cron:      fields[0],
-		Message:   fields[1],
+func (p *Plugin) executeCommandSchedulerAudit(args *model.CommandArgs) *model.CommandResponse {
+	if !p.isSystemAdmin(args.UserId) {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: Only system admins can see the audit log",
+		}
 	}
 
-	entryID, err := p.pluginCron.AddFunc(newMessage.Cron, func() { p.postMessage(newMessage) })
+	givenText := strings.TrimPrefix(args.Command, fmt.Sprintf("/%s", commandSchedulerAudit))
+	filter, err := p.parseAuditFilter(givenText, args.TeamId)
 	if err != nil {
 		return &model.CommandResponse{
 			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
-			Text:         "Error: Cannot start cron-job. Is your cron-syntax correct?",
+			Text:         fmt.Sprintf("Error: Please give the audit command in the format %s (%s)", auditHint, err.Error()),
+		}
+	}
+
+	//a page holds at most listPageSize rows, so the entries of the following pages don't have to be read
+	limit := 0
+	if filter.Format == "" {
+		limit = filter.Page * listPageSize
+	}
+	entries, more := p.readAuditEntries(filter, limit)
+	if len(entries) == 0 {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "There are no matching changes in the audit log...",
+		}
+	}
+
+	if filter.Format != "" {
+		content, err := p.exportAudit(entries, filter.Format)
+		if err == nil {
+			err = p.sendAuditExport(args.UserId, content, filter.Format)
+		}
+		if err != nil {
+			p.API.LogError("Failed to export audit log", "err", err.Error())
+			return &model.CommandResponse{
+				ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+				Text:         "Error: Cannot export the audit log",
+			}
+		}
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Sent you %d changes of the audit log as a direct message", len(entries)),
+		}
+	}
+
+	location := p.getUserLocation(args.UserId)
+	rows := []string{}
+	for _, entry := range entries {
+		channelName := entry.ChannelID
+		if channel, err := p.API.GetChannel(entry.ChannelID); err == nil {
+			channelName = channel.DisplayName
+		}
+		changes := p.describeChanges(entry.Before, entry.After, shortenMessage)
+		if entry.Note != "" {
+			changes = append(changes, shortenMessage(entry.Note))
+		}
+		rows = append(rows, fmt.Sprintf("| %s | %s | %s | %s | %s | %s |\n",
+			fromMillis(entry.Timestamp).In(location).Format(timeFormat),
+			p.getAuditActorName(entry.ActorID), entry.Action, entry.ScheduleID, channelName, strings.Join(changes, ", ")))
+	}
+
+	header := "Audit log of scheduled messages:\n"
+	header = header + "| Time | Actor | Action | ID | Channel | Changes |\n"
+	header = header + "| :--- | :---- | :----- | :- | :------ | :------ |\n"
+	pages := paginateRows(rows, len(header)+200)
+	if filter.Page > len(pages) {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: There are only %d pages of changes", len(pages)),
+		}
+	}
+
+	message := header + strings.Join(pages[filter.Page-1], "")
+	switch {
+	case more:
+		message = message + fmt.Sprintf("\nPage %d. Add `--page=%d` to see the next page.", filter.Page, filter.Page+1)
+	case len(pages) > 1:
+		message = message + fmt.Sprintf("\nPage %d of %d.", filter.Page, len(pages))
+		if filter.Page < len(pages) {
+			message = message + fmt.Sprintf(" Add `--page=%d` to see the next page.", filter.Page+1)
+		}
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         message,
+	}
+}
+
+func (p *Plugin) executeCommandSchedulerStatus(args *model.CommandArgs) *model.CommandResponse {
+	if !p.isSystemAdmin(args.UserId) {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: Only system admins can see the status",
+		}
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         renderStatus(p.collectStatus(), p.getUserLocation(args.UserId)),
+	}
+}
+
+func (p *Plugin) executeCommandSchedulerExport(args *model.CommandArgs) *model.CommandResponse {
+	givenText := strings.TrimPrefix(args.Command, fmt.Sprintf("/%s", commandSchedulerExport))
+	scope, format, err := parseExportArguments(givenText)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Please give the export command in the format %s (%s)", exportHint, err.Error()),
+		}
+	}
+	if scope == listScopeAll && !p.isSystemAdmin(args.UserId) {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: Only system admins can export all scheduled messages",
 		}
 	}
-	newMessage.CronID = entryID
 
 	data := p.ReadFromStorage()
-	data.ScheduledMessages = append(data.ScheduledMessages, newMessage)
-	p.WriteToStorage(&data)
+	entries := p.filterSchedules(data.ScheduledMessages, &listFilter{Scope: scope, Page: 1}, args)
+	if len(entries) == 0 {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "There are no scheduled messages...",
+		}
+	}
+	messages := []ScheduledMessage{}
+	for _, entry := range entries {
+		messages = append(messages, entry.Message)
+	}
+
+	content, err := p.exportSchedules(messages, format)
+	if err == nil {
+		fileName := fmt.Sprintf("scheduler-export-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+		err = p.sendFile(args.UserId, fileName, content, fmt.Sprintf("Here are the exported scheduled messages. Upload the file into a channel and call `/%s` to import them.", commandSchedulerImport))
+	}
+	if err != nil {
+		p.API.LogError("Failed to export scheduled messages", "err", err.Error())
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: Cannot export the scheduled messages",
+		}
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         fmt.Sprintf("Sent you %d scheduled messages as a direct message", len(messages)),
+	}
+}
+
+func (p *Plugin) executeCommandSchedulerImport(args *model.CommandArgs) *model.CommandResponse {
+	givenText := strings.TrimPrefix(args.Command, fmt.Sprintf("/%s", commandSchedulerImport))
+	opts, err := parseImportOptions(givenText)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Please give the import command in the format %s (%s)", importHint, err.Error()),
+		}
+	}
+
+	fileInfo, format, err := p.findUploadedFile(args.UserId, args.ChannelId, importExtensions, "JSON or YAML")
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Cannot import the scheduled messages, %s", err.Error()),
+		}
+	}
+	content, appErr := p.API.GetFile(fileInfo.Id)
+	if appErr != nil {
+		p.API.LogError("Failed to read imported file", "id", fileInfo.Id, "err", appErr.Error())
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Cannot read the file %s", fileInfo.Name),
+		}
+	}
+	export, err := parseScheduleExport(content, format)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Cannot import the file %s, %s", fileInfo.Name, err.Error()),
+		}
+	}
+
+	data := p.ReadFromStorage()
+	actions := p.planImport(args.UserId, export, data.ScheduledMessages, opts)
+	if !opts.DryRun {
+		p.applyImport(args.UserId, actions)
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         p.renderImport(fileInfo.Name, actions, opts.DryRun),
+	}
+}
+
+func (p *Plugin) executeCommandSchedulerImportCSV(args *model.CommandArgs) *model.CommandResponse {
+	if !p.isSystemAdmin(args.UserId) {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: Only system admins can import CSV files",
+		}
+	}
+
+	givenText := strings.TrimPrefix(args.Command, fmt.Sprintf("/%s", commandSchedulerImportCSV))
+	dryRun, err := parseBulkOptions(givenText)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Please give the import-csv command in the format %s (%s)", bulkImportHint, err.Error()),
+		}
+	}
+
+	fileInfo, _, err := p.findUploadedFile(args.UserId, args.ChannelId, bulkExtensions, "CSV")
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Cannot import the scheduled messages, %s", err.Error()),
+		}
+	}
+	content, appErr := p.API.GetFile(fileInfo.Id)
+	if appErr != nil {
+		p.API.LogError("Failed to read imported file", "id", fileInfo.Id, "err", appErr.Error())
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Cannot read the file %s", fileInfo.Name),
+		}
+	}
+	rows, err := parseBulkCSV(content)
+	if err == nil && len(rows) == 0 {
+		err = errors.New("the file does not contain any scheduled messages")
+	}
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Cannot import the file %s, %s", fileInfo.Name, err.Error()),
+		}
+	}
+
+	data := p.ReadFromStorage()
+	p.validateBulkRows(args.UserId, args.TeamId, rows, data.ScheduledMessages)
+	location := p.getUserLocation(args.UserId)
+	invalid := 0
+	for _, row := range rows {
+		if len(row.Errors) > 0 {
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         p.renderBulkRows(fmt.Sprintf("Error: %d of %d rows of %s are invalid, nothing has been imported. Fix them and upload the file again.\n", invalid, len(rows), fileInfo.Name), rows, location),
+		}
+	}
+	if dryRun {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         p.renderBulkRows(fmt.Sprintf("All rows of %s are valid, importing it creates %d scheduled messages:\n", fileInfo.Name, len(rows)), rows, location),
+		}
+	}
+
+	batch, err := p.createBatch(args.UserId, fileInfo.Name, rows)
+	if scheduleErr, ok := errors.Cause(err).(*scheduleError); ok {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Cannot import the scheduled messages, %s. Nothing has been imported.", scheduleErr.Message),
+		}
+	}
+	if err != nil {
+		p.API.LogError("Failed to import scheduled messages", "err", err.Error())
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: Cannot import the scheduled messages",
+		}
+	}
+
+	header := fmt.Sprintf("Imported %d scheduled messages of %s as the batch %s. Use `/%s %s` to remove all of them.\n", len(rows), fileInfo.Name, batch.ID, commandSchedulerRollback, batch.ID)
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         p.renderBulkRows(header, rows, location),
+	}
+}
+
+func (p *Plugin) executeCommandSchedulerRollback(args *model.CommandArgs) *model.CommandResponse {
+	if !p.isSystemAdmin(args.UserId) {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: Only system admins can roll back imports",
+		}
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(args.Command, fmt.Sprintf("/%s", commandSchedulerRollback)))
+	if len(fields) != 1 {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: Please give the ID of the batch to roll back",
+		}
+	}
+
+	removed, scheduleErr := p.rollbackBatch(args.UserId, fields[0])
+	if scheduleErr != nil {
+		return &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: " + scheduleErr.Message,
+		}
+	}
 
 	return &model.CommandResponse{
 		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
-		Text:         "Added your message!",
+		Text:         fmt.Sprintf("Rolled back the batch %s, removed %d scheduled messages", fields[0], removed),
 	}
 }
@@ -3,16 +3,18 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/robfig/cron/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
 func TestRemoveSchedule_fail(t *testing.T) {
-	t.Run("No index given", func(t *testing.T) {
+	t.Run("No ID given", func(t *testing.T) {
 		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{}}
 		reqBodyBytes := new(bytes.Buffer)
 		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
@@ -31,7 +33,7 @@ func TestRemoveSchedule_fail(t *testing.T) {
 		}
 
 		result, _ := plugin.ExecuteCommand(nil, args)
-		assert.Equal(t, "Error: Please enter a valid index", result.Text)
+		assert.Equal(t, "Error: Please enter a valid schedule ID", result.Text)
 	})
 	t.Run("No messages", func(t *testing.T) {
 		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{}}
@@ -45,20 +47,20 @@ func TestRemoveSchedule_fail(t *testing.T) {
 		plugin.SetAPI(api)
 
 		args := &model.CommandArgs{
-			Command:   "/scheduler remove 0",
+			Command:   "/scheduler remove schedule0",
 			ChannelId: "TestChannel",
 			TeamId:    "TestTeam",
 			UserId:    "TestUser",
 		}
 
 		result, _ := plugin.ExecuteCommand(nil, args)
-		assert.Equal(t, "Error: Your given index of 0 is not valid", result.Text)
+		assert.Equal(t, "Error: There is no schedule with the ID schedule0", result.Text)
 	})
-	t.Run("Negative Index", func(t *testing.T) {
+	t.Run("List position instead of an ID", func(t *testing.T) {
 		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
-			ScheduledMessage{},
-			ScheduledMessage{},
-			ScheduledMessage{},
+			ScheduledMessage{ID: "schedule0"},
+			ScheduledMessage{ID: "schedule1"},
+			ScheduledMessage{ID: "schedule2"},
 		}}
 		reqBodyBytes := new(bytes.Buffer)
 		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
@@ -70,77 +72,67 @@ func TestRemoveSchedule_fail(t *testing.T) {
 		plugin.SetAPI(api)
 
 		args := &model.CommandArgs{
-			Command:   "/scheduler remove -1",
-			ChannelId: "TestChannel",
-			TeamId:    "TestTeam",
-			UserId:    "TestUser",
-		}
-
-		result, _ := plugin.ExecuteCommand(nil, args)
-		assert.Equal(t, "Error: Your given index of -1 is not valid", result.Text)
-	})
-	t.Run("Index out of bounds", func(t *testing.T) {
-		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
-			ScheduledMessage{},
-			ScheduledMessage{},
-			ScheduledMessage{},
-		}}
-		reqBodyBytes := new(bytes.Buffer)
-		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
-
-		plugin := &Plugin{}
-		api := &plugintest.API{}
-		api.On("GetUser", mock.AnythingOfType("string")).Return(&model.User{Username: "TestUser"}, nil)
-		api.On("KVGet", mock.AnythingOfType("string")).Return(reqBodyBytes.Bytes(), nil)
-		plugin.SetAPI(api)
-
-		args := &model.CommandArgs{
-			Command:   "/scheduler remove 3",
+			Command:   "/scheduler remove 1",
 			ChannelId: "TestChannel",
 			TeamId:    "TestTeam",
 			UserId:    "TestUser",
 		}
 
 		result, _ := plugin.ExecuteCommand(nil, args)
-		assert.Equal(t, "Error: Your given index of 3 is not valid", result.Text)
+		assert.Equal(t, "Error: There is no schedule with the ID 1", result.Text)
+		api.AssertNotCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
 	})
 }
 func TestRemoveSchedule_success(t *testing.T) {
 	t.Run("Remove message", func(t *testing.T) {
 		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
 			ScheduledMessage{
+				ID:      "schedule0",
+				Creator: "TestUser",
 				Message: "Index 0",
 			},
 			ScheduledMessage{
+				ID:      "schedule1",
+				Creator: "TestUser",
 				Message: "Index 1",
 			},
 			ScheduledMessage{
+				ID:      "schedule2",
+				Creator: "TestUser",
 				Message: "Index 2",
 			},
 		}}
 		reqBodyBytes := new(bytes.Buffer)
 		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
 
-		schedulerDataAfter := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+		schedulerDataAfter := &SchedulerData{Version: schemaVersion, ScheduledMessages: []ScheduledMessage{
 			ScheduledMessage{
+				ID:      "schedule0",
+				Creator: "TestUser",
 				Message: "Index 0",
 			},
 			ScheduledMessage{
+				ID:      "schedule2",
+				Creator: "TestUser",
 				Message: "Index 2",
 			},
 		}}
 		reqBodyBytesAfter := new(bytes.Buffer)
 		json.NewEncoder(reqBodyBytesAfter).Encode(schedulerDataAfter)
 
-		plugin := &Plugin{}
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
 		api := &plugintest.API{}
 		api.On("GetUser", mock.AnythingOfType("string")).Return(&model.User{Username: "TestUser"}, nil)
+		expectAudit(api)
 		api.On("KVGet", mock.AnythingOfType("string")).Return(reqBodyBytes.Bytes(), nil)
-		api.On("KVSet", mock.AnythingOfType("string"), reqBodyBytesAfter.Bytes()).Return(nil)
+		expectSchedulerStorage(api)
+		api.On("KVSetWithOptions", KVKEY, reqBodyBytesAfter.Bytes(), mock.Anything).Return(true, nil)
+		api.On("KVDelete", mock.AnythingOfType("string")).Return(nil)
+		api.On("HasPermissionTo", "TestUser", model.PERMISSION_MANAGE_SYSTEM).Return(true)
 		plugin.SetAPI(api)
 
 		args := &model.CommandArgs{
-			Command:   "/scheduler remove 1",
+			Command:   "/scheduler remove schedule1",
 			ChannelId: "TestChannel",
 			TeamId:    "TestTeam",
 			UserId:    "TestUser",
@@ -149,41 +141,55 @@ func TestRemoveSchedule_success(t *testing.T) {
 		result, _ := plugin.ExecuteCommand(nil, args)
 		assert.Equal(t, "Scheduled messages removed", result.Text)
 	})
-	t.Run("Remove message with some random string in the middle of the command", func(t *testing.T) {
+	t.Run("Remove message with whitespace around the ID", func(t *testing.T) {
 		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
 			ScheduledMessage{
+				ID:      "schedule0",
+				Creator: "TestUser",
 				Message: "Index 0",
 			},
 			ScheduledMessage{
+				ID:      "schedule1",
+				Creator: "TestUser",
 				Message: "Index 1",
 			},
 			ScheduledMessage{
+				ID:      "schedule2",
+				Creator: "TestUser",
 				Message: "Index 2",
 			},
 		}}
 		reqBodyBytes := new(bytes.Buffer)
 		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
 
-		schedulerDataAfter := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+		schedulerDataAfter := &SchedulerData{Version: schemaVersion, ScheduledMessages: []ScheduledMessage{
 			ScheduledMessage{
+				ID:      "schedule0",
+				Creator: "TestUser",
 				Message: "Index 0",
 			},
 			ScheduledMessage{
+				ID:      "schedule2",
+				Creator: "TestUser",
 				Message: "Index 2",
 			},
 		}}
 		reqBodyBytesAfter := new(bytes.Buffer)
 		json.NewEncoder(reqBodyBytesAfter).Encode(schedulerDataAfter)
 
-		plugin := &Plugin{}
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
 		api := &plugintest.API{}
 		api.On("GetUser", mock.AnythingOfType("string")).Return(&model.User{Username: "TestUser"}, nil)
+		expectAudit(api)
 		api.On("KVGet", mock.AnythingOfType("string")).Return(reqBodyBytes.Bytes(), nil)
-		api.On("KVSet", mock.AnythingOfType("string"), reqBodyBytesAfter.Bytes()).Return(nil)
+		expectSchedulerStorage(api)
+		api.On("KVSetWithOptions", KVKEY, reqBodyBytesAfter.Bytes(), mock.Anything).Return(true, nil)
+		api.On("KVDelete", mock.AnythingOfType("string")).Return(nil)
+		api.On("HasPermissionTo", "TestUser", model.PERMISSION_MANAGE_SYSTEM).Return(true)
 		plugin.SetAPI(api)
 
 		args := &model.CommandArgs{
-			Command:   "/scheduler remove yeah 1",
+			Command:   "/scheduler remove   schedule1  ",
 			ChannelId: "TestChannel",
 			TeamId:    "TestTeam",
 			UserId:    "TestUser",
@@ -193,3 +199,519 @@ func TestRemoveSchedule_success(t *testing.T) {
 		assert.Equal(t, "Scheduled messages removed", result.Text)
 	})
 }
+
+func TestHistory(t *testing.T) {
+	t.Run("Unknown ID", func(t *testing.T) {
+		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+			ScheduledMessage{ID: "schedule1"},
+		}}
+		reqBodyBytes := new(bytes.Buffer)
+		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{
+			Command:   "/scheduler history schedule2",
+			ChannelId: "TestChannel",
+			TeamId:    "TestTeam",
+			UserId:    "TestUser",
+		}
+
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Error: There is no schedule with the ID schedule2", result.Text)
+	})
+	t.Run("No runs yet", func(t *testing.T) {
+		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+			ScheduledMessage{ID: "schedule1", ChannelID: "TestChannel"},
+		}}
+		reqBodyBytes := new(bytes.Buffer)
+		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("GetChannelMember", "TestChannel", "TestUser").Return(&model.ChannelMember{}, nil)
+		api.On("KVGet", HISTORYKEYPREFIX+"schedule1").Return(nil, nil)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{
+			Command:   "/scheduler history schedule1",
+			ChannelId: "TestChannel",
+			TeamId:    "TestTeam",
+			UserId:    "TestUser",
+		}
+
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "The scheduled message schedule1 has not run yet...", result.Text)
+	})
+	t.Run("Runs with permalinks", func(t *testing.T) {
+		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+			ScheduledMessage{ID: "schedule1", ChannelID: "TestChannel"},
+		}}
+		reqBodyBytes := new(bytes.Buffer)
+		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+		history := []RunRecord{
+			RunRecord{Outcome: outcomeSuccess, Node: "node1", Trigger: triggerCron, PostID: "post1"},
+			RunRecord{Outcome: outcomeFailed, Node: "node2", Trigger: triggerManual, Error: "channel archived"},
+		}
+		historyBytes := new(bytes.Buffer)
+		json.NewEncoder(historyBytes).Encode(history)
+
+		siteURL := "https://mattermost.example.com"
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("GetChannelMember", "TestChannel", "TestUser").Return(&model.ChannelMember{}, nil)
+		api.On("KVGet", HISTORYKEYPREFIX+"schedule1").Return(historyBytes.Bytes(), nil)
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", TeamId: "TestTeam"}, nil)
+		api.On("GetUser", "TestUser").Return(&model.User{Id: "TestUser"}, nil)
+		api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+		api.On("GetTeam", "TestTeam").Return(&model.Team{Name: "testteam"}, nil)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{
+			Command:   "/scheduler history schedule1",
+			ChannelId: "TestChannel",
+			TeamId:    "TestTeam",
+			UserId:    "TestUser",
+		}
+
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Contains(t, result.Text, "[Permalink](https://mattermost.example.com/testteam/pl/post1)")
+		assert.Contains(t, result.Text, "| node2 | manual | failed | channel archived |")
+		assert.Less(t, strings.Index(result.Text, "node2"), strings.Index(result.Text, "node1"))
+	})
+}
+
+func TestShow(t *testing.T) {
+	t.Run("Show all details", func(t *testing.T) {
+		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+			ScheduledMessage{
+				ID:        "schedule1",
+				Creator:   "Owner",
+				ChannelID: "TestChannel",
+				Cron:      "CRON_TZ=Europe/Berlin 0 30 9 * * MON-FRI",
+				Message:   "Good morning!\nHave a nice day",
+			},
+		}}
+		reqBodyBytes := new(bytes.Buffer)
+		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+		history := []RunRecord{
+			RunRecord{Outcome: outcomeFailed, Error: "channel archived"},
+		}
+		historyBytes := new(bytes.Buffer)
+		json.NewEncoder(historyBytes).Encode(history)
+
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("GetChannelMember", "TestChannel", "TestUser").Return(&model.ChannelMember{}, nil)
+		api.On("KVGet", HISTORYKEYPREFIX+"schedule1").Return(historyBytes.Bytes(), nil)
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", Name: "town-square", TeamId: "TestTeam"}, nil)
+		api.On("GetUser", "TestUser").Return(&model.User{Id: "TestUser"}, nil)
+		api.On("GetUser", "Owner").Return(&model.User{Id: "Owner", Username: "owner"}, nil)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{
+			Command:   "/scheduler show schedule1",
+			ChannelId: "TestChannel",
+			TeamId:    "TestTeam",
+			UserId:    "TestUser",
+		}
+
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Contains(t, result.Text, "* **Channel:** ~town-square\n")
+		assert.Contains(t, result.Text, "* **Owner:** @owner\n")
+		assert.Contains(t, result.Text, "* **Recurrence:** At 09:30:00, on Monday through Friday\n")
+		assert.Contains(t, result.Text, "* **Timezone:** Europe/Berlin\n")
+		assert.Contains(t, result.Text, "* **State:** active\n")
+		assert.Contains(t, result.Text, "* **Next runs:**\n")
+		assert.Contains(t, result.Text, "(channel archived)")
+		assert.Contains(t, result.Text, "> Good morning!\n> Have a nice day\n")
+	})
+}
+
+func TestRun(t *testing.T) {
+	t.Run("Post message now", func(t *testing.T) {
+		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+			ScheduledMessage{ID: "schedule1", Creator: "Owner", ChannelID: "TestChannel", Message: "Hello"},
+		}}
+		reqBodyBytes := new(bytes.Buffer)
+		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("GetChannelMember", "TestChannel", "TestUser").Return(&model.ChannelMember{}, nil)
+		api.On("HasPermissionTo", "TestUser", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		api.On("HasPermissionToChannel", "TestUser", "TestChannel", model.PERMISSION_MANAGE_CHANNEL_ROLES).Return(true)
+		api.On("KVGet", HISTORYKEYPREFIX+"schedule1").Return(nil, nil)
+		api.On("KVSetWithOptions", HISTORYKEYPREFIX+"schedule1", mock.MatchedBy(func(value []byte) bool {
+			history := []RunRecord{}
+			json.Unmarshal(value, &history)
+			return len(history) == 1 && history[0].Trigger == triggerManual && history[0].PostID == "post1"
+		}), mock.Anything).Return(true, nil)
+		api.On("CreatePost", &model.Post{ChannelId: "TestChannel", UserId: "Owner", Message: "Hello"}).Return(&model.Post{Id: "post1", ChannelId: "TestChannel"}, nil)
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", TeamId: "TestTeam"}, nil)
+		api.On("GetConfig").Return(&model.Config{})
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{
+			Command:   "/scheduler run schedule1",
+			ChannelId: "TestChannel",
+			TeamId:    "TestTeam",
+			UserId:    "TestUser",
+		}
+
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Posted scheduled message schedule1", result.Text)
+		api.AssertExpectations(t)
+	})
+	t.Run("Rejected schedules cannot be run", func(t *testing.T) {
+		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+			ScheduledMessage{ID: "schedule1", Creator: "TestUser", ChannelID: "TestChannel", Message: "Hello", State: stateDisabled, Reason: "Rejected by @admin"},
+		}}
+		reqBodyBytes := new(bytes.Buffer)
+		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{Command: "/scheduler run schedule1", ChannelId: "TestChannel", TeamId: "TestTeam", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Error: The scheduled message schedule1 is disabled and cannot be run", result.Text)
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+	})
+	t.Run("Dry run does not post", func(t *testing.T) {
+		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+			ScheduledMessage{ID: "schedule1", Creator: "Owner", ChannelID: "TestChannel", Message: "Hello"},
+		}}
+		reqBodyBytes := new(bytes.Buffer)
+		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("GetChannelMember", "TestChannel", "TestUser").Return(&model.ChannelMember{}, nil)
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", Name: "town-square"}, nil)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{
+			Command:   "/scheduler dryrun schedule1",
+			ChannelId: "TestChannel",
+			TeamId:    "TestTeam",
+			UserId:    "TestUser",
+		}
+
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Scheduled message schedule1 would post the following to ~town-square:\n\n---\nHello", result.Text)
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+	})
+}
+
+func TestAddSchedule(t *testing.T) {
+	t.Run("Message containing colons", func(t *testing.T) {
+		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{}}
+		reqBodyBytes := new(bytes.Buffer)
+		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("HasPermissionToChannel", "TestUser", "TestChannel", model.PERMISSION_CREATE_POST).Return(true)
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", Name: "town-square", Type: model.CHANNEL_OPEN}, nil)
+		expectAudit(api)
+		expectSchedulerStorage(api)
+		api.On("KVSetWithOptions", KVKEY, mock.MatchedBy(func(value []byte) bool {
+			data := SchedulerData{}
+			json.Unmarshal(value, &data)
+			return len(data.ScheduledMessages) == 1 &&
+				data.ScheduledMessages[0].Cron == "0 0 9 * * MON" &&
+				data.ScheduledMessages[0].Message == "Meeting at 10:30: bring notes"
+		}), mock.Anything).Return(true, nil)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{
+			Command:   "/scheduler add 0 0 9 * * MON: Meeting at 10:30: bring notes",
+			ChannelId: "TestChannel",
+			TeamId:    "TestTeam",
+			UserId:    "TestUser",
+		}
+
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.True(t, strings.HasPrefix(result.Text, "Added your message with the ID "))
+		api.AssertExpectations(t)
+	})
+	t.Run("Invalid format", func(t *testing.T) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		api := &plugintest.API{}
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{
+			Command:   "/scheduler add 0 0 9 * * MON Meeting",
+			ChannelId: "TestChannel",
+			TeamId:    "TestTeam",
+			UserId:    "TestUser",
+		}
+
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Error: Please give your schedule message in the format [--tz=<timezone>] <cron>: <message> (missing : between cron and message)", result.Text)
+	})
+}
+
+func TestList(t *testing.T) {
+	schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+		ScheduledMessage{ID: "yearly", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@yearly", Message: "Happy new year"},
+		ScheduledMessage{ID: "other", Creator: "OtherUser", ChannelID: "OtherChannel", Cron: "@hourly", Message: "Other channel"},
+		ScheduledMessage{ID: "every", Creator: "OtherUser", ChannelID: "TestChannel", Cron: "@every 1m", Message: "Every minute"},
+		ScheduledMessage{ID: "paused", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@every 1s", Message: "Paused", State: statePaused},
+	}}
+	reqBodyBytes := new(bytes.Buffer)
+	json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+	setupAPI := func() *plugintest.API {
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("GetUser", mock.AnythingOfType("string")).Return(&model.User{Username: "TestUser"}, nil)
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", TeamId: "TestTeam", DisplayName: "Test Channel"}, nil)
+		api.On("GetChannel", "OtherChannel").Return(&model.Channel{Id: "OtherChannel", TeamId: "OtherTeam", DisplayName: "Other Channel"}, nil)
+		api.On("GetChannelMember", "TestChannel", "TestUser").Return(&model.ChannelMember{}, nil)
+		api.On("GetChannelMember", "OtherChannel", "TestUser").Return(nil, &model.AppError{})
+		return api
+	}
+	listIDs := func(text string) []string {
+		ids := []string{}
+		for _, line := range strings.Split(text, "\n") {
+			fields := strings.Split(line, " | ")
+			if len(fields) > 2 && fields[1] != "ID" && fields[1] != ":-" {
+				ids = append(ids, fields[1])
+			}
+		}
+		return ids
+	}
+
+	t.Run("Current channel sorted by next run", func(t *testing.T) {
+		plugin := &Plugin{}
+		plugin.SetAPI(setupAPI())
+
+		args := &model.CommandArgs{Command: "/scheduler list", ChannelId: "TestChannel", TeamId: "TestTeam", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, []string{"every", "yearly", "paused"}, listIDs(result.Text))
+	})
+	t.Run("Own schedules filtered by state", func(t *testing.T) {
+		plugin := &Plugin{}
+		plugin.SetAPI(setupAPI())
+
+		args := &model.CommandArgs{Command: "/scheduler list mine --state=active", ChannelId: "TestChannel", TeamId: "TestTeam", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, []string{"yearly"}, listIDs(result.Text))
+	})
+	t.Run("Filtered by owner and text", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := setupAPI()
+		api.On("GetUserByUsername", "other").Return(&model.User{Id: "OtherUser"}, nil)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{Command: `/scheduler list team --owner=@other --text="every MINUTE"`, ChannelId: "TestChannel", TeamId: "TestTeam", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, []string{"every"}, listIDs(result.Text))
+	})
+	t.Run("All schedules only for admins", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := setupAPI()
+		api.On("HasPermissionTo", "TestUser", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{Command: "/scheduler list all", ChannelId: "TestChannel", TeamId: "TestTeam", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Error: Only system admins can list all scheduled messages", result.Text)
+	})
+	t.Run("Page out of range", func(t *testing.T) {
+		plugin := &Plugin{}
+		plugin.SetAPI(setupAPI())
+
+		args := &model.CommandArgs{Command: "/scheduler list --page=2", ChannelId: "TestChannel", TeamId: "TestTeam", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Error: There are only 1 pages of scheduled messages", result.Text)
+	})
+}
+
+func TestPermissions(t *testing.T) {
+	schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+		ScheduledMessage{ID: "schedule1", Creator: "Owner", ChannelID: "PrivateChannel", Cron: "@daily", Message: "Secret"},
+	}}
+	reqBodyBytes := new(bytes.Buffer)
+	json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+	t.Run("Schedules of other channels are hidden", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("GetChannelMember", "PrivateChannel", "TestUser").Return(nil, &model.AppError{})
+		api.On("HasPermissionTo", "TestUser", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{Command: "/scheduler show schedule1", ChannelId: "TestChannel", TeamId: "TestTeam", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Error: There is no schedule with the ID schedule1", result.Text)
+	})
+	t.Run("Members cannot remove schedules of others", func(t *testing.T) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("GetChannelMember", "PrivateChannel", "TestUser").Return(&model.ChannelMember{}, nil)
+		api.On("HasPermissionTo", "TestUser", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		api.On("HasPermissionToChannel", "TestUser", "PrivateChannel", model.PERMISSION_MANAGE_CHANNEL_ROLES).Return(false)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{Command: "/scheduler remove schedule1", ChannelId: "PrivateChannel", TeamId: "TestTeam", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Error: You are not allowed to change the scheduled message schedule1", result.Text)
+		api.AssertNotCalled(t, "KVSet", mock.Anything, mock.Anything)
+		api.AssertNotCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
+	})
+	t.Run("Members cannot edit schedules of others", func(t *testing.T) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("GetChannelMember", "PrivateChannel", "TestUser").Return(&model.ChannelMember{}, nil)
+		api.On("HasPermissionTo", "TestUser", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		api.On("HasPermissionToChannel", "TestUser", "PrivateChannel", model.PERMISSION_MANAGE_CHANNEL_ROLES).Return(false)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{Command: "/scheduler edit schedule1 @hourly: Changed", ChannelId: "PrivateChannel", TeamId: "TestTeam", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Error: You are not allowed to change the scheduled message schedule1", result.Text)
+	})
+	t.Run("Cannot add schedules to channels without post permission", func(t *testing.T) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		api := &plugintest.API{}
+		api.On("HasPermissionToChannel", "TestUser", "ReadOnlyChannel", model.PERMISSION_CREATE_POST).Return(false)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{Command: "/scheduler add @daily: Hi", ChannelId: "ReadOnlyChannel", TeamId: "TestTeam", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Error: You are not allowed to post in this channel", result.Text)
+	})
+}
+
+func TestEdit(t *testing.T) {
+	t.Run("Change cron and message", func(t *testing.T) {
+		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+			ScheduledMessage{ID: "schedule1", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@daily", Message: "Old"},
+		}}
+		reqBodyBytes := new(bytes.Buffer)
+		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		expectAudit(api)
+		expectSchedulerStorage(api)
+		api.On("KVSetWithOptions", KVKEY, mock.MatchedBy(func(value []byte) bool {
+			data := SchedulerData{}
+			json.Unmarshal(value, &data)
+			return len(data.ScheduledMessages) == 1 &&
+				data.ScheduledMessages[0].ID == "schedule1" &&
+				data.ScheduledMessages[0].Cron == "@every 1h" &&
+				data.ScheduledMessages[0].Message == "New: with colon"
+		}), mock.Anything).Return(true, nil)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{Command: "/scheduler edit schedule1 @every 1h: New: with colon", ChannelId: "TestChannel", TeamId: "TestTeam", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Changed the scheduled message schedule1!", result.Text)
+		assert.Equal(t, 1, len(plugin.registry))
+		api.AssertExpectations(t)
+	})
+}
+
+func TestTransfer(t *testing.T) {
+	schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+		ScheduledMessage{ID: "schedule1", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@daily"},
+		ScheduledMessage{ID: "schedule2", Creator: "TestUser", ChannelID: "OtherChannel", Cron: "@daily"},
+		ScheduledMessage{ID: "schedule3", Creator: "OtherUser", ChannelID: "TestChannel", Cron: "@daily"},
+	}}
+	reqBodyBytes := new(bytes.Buffer)
+	json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+	setupAPI := func() *plugintest.API {
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("KVGet", mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, HISTORYKEYPREFIX) })).Return(nil, nil)
+		api.On("KVSetWithOptions", mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, HISTORYKEYPREFIX) }), mock.Anything, mock.Anything).Return(true, nil)
+		api.On("GetUser", "TestUser").Return(&model.User{Id: "TestUser", Username: "test"}, nil)
+		api.On("GetUser", "AdminUser").Return(&model.User{Id: "AdminUser", Username: "admin"}, nil)
+		api.On("GetUserByUsername", "test").Return(&model.User{Id: "TestUser", Username: "test"}, nil)
+		api.On("GetUserByUsername", "newowner").Return(&model.User{Id: "NewOwner", Username: "newowner"}, nil)
+		api.On("GetUserByUsername", "gone").Return(&model.User{Id: "GoneUser", Username: "gone", DeleteAt: 1}, nil)
+		api.On("HasPermissionToChannel", "NewOwner", "TestChannel", model.PERMISSION_CREATE_POST).Return(true)
+		api.On("HasPermissionToChannel", "NewOwner", "OtherChannel", model.PERMISSION_CREATE_POST).Return(false)
+		return api
+	}
+
+	t.Run("Transfer a schedule", func(t *testing.T) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		api := setupAPI()
+		expectAudit(api)
+		expectSchedulerStorage(api)
+		api.On("KVSetWithOptions", KVKEY, mock.MatchedBy(func(value []byte) bool {
+			data := SchedulerData{}
+			json.Unmarshal(value, &data)
+			return data.ScheduledMessages[0].Creator == "NewOwner" && data.ScheduledMessages[1].Creator == "TestUser"
+		}), mock.Anything).Return(true, nil)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{Command: "/scheduler transfer schedule1 @newowner", ChannelId: "TestChannel", TeamId: "TestTeam", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Transferred the scheduled message schedule1 to @newowner", result.Text)
+		assert.Equal(t, "NewOwner", plugin.registry["schedule1"].msg.Creator)
+		api.AssertCalled(t, "KVSetWithOptions", HISTORYKEYPREFIX+"schedule1", mock.MatchedBy(func(value []byte) bool {
+			history := []RunRecord{}
+			json.Unmarshal(value, &history)
+			return len(history) == 1 && history[0].Note == "Transferred from @test to @newowner by @test"
+		}), mock.Anything)
+	})
+	t.Run("Cannot transfer to deactivated users", func(t *testing.T) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		plugin.SetAPI(setupAPI())
+
+		args := &model.CommandArgs{Command: "/scheduler transfer schedule1 @gone", ChannelId: "TestChannel", TeamId: "TestTeam", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Error: Cannot transfer the scheduled message, the user @gone has been deactivated", result.Text)
+	})
+	t.Run("Transfer all schedules of a user", func(t *testing.T) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		api := setupAPI()
+		api.On("HasPermissionTo", "AdminUser", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+		expectAudit(api)
+		expectSchedulerStorage(api)
+		api.On("KVSetWithOptions", KVKEY, mock.MatchedBy(func(value []byte) bool {
+			data := SchedulerData{}
+			json.Unmarshal(value, &data)
+			return data.ScheduledMessages[0].Creator == "NewOwner" &&
+				data.ScheduledMessages[1].Creator == "TestUser" &&
+				data.ScheduledMessages[2].Creator == "OtherUser"
+		}), mock.Anything).Return(true, nil)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{Command: "/scheduler transfer-all @test @newowner", ChannelId: "TestChannel", TeamId: "TestTeam", UserId: "AdminUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Transferred 1 scheduled messages from @test to @newowner. @newowner is not allowed to post in the channels of these scheduled messages: schedule2", result.Text)
+	})
+	t.Run("Transfer all only for admins", func(t *testing.T) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		api := setupAPI()
+		api.On("HasPermissionTo", "TestUser", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		plugin.SetAPI(api)
+
+		args := &model.CommandArgs{Command: "/scheduler transfer-all @test @newowner", ChannelId: "TestChannel", TeamId: "TestTeam", UserId: "TestUser"}
+		result, _ := plugin.ExecuteCommand(nil, args)
+		assert.Equal(t, "Error: Only system admins can transfer all scheduled messages of a user", result.Text)
+	})
+}
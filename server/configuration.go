@@ -18,6 +18,43 @@ import (
 // If you add non-reference types to your configuration struct, be sure to rewrite Clone as a deep
 // copy appropriate for your types.
 type configuration struct {
+	// MaxSchedulesPerUser limits how many schedules a single user can create, 0 means unlimited
+	MaxSchedulesPerUser int
+
+	// MaxSchedulesPerChannel limits how many schedules can post into a single channel, 0 means unlimited
+	MaxSchedulesPerChannel int
+
+	// MinIntervalSeconds is the minimum time between two runs of a schedule, 0 means unlimited
+	MinIntervalSeconds int
+
+	// MaxMessageLength limits the number of characters of a scheduled message, 0 means unlimited
+	MaxMessageLength int
+
+	// AllowedTeams and AllowedChannels are comma-separated names, schedules may only post into these if given
+	AllowedTeams    string
+	AllowedChannels string
+
+	// DeniedTeams and DeniedChannels are comma-separated names, schedules must never post into these
+	DeniedTeams    string
+	DeniedChannels string
+
+	// DenyDirectMessages prevents schedules from posting into direct and group messages
+	DenyDirectMessages bool
+
+	// ApprovalChannels are comma-separated names of channels, where schedules have to be approved by a channel admin
+	ApprovalChannels string
+
+	// MetricsToken allows Prometheus to scrape the metrics by sending it as query parameter, system admins can always see them
+	MetricsToken string
+
+	// IntegrityAction is applied to schedules whose creator or channel is gone, one of disable, transfer or delete
+	IntegrityAction string
+
+	// DeliveryWorkers is the number of scheduled messages posted at the same time, 0 means the default of 4
+	DeliveryWorkers int
+
+	// DeliveryJitterSeconds delays every schedule by up to this many seconds to spread schedules due at the same time, 0 disables it
+	DeliveryJitterSeconds int
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -81,5 +118,10 @@ func (p *Plugin) OnConfigurationChange() error {
 	}
 
 	p.setConfiguration(configuration)
+
+	//re-evaluate the existing schedules if the plugin is already running
+	if p.getCron() != nil {
+		p.applyConfigurationToSchedules()
+	}
 	return nil
 }
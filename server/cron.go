@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// cronParser parses the cron-syntax the same way our cron-instance does
+var cronParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+var monthNames = []string{"", "January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+
+var weekdayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+
+var descriptorDescriptions = map[string]string{
+	"@yearly":   "Once a year, at midnight on January 1st",
+	"@annually": "Once a year, at midnight on January 1st",
+	"@monthly":  "Once a month, at midnight on the 1st",
+	"@weekly":   "Once a week, at midnight on Sunday",
+	"@daily":    "Once a day, at midnight",
+	"@midnight": "Once a day, at midnight",
+	"@hourly":   "Once an hour, at the beginning of the hour",
+}
+
+// splitCronTimezone splits the optional CRON_TZ or TZ prefix from the given cron-syntax and returns the timezone name and the remaining spec
+func splitCronTimezone(spec string) (string, string) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.Index(spec, " ")
+		if i < 0 {
+			return spec[strings.Index(spec, "=")+1:], ""
+		}
+		return spec[strings.Index(spec, "=")+1 : i], strings.TrimSpace(spec[i:])
+	}
+	return "", spec
+}
+
+// nextRuns returns the next count times the given recurrence fires after the given time
+func nextRuns(spec string, from time.Time, count int) ([]time.Time, error) {
+	schedule, err := parseRecurrence(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []time.Time{}
+	next := from
+	for len(runs) < count {
+		next = schedule.Next(next)
+		if next.IsZero() {
+			break //the schedule never fires again
+		}
+		runs = append(runs, next)
+	}
+	return runs, nil
+}
+
+// minimumInterval returns the shortest time between two runs of the given recurrence within the next samples runs
+func minimumInterval(spec string, from time.Time, samples int) (time.Duration, error) {
+	runs, err := nextRuns(spec, from, samples)
+	if err != nil {
+		return 0, err
+	}
+
+	var interval time.Duration
+	for i := 1; i < len(runs); i++ {
+		if gap := runs[i].Sub(runs[i-1]); interval == 0 || gap < interval {
+			interval = gap
+		}
+	}
+	return interval, nil
+}
+
+// describeCron explains the given recurrence in plain English
+func describeCron(spec string) string {
+	_, rest := splitCronTimezone(spec)
+	switch {
+	case strings.HasPrefix(rest, rrulePrefix), strings.HasPrefix(rest, oneShotPrefix):
+		schedule, err := parseRecurrence(spec)
+		if err != nil {
+			return rest
+		}
+		if rule, ok := schedule.(*rrule); ok {
+			return rule.describe()
+		}
+		return "Once at " + schedule.(*oneShot).at.Format(timeFormat)
+	}
+	spec = rest
+
+	if description, ok := descriptorDescriptions[spec]; ok {
+		return description
+	}
+	if strings.HasPrefix(spec, "@every ") {
+		duration, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return spec
+		}
+		return fmt.Sprintf("Every %s", duration)
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 6 {
+		return spec
+	}
+	second, minute, hour, dom, month, dow := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]
+
+	parts := []string{}
+	if isSingleValue(second) && isSingleValue(minute) && isSingleValue(hour) {
+		parts = append(parts, fmt.Sprintf("At %02s:%02s:%02s", hour, minute, second))
+	} else {
+		parts = append(parts, "At "+describeField(second, "second", nil))
+		if !isWildcard(minute) || !isWildcard(second) {
+			parts = append(parts, describeField(minute, "minute", nil))
+		}
+		if !isWildcard(hour) {
+			parts = append(parts, describeField(hour, "hour", nil))
+		}
+	}
+
+	switch {
+	case isWildcard(dom) && isWildcard(dow):
+		parts = append(parts, "every day")
+	case isWildcard(dow):
+		parts = append(parts, "on "+describeField(dom, "day-of-month", nil))
+	case isWildcard(dom):
+		parts = append(parts, "on "+describeField(dow, "", weekdayNames))
+	default:
+		parts = append(parts, "on "+describeField(dom, "day-of-month", nil)+" and on "+describeField(dow, "", weekdayNames))
+	}
+	if !isWildcard(month) {
+		parts = append(parts, "in "+describeField(month, "", monthNames))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func isWildcard(field string) bool {
+	return field == "*" || field == "?"
+}
+
+func isSingleValue(field string) bool {
+	_, err := strconv.Atoi(field)
+	return err == nil
+}
+
+// describeField explains a single field of the cron-syntax. Fields with names (months, weekdays) are given without a unit.
+func describeField(field string, unit string, names []string) string {
+	if isWildcard(field) {
+		return "every " + unit
+	}
+
+	descriptions := []string{}
+	for _, item := range strings.Split(field, ",") {
+		step := ""
+		if i := strings.Index(item, "/"); i >= 0 {
+			step = item[i+1:]
+			item = item[:i]
+		}
+
+		var description string
+		switch {
+		case isWildcard(item):
+			description = fmt.Sprintf("every %s %ss", step, unit)
+			step = ""
+		case strings.Contains(item, "-"):
+			bounds := strings.SplitN(item, "-", 2)
+			description = fmt.Sprintf("%s through %s", nameValue(bounds[0], names), nameValue(bounds[1], names))
+		default:
+			description = nameValue(item, names)
+		}
+		if step != "" {
+			description = fmt.Sprintf("every %s %ss from %s", step, unit, description)
+		}
+		descriptions = append(descriptions, description)
+	}
+
+	prefix := ""
+	if unit != "" && !strings.HasPrefix(descriptions[0], "every") {
+		prefix = unit + " "
+	}
+	if len(descriptions) == 1 {
+		return prefix + descriptions[0]
+	}
+	return prefix + strings.Join(descriptions[:len(descriptions)-1], ", ") + " and " + descriptions[len(descriptions)-1]
+}
+
+// nameValue returns the name of the given value if names are given, e.g. months or weekdays
+func nameValue(value string, names []string) string {
+	if names == nil {
+		return value
+	}
+	index, err := strconv.Atoi(value)
+	if err == nil && index >= 0 && index < len(names) {
+		return names[index]
+	}
+	//the value may already be a name like MON or DEC
+	for _, name := range names {
+		if len(value) == 3 && len(name) >= 3 && strings.EqualFold(name[:3], value) {
+			return name
+		}
+	}
+	return value
+}
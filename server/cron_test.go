@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribeCron(t *testing.T) {
+	testCases := []struct {
+		spec     string
+		expected string
+	}{
+		{"0 0 12 * * *", "At 12:00:00, every day"},
+		{"0 30 9 * * MON-FRI", "At 09:30:00, on Monday through Friday"},
+		{"0 0 0 25 DEC ?", "At 00:00:00, on day-of-month 25, in December"},
+		{"0 */15 * * * *", "At second 0, every 15 minutes, every day"},
+		{"* * * * * *", "At every second, every day"},
+		{"0 0 8 1,15 * *", "At 08:00:00, on day-of-month 1 and 15"},
+		{"@midnight", "Once a day, at midnight"},
+		{"@every 1h30m", "Every 1h30m0s"},
+		{"CRON_TZ=Europe/Berlin 0 0 12 * * *", "At 12:00:00, every day"},
+		{"CRON_TZ=UTC RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO;DTSTART=20200601T090000", "Weekly, on Monday, at 09:00:00, every 2 weeks, starting 2020-06-01 09:00:00 UTC"},
+		{"CRON_TZ=UTC RRULE:FREQ=MONTHLY;BYDAY=-1FR;BYHOUR=17", "Monthly, on the last Friday, at 17:00:00"},
+		{"CRON_TZ=UTC @at 2026-10-20T09:00", "Once at 2026-10-20 09:00:00 UTC"},
+		{"not a cron", "not a cron"},
+	}
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, describeCron(testCase.spec), testCase.spec)
+	}
+}
+
+func TestSplitCronTimezone(t *testing.T) {
+	timezone, spec := splitCronTimezone("CRON_TZ=Europe/Berlin 0 0 12 * * *")
+	assert.Equal(t, "Europe/Berlin", timezone)
+	assert.Equal(t, "0 0 12 * * *", spec)
+
+	timezone, spec = splitCronTimezone("0 0 12 * * *")
+	assert.Equal(t, "", timezone)
+	assert.Equal(t, "0 0 12 * * *", spec)
+}
+
+func TestNextRuns(t *testing.T) {
+	from := time.Date(2020, time.June, 27, 10, 0, 0, 0, time.UTC)
+
+	runs, err := nextRuns("CRON_TZ=UTC 0 0 12 * * *", from, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Time{
+		time.Date(2020, time.June, 27, 12, 0, 0, 0, time.UTC),
+		time.Date(2020, time.June, 28, 12, 0, 0, 0, time.UTC),
+	}, runs)
+
+	_, err = nextRuns("not a cron", from, 2)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	//deliveryKeyProp is the prop of scheduled posts containing the key of the occurrence they have been posted for
+	deliveryKeyProp = "scheduler_delivery_key"
+	//deliveryRecordExpiry is how long the deliveries of occurrences are remembered
+	deliveryRecordExpiry = 7 * 24 * time.Hour
+)
+
+// deliveryRecord is stored in the KVStore for every occurrence the scheduler posts. It is written before the post is
+// created and completed afterwards, so a node trying again after another node stopped can tell whether the
+// occurrence has been posted already.
+type deliveryRecord struct {
+	Node      string `json:"node"`             //ID of the plugin instance posting the occurrence
+	StartedAt int64  `json:"startedAt"`        //time in millis the delivery started
+	PostID    string `json:"postID,omitempty"` //empty until the post has been created
+}
+
+// deliveryKey identifies an occurrence of a schedule, it is the same on every node and for every try
+func deliveryKey(scheduleID string, scheduledAt time.Time) string {
+	return fmt.Sprintf("%s_%d", scheduleID, toMillis(scheduledAt))
+}
+
+// deliverOccurrence posts the given occurrence of a schedule, unless it has been posted already by a node that
+// stopped before it stored the next run. It returns false if the occurrence has not been posted and should be tried
+// again, e.g. because the post could not be created.
+func (p *Plugin) deliverOccurrence(msg ScheduledMessage, scheduledAt time.Time) bool {
+	key := deliveryKey(msg.ID, scheduledAt)
+	if record := p.ReadDeliveryFromStorage(key); record != nil {
+		if record.PostID != "" {
+			return true
+		}
+
+		//the previous try stopped while posting, so the post may have been created anyway
+		p.metrics.observeRetry()
+		if post := p.findDeliveredPost(msg.ChannelID, key, record.StartedAt); post != nil {
+			record.PostID = post.Id
+			p.WriteDeliveryToStorage(key, record)
+			p.appendRunRecord(msg.ID, RunRecord{
+				ScheduledAt: toMillis(scheduledAt),
+				ExecutedAt:  post.CreateAt,
+				Node:        nodeName(),
+				Trigger:     triggerCron,
+				Outcome:     outcomeSuccess,
+				PostID:      post.Id,
+				Note:        "The post has been created by a node that stopped before it recorded the run",
+			})
+			return true
+		}
+	}
+
+	record := &deliveryRecord{Node: p.nodeID, StartedAt: toMillis(time.Now())}
+	//without the record a node trying again after we stopped could post the occurrence twice
+	if !p.WriteDeliveryToStorage(key, record) {
+		return false
+	}
+	post, outcome, _ := p.postMessageWithOutcome(msg, scheduledAt, triggerCron)
+	if post == nil {
+		//nothing has been posted, so there is nothing to check if the occurrence is posted again
+		p.ClearDeliveryFromStorage(key)
+		return outcome != outcomeFailed
+	}
+	record.PostID = post.Id
+	p.WriteDeliveryToStorage(key, record)
+	return true
+}
+
+// findDeliveredPost returns the post of the occurrence with the given key created since the given time, or nil if
+// there is none
+func (p *Plugin) findDeliveredPost(channelID string, key string, since int64) *model.Post {
+	posts, err := p.API.GetPostsSince(channelID, since)
+	if err != nil {
+		p.recordError("Failed to check whether the scheduled message %s has been posted: %s", key, err.Error())
+		return nil
+	}
+	for _, post := range posts.Posts {
+		if post.DeleteAt == 0 && post.Props[deliveryKeyProp] == key {
+			return post
+		}
+	}
+	return nil
+}
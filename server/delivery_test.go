@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// expectDeliveries mocks the deliveries of occurrences, none of them has been posted before
+func expectDeliveries(api *plugintest.API) {
+	isDeliveryKey := mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, DELIVERYKEYPREFIX) })
+	api.On("KVGet", isDeliveryKey).Return(nil, nil)
+	api.On("KVSetWithOptions", isDeliveryKey, mock.Anything, mock.Anything).Return(true, nil)
+	api.On("KVDelete", isDeliveryKey).Return(nil)
+}
+
+func TestDeliverOccurrence(t *testing.T) {
+	msg := ScheduledMessage{ID: "daily", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@daily", Message: "Hello"}
+	scheduledAt := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+	key := deliveryKey(msg.ID, scheduledAt)
+	startedAt := toMillis(scheduledAt)
+
+	//writeErr is returned when the delivery is stored
+	var writeErr *model.AppError
+	setupPlugin := func(record *deliveryRecord) (*Plugin, *plugintest.API) {
+		writeErr = nil
+		plugin := &Plugin{nodeID: "node1", metrics: newMetrics()}
+		api := &plugintest.API{}
+		if record != nil {
+			api.On("KVGet", DELIVERYKEYPREFIX+key).Return(mustMarshal(record), nil)
+		} else {
+			api.On("KVGet", DELIVERYKEYPREFIX+key).Return(nil, nil)
+		}
+		api.On("KVSetWithOptions", DELIVERYKEYPREFIX+key, mock.Anything, mock.Anything).Return(true, func(string, []byte, model.PluginKVSetOptions) *model.AppError {
+			return writeErr
+		})
+		api.On("KVDelete", DELIVERYKEYPREFIX+key).Return(nil)
+		api.On("KVGet", HISTORYKEYPREFIX+"daily").Return(nil, nil)
+		api.On("KVSetWithOptions", HISTORYKEYPREFIX+"daily", mock.Anything, mock.Anything).Return(true, nil)
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", Name: "test"}, nil)
+		plugin.SetAPI(api)
+		plugin.setConfiguration(&configuration{})
+		return plugin, api
+	}
+	storedRecords := func(api *plugintest.API) []deliveryRecord {
+		records := []deliveryRecord{}
+		for _, call := range api.Calls {
+			if call.Method == "KVSetWithOptions" && strings.HasPrefix(call.Arguments.String(0), DELIVERYKEYPREFIX) {
+				record := deliveryRecord{}
+				json.Unmarshal(call.Arguments.Get(1).([]byte), &record)
+				records = append(records, record)
+				assert.Equal(t, int64(7*24*60*60), call.Arguments.Get(2).(model.PluginKVSetOptions).ExpireInSeconds)
+			}
+		}
+		return records
+	}
+
+	t.Run("Records the delivery before and after posting", func(t *testing.T) {
+		plugin, api := setupPlugin(nil)
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Props[deliveryKeyProp] == "daily_1590969600000"
+		})).Return(&model.Post{Id: "post1"}, nil)
+
+		assert.True(t, plugin.deliverOccurrence(msg, scheduledAt))
+		records := storedRecords(api)
+		if assert.Len(t, records, 2) {
+			assert.Equal(t, "node1", records[0].Node)
+			assert.Equal(t, "", records[0].PostID)
+			assert.Equal(t, "post1", records[1].PostID)
+		}
+		assert.Equal(t, uint64(0), plugin.metrics.retries)
+	})
+	t.Run("Forgets failed deliveries", func(t *testing.T) {
+		plugin, api := setupPlugin(nil)
+		api.On("CreatePost", mock.Anything).Return(nil, &model.AppError{Message: "failed"})
+		api.On("LogError", mock.Anything, mock.Anything, mock.Anything)
+
+		assert.False(t, plugin.deliverOccurrence(msg, scheduledAt), "failed posts are tried again")
+		api.AssertCalled(t, "KVDelete", DELIVERYKEYPREFIX+key)
+	})
+	t.Run("Does not post if the delivery cannot be recorded", func(t *testing.T) {
+		plugin, api := setupPlugin(nil)
+		writeErr = &model.AppError{Message: "failed"}
+
+		assert.False(t, plugin.deliverOccurrence(msg, scheduledAt))
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+		assert.Len(t, plugin.recentErrors, 1)
+	})
+	t.Run("Does not post delivered occurrences again", func(t *testing.T) {
+		plugin, api := setupPlugin(&deliveryRecord{Node: "node2", StartedAt: startedAt, PostID: "post1"})
+
+		plugin.deliverOccurrence(msg, scheduledAt)
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+		api.AssertNotCalled(t, "KVSetWithOptions", mock.Anything, mock.Anything, mock.Anything)
+	})
+	t.Run("Finds the post of an interrupted delivery", func(t *testing.T) {
+		plugin, api := setupPlugin(&deliveryRecord{Node: "node2", StartedAt: startedAt})
+		api.On("GetPostsSince", "TestChannel", startedAt).Return(&model.PostList{Posts: map[string]*model.Post{
+			"other": &model.Post{Id: "other", Props: model.StringInterface{deliveryKeyProp: "daily_1"}},
+			"post1": &model.Post{Id: "post1", CreateAt: startedAt + 10, Props: model.StringInterface{deliveryKeyProp: key}},
+		}}, nil)
+
+		plugin.deliverOccurrence(msg, scheduledAt)
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+		records := storedRecords(api)
+		if assert.Len(t, records, 1) {
+			assert.Equal(t, "post1", records[0].PostID)
+		}
+		api.AssertCalled(t, "KVSetWithOptions", HISTORYKEYPREFIX+"daily", mock.MatchedBy(func(value []byte) bool {
+			history := []RunRecord{}
+			json.Unmarshal(value, &history)
+			return len(history) == 1 && history[0].PostID == "post1" && history[0].Outcome == outcomeSuccess
+		}), mock.Anything)
+		assert.Equal(t, uint64(1), plugin.metrics.retries)
+	})
+	t.Run("Posts interrupted deliveries that did not create a post", func(t *testing.T) {
+		plugin, api := setupPlugin(&deliveryRecord{Node: "node2", StartedAt: startedAt})
+		api.On("GetPostsSince", "TestChannel", startedAt).Return(&model.PostList{Posts: map[string]*model.Post{
+			"deleted": &model.Post{Id: "deleted", DeleteAt: startedAt, Props: model.StringInterface{deliveryKeyProp: key}},
+		}}, nil)
+		api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{Id: "post2"}, nil)
+
+		plugin.deliverOccurrence(msg, scheduledAt)
+		api.AssertNumberOfCalls(t, "CreatePost", 1)
+		assert.Equal(t, uint64(1), plugin.metrics.retries)
+	})
+}
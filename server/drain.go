@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	//drainTimeout is how long the plugin waits for running jobs and deliveries when it is deactivated
+	drainTimeout = 30 * time.Second
+)
+
+// getDeliveryPool returns the pool posting the due schedules, or nil if the plugin is not active
+func (p *Plugin) getDeliveryPool() *deliveryPool {
+	p.poolLock.Lock()
+	defer p.poolLock.Unlock()
+	return p.deliveryPool
+}
+
+// getCron returns the cron-instance running the jobs of the plugin, or nil if the plugin is not active
+func (p *Plugin) getCron() *cron.Cron {
+	p.cronLock.Lock()
+	defer p.cronLock.Unlock()
+	return p.pluginCron
+}
+
+// stopDeliveries stops the cron jobs and the delivery pool and waits up to the given timeout for the running jobs and
+// deliveries, so their outcomes are recorded. Deliveries of a previous activation that did not finish in time are
+// waited for as well. It tells whether everything finished in time, the rest keeps running in the background.
+func (p *Plugin) stopDeliveries(timeout time.Duration) bool {
+	cronDone, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.cronLock.Lock()
+	pluginCron := p.pluginCron
+	p.pluginCron = nil
+	p.cronLock.Unlock()
+	if pluginCron != nil {
+		cronDone = pluginCron.Stop()
+	}
+
+	p.poolLock.Lock()
+	pool := p.deliveryPool
+	p.deliveryPool = nil
+	previous := p.drained
+	drained := make(chan struct{})
+	p.drained = drained
+	p.poolLock.Unlock()
+
+	if pool != nil {
+		//queued schedules are dropped, they are still due and posted by the next leader
+		pool.stop()
+	}
+	go func() {
+		if previous != nil {
+			<-previous
+		}
+		<-cronDone.Done()
+		if pool != nil {
+			pool.wait()
+		}
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
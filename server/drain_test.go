@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStopDeliveries(t *testing.T) {
+	schedule := registration{msg: ScheduledMessage{ID: "schedule1"}}
+	setupPlugin := func(deliver func(registration)) *Plugin {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		plugin.pluginCron.Start()
+		plugin.deliveryPool = newDeliveryPool(func() int { return 1 }, deliver)
+		return plugin
+	}
+
+	t.Run("Waits for running deliveries", func(t *testing.T) {
+		var delivered int32
+		started := make(chan struct{})
+		plugin := setupPlugin(func(registration) {
+			close(started)
+			time.Sleep(20 * time.Millisecond)
+			atomic.StoreInt32(&delivered, 1)
+		})
+		plugin.deliveryPool.enqueue(schedule, time.Now())
+		<-started
+
+		assert.True(t, plugin.stopDeliveries(time.Second))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&delivered))
+		assert.Nil(t, plugin.getCron())
+		assert.Nil(t, plugin.getDeliveryPool())
+	})
+	t.Run("Running deliveries can reconcile the schedules", func(t *testing.T) {
+		started := make(chan struct{})
+		var plugin *Plugin
+		plugin = setupPlugin(func(registration) {
+			close(started)
+			//e.g. a delivery disabling its schedule, go test -race reports unsynchronized access to the cron-instance
+			for i := 0; i < 100; i++ {
+				plugin.reconcileSchedules(SchedulerData{})
+			}
+		})
+		plugin.deliveryPool.enqueue(schedule, time.Now())
+		<-started
+
+		assert.True(t, plugin.stopDeliveries(time.Second))
+		assert.Nil(t, plugin.getCron())
+	})
+	t.Run("Gives up after the timeout", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		plugin := setupPlugin(func(registration) {
+			close(started)
+			<-release
+		})
+		plugin.deliveryPool.enqueue(schedule, time.Now())
+		<-started
+
+		assert.False(t, plugin.stopDeliveries(10*time.Millisecond))
+		close(release)
+	})
+	t.Run("Activating again waits for the previous deliveries", func(t *testing.T) {
+		var delivered int32
+		started := make(chan struct{})
+		release := make(chan struct{})
+		plugin := setupPlugin(func(registration) {
+			close(started)
+			<-release
+			atomic.StoreInt32(&delivered, 1)
+		})
+		plugin.deliveryPool.enqueue(schedule, time.Now())
+		<-started
+		assert.False(t, plugin.stopDeliveries(10*time.Millisecond))
+
+		//the next activation stops nothing, but the delivery of the previous one is still running
+		time.AfterFunc(20*time.Millisecond, func() { close(release) })
+		assert.True(t, plugin.stopDeliveries(time.Second))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&delivered))
+	})
+	t.Run("Nothing to stop", func(t *testing.T) {
+		plugin := &Plugin{}
+		assert.True(t, plugin.stopDeliveries(time.Second))
+	})
+}
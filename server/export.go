@@ -0,0 +1,452 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	//exportVersion is the version of the file format written by the export command
+	exportVersion = 1
+
+	exportFormatJSON = "json"
+	exportFormatYAML = "yaml"
+
+	optionDryRun   = "dry-run"
+	optionConflict = "conflict"
+
+	conflictSkip   = "skip"
+	conflictUpdate = "update"
+
+	importActionCreate    = "create"
+	importActionUpdate    = "update"
+	importActionSkip      = "skip"
+	importActionUnchanged = "unchanged"
+	importActionError     = "error"
+
+	//importFileMaxAge is how long after uploading a file it can be imported
+	importFileMaxAge = 10 * time.Minute
+	//importMaxFileSize keeps huge files from being read into memory
+	importMaxFileSize = 1024 * 1024
+
+	exportHint = "[here|team|mine|all] [--format=json|yaml]"
+	importHint = "[--dry-run] [--conflict=skip|update]"
+)
+
+// importExtensions maps the extensions of the files accepted by the import command to their format
+var importExtensions = map[string]string{"json": exportFormatJSON, "yaml": exportFormatYAML, "yml": exportFormatYAML}
+
+// scheduleExport is the content of an exported file. Channels, teams and users are referenced by name, so the
+// schedules can be imported into another Mattermost server.
+type scheduleExport struct {
+	Version   int                `json:"version" yaml:"version"`
+	Schedules []exportedSchedule `json:"schedules" yaml:"schedules"`
+}
+
+// exportedSchedule is a single schedule of an exported file
+type exportedSchedule struct {
+	ID      string `json:"id,omitempty" yaml:"id,omitempty"`
+	Team    string `json:"team" yaml:"team"`
+	Channel string `json:"channel" yaml:"channel"`
+	Owner   string `json:"owner" yaml:"owner"`
+	Cron    string `json:"cron" yaml:"cron"`
+	Message string `json:"message" yaml:"message"`
+	State   string `json:"state,omitempty" yaml:"state,omitempty"`
+}
+
+// importOptions describes how the import command handles the given file
+type importOptions struct {
+	DryRun   bool
+	Conflict string
+}
+
+// importAction is the change the import command makes for a single schedule of the imported file
+type importAction struct {
+	Row      int //position of the schedule in the file, starting at 1
+	Action   string
+	Imported exportedSchedule
+	Schedule ScheduledMessage  //the schedule to create or the updated schedule
+	Existing *ScheduledMessage //the schedule that is updated or skipped
+	Details  string
+}
+
+// parseExportArguments reads the scope and the format from the arguments given to the export command
+func parseExportArguments(text string) (string, string, error) {
+	arguments, options, err := parseArguments(text, optionFormat)
+	if err != nil {
+		return "", "", err
+	}
+	scope := listScopeHere
+	if len(arguments) > 1 {
+		return "", "", errors.New("only a single scope can be given")
+	}
+	if len(arguments) == 1 {
+		scope = arguments[0]
+		if !containsString([]string{listScopeHere, listScopeTeam, listScopeMine, listScopeAll}, scope) {
+			return "", "", errors.Errorf("unknown scope %s", scope)
+		}
+	}
+	format := exportFormatJSON
+	if value, ok := options[optionFormat]; ok {
+		if value != exportFormatJSON && value != exportFormatYAML {
+			return "", "", errors.Errorf("unknown format %s", value)
+		}
+		format = value
+	}
+	return scope, format, nil
+}
+
+// parseImportOptions reads the options given to the import command
+func parseImportOptions(text string) (*importOptions, error) {
+	arguments, options, err := parseArguments(text, optionDryRun, optionConflict)
+	if err != nil {
+		return nil, err
+	}
+	if len(arguments) > 0 {
+		return nil, errors.Errorf("unexpected argument %s", arguments[0])
+	}
+
+	opts := &importOptions{Conflict: conflictSkip}
+	if opts.DryRun, err = parseDryRun(options); err != nil {
+		return nil, err
+	}
+	if conflict, ok := options[optionConflict]; ok {
+		if conflict != conflictSkip && conflict != conflictUpdate {
+			return nil, errors.Errorf("unknown conflict handling %s", conflict)
+		}
+		opts.Conflict = conflict
+	}
+	return opts, nil
+}
+
+// parseDryRun tells whether the given options ask for a dry run
+func parseDryRun(options map[string]string) (bool, error) {
+	dryRun, ok := options[optionDryRun]
+	if !ok {
+		return false, nil
+	}
+	if dryRun != "true" && dryRun != "false" {
+		return false, errors.Errorf("invalid value %s for --%s", dryRun, optionDryRun)
+	}
+	return dryRun == "true", nil
+}
+
+// exportSchedules converts the given schedules into the given format, referencing channels, teams and users by name
+func (p *Plugin) exportSchedules(messages []ScheduledMessage, format string) ([]byte, error) {
+	teamNames := map[string]string{}
+	channels := map[string]*model.Channel{}
+	export := scheduleExport{Version: exportVersion, Schedules: []exportedSchedule{}}
+	for _, msg := range messages {
+		if _, ok := channels[msg.ChannelID]; !ok {
+			channel, appErr := p.API.GetChannel(msg.ChannelID)
+			if appErr != nil {
+				return nil, errors.Wrapf(appErr, "cannot find the channel of the scheduled message %s", msg.ID)
+			}
+			channels[msg.ChannelID] = channel
+		}
+		channel := channels[msg.ChannelID]
+		//direct and group messages do not belong to a team
+		if _, ok := teamNames[channel.TeamId]; !ok && channel.TeamId != "" {
+			team, appErr := p.API.GetTeam(channel.TeamId)
+			if appErr != nil {
+				return nil, errors.Wrapf(appErr, "cannot find the team of the scheduled message %s", msg.ID)
+			}
+			teamNames[channel.TeamId] = team.Name
+		}
+
+		export.Schedules = append(export.Schedules, exportedSchedule{
+			ID:      msg.ID,
+			Team:    teamNames[channel.TeamId],
+			Channel: channel.Name,
+			Owner:   p.getUsername(msg.Creator),
+			Cron:    msg.Cron,
+			Message: msg.Message,
+			State:   msg.State,
+		})
+	}
+
+	if format == exportFormatYAML {
+		return yaml.Marshal(export)
+	}
+	return json.MarshalIndent(export, "", "  ")
+}
+
+// parseScheduleExport reads an exported file in the given format
+func parseScheduleExport(content []byte, format string) (*scheduleExport, error) {
+	export := &scheduleExport{}
+	var err error
+	if format == exportFormatYAML {
+		err = yaml.Unmarshal(content, export)
+	} else {
+		err = json.Unmarshal(content, export)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", strings.ToUpper(format))
+	}
+	if export.Version > exportVersion {
+		return nil, errors.Errorf("the file has been exported by a newer version of the plugin with format version %d, this version supports up to %d", export.Version, exportVersion)
+	}
+	return export, nil
+}
+
+// findUploadedFile returns the most recent file with one of the given extensions the user uploaded into the given
+// channel, together with the format the extension maps to. Only files uploaded within the last importFileMaxAge are
+// considered. The description names the accepted files in the error returned if there is none.
+func (p *Plugin) findUploadedFile(userID string, channelID string, extensions map[string]string, description string) (*model.FileInfo, string, error) {
+	posts, appErr := p.API.GetPostsSince(channelID, toMillis(time.Now().Add(-importFileMaxAge)))
+	if appErr != nil {
+		return nil, "", appErr
+	}
+
+	var found *model.FileInfo
+	for _, post := range posts.Posts {
+		if post.UserId != userID || post.DeleteAt != 0 {
+			continue
+		}
+		for _, fileID := range post.FileIds {
+			info, appErr := p.API.GetFileInfo(fileID)
+			if appErr != nil || (found != nil && info.CreateAt <= found.CreateAt) {
+				continue
+			}
+			if _, ok := extensions[strings.ToLower(info.Extension)]; ok {
+				found = info
+			}
+		}
+	}
+	if found == nil {
+		return nil, "", errors.Errorf("please upload a %s file into this channel first, files uploaded more than %s ago are not imported", description, importFileMaxAge)
+	}
+	if found.Size > importMaxFileSize {
+		return nil, "", errors.Errorf("the file %s is larger than %d bytes", found.Name, importMaxFileSize)
+	}
+	return found, extensions[strings.ToLower(found.Extension)], nil
+}
+
+// planImport decides what to do with every schedule of the imported file. An imported schedule conflicts with an
+// existing one in the same channel, which has the same ID or the same message. Users who are not system admins can
+// only import their own schedules.
+func (p *Plugin) planImport(userID string, export *scheduleExport, existing []ScheduledMessage, opts *importOptions) []importAction {
+	isAdmin := p.isSystemAdmin(userID)
+	teams := map[string]*model.Team{}
+	users := map[string]*model.User{}
+	//the schedules that exist once the previous actions are done, to check the limits and duplicates in the file
+	planned := append([]ScheduledMessage{}, existing...)
+	//rows of the file creating or updating the planned schedule at the given index
+	plannedRows := map[int]int{}
+
+	actions := []importAction{}
+	for index, imported := range export.Schedules {
+		action := importAction{Row: index + 1, Imported: imported}
+		fail := func(format string, args ...interface{}) {
+			action.Action = importActionError
+			action.Details = fmt.Sprintf(format, args...)
+			actions = append(actions, action)
+		}
+
+		if imported.Channel == "" || imported.Cron == "" || imported.Message == "" {
+			fail("The channel, cron and message are required")
+			continue
+		}
+		if imported.Team == "" {
+			fail("The team of the channel is missing, direct messages cannot be imported")
+			continue
+		}
+		if _, ok := teams[imported.Team]; !ok {
+			team, appErr := p.API.GetTeamByName(strings.ToLower(imported.Team))
+			if appErr != nil {
+				team = nil
+			}
+			teams[imported.Team] = team
+		}
+		team := teams[imported.Team]
+		if team == nil {
+			fail("There is no team %s", imported.Team)
+			continue
+		}
+		channel, appErr := p.API.GetChannelByName(team.Id, strings.ToLower(strings.TrimPrefix(imported.Channel, "~")), false)
+		if appErr != nil {
+			fail("There is no channel ~%s in the team %s", strings.TrimPrefix(imported.Channel, "~"), team.Name)
+			continue
+		}
+
+		ownerName := strings.TrimPrefix(imported.Owner, "@")
+		owner := (*model.User)(nil)
+		if ownerName != "" {
+			if _, ok := users[ownerName]; !ok {
+				user, appErr := p.API.GetUserByUsername(ownerName)
+				if appErr != nil || user.DeleteAt != 0 {
+					user = nil
+				}
+				users[ownerName] = user
+			}
+			owner = users[ownerName]
+			if owner == nil {
+				fail("There is no active user @%s", ownerName)
+				continue
+			}
+		} else {
+			//schedules without an owner are imported for the importing user
+			owner = &model.User{Id: userID}
+		}
+		if owner.Id != userID && !isAdmin {
+			fail("Only system admins can import the scheduled messages of other users")
+			continue
+		}
+		if _, err := parseRecurrence(imported.Cron); err != nil {
+			fail("Invalid cron-syntax: %s", err.Error())
+			continue
+		}
+
+		action.Schedule = ScheduledMessage{
+			Creator:   owner.Id,
+			ChannelID: channel.Id,
+			Cron:      imported.Cron,
+			Message:   imported.Message,
+		}
+		//disabled and pending schedules are checked again, so only paused schedules keep their state
+		if imported.State == statePaused {
+			action.Schedule.State = statePaused
+		}
+		conflict := -1
+		for plannedIndex, msg := range planned {
+			if msg.ChannelID == channel.Id && ((imported.ID != "" && msg.ID == imported.ID) || msg.Message == imported.Message) {
+				conflict = plannedIndex
+				break
+			}
+		}
+		if row, ok := plannedRows[conflict]; ok {
+			fail("Duplicate of the scheduled message in row %d", row)
+			continue
+		}
+
+		if conflict >= 0 {
+			current := planned[conflict]
+			action.Existing = &current
+			if current.Cron == imported.Cron && current.Message == imported.Message {
+				action.Action = importActionUnchanged
+				action.Schedule = current
+				actions = append(actions, action)
+				continue
+			}
+			if opts.Conflict == conflictSkip {
+				action.Action = importActionSkip
+				action.Schedule = current
+				action.Details = fmt.Sprintf("Conflicts with the scheduled message %s, add `--%s=%s` to change it", current.ID, optionConflict, conflictUpdate)
+				actions = append(actions, action)
+				continue
+			}
+			if !p.canManage(userID, current) {
+				fail("You are not allowed to change the scheduled message %s", current.ID)
+				continue
+			}
+			updated := current
+			updated.Cron = imported.Cron
+			updated.Message = imported.Message
+			if err := p.checkPolicy(updated, planned); err != nil {
+				fail("Violates the limits set by the admins: %s", err.Error())
+				continue
+			}
+			action.Action = importActionUpdate
+			action.Schedule = updated
+			action.Details = strings.Join(p.describeChanges(&current, &updated, shortenMessage), ", ")
+			planned[conflict] = updated
+			plannedRows[conflict] = action.Row
+			actions = append(actions, action)
+			continue
+		}
+
+		if !p.canPostIn(owner.Id, channel.Id) {
+			fail("@%s is not allowed to post in ~%s", p.getUsername(owner.Id), channel.Name)
+			continue
+		}
+		if err := p.checkDestination(channel.Id); err != nil {
+			fail("Violates the restrictions set by the admins: %s", err.Error())
+			continue
+		}
+		if err := p.checkPolicy(action.Schedule, planned); err != nil {
+			fail("Violates the limits set by the admins: %s", err.Error())
+			continue
+		}
+		action.Action = importActionCreate
+		plannedRows[len(planned)] = action.Row
+		planned = append(planned, action.Schedule)
+		actions = append(actions, action)
+	}
+	return actions
+}
+
+// applyImport carries out the planned actions with the same checks as the add and edit commands. Actions failing
+// because the schedules have been changed in the meantime are turned into errors.
+func (p *Plugin) applyImport(userID string, actions []importAction) {
+	for index := range actions {
+		action := &actions[index]
+		switch action.Action {
+		case importActionCreate:
+			created, scheduleErr := p.createScheduleAs(userID, action.Schedule)
+			if scheduleErr != nil {
+				action.Action = importActionError
+				action.Details = scheduleErr.Message
+				continue
+			}
+			action.Schedule = *created
+			action.Details = fmt.Sprintf("Created the scheduled message %s", created.ID)
+		case importActionUpdate:
+			data := p.ReadFromStorage()
+			_, scheduleErr := p.findPermittedSchedule(userID, action.Existing.ID, data.ScheduledMessages, true)
+			if scheduleErr == nil {
+				_, scheduleErr = p.updateSchedule(userID, action.Existing.ID, action.Schedule.Cron, action.Schedule.Message)
+			}
+			if scheduleErr != nil {
+				action.Action = importActionError
+				action.Details = scheduleErr.Message
+			}
+		}
+	}
+}
+
+// renderImport shows the actions of an import as a table, which is shortened to fit into a single post
+func (p *Plugin) renderImport(fileName string, actions []importAction, dryRun bool) string {
+	counts := map[string]int{}
+	rows := []string{}
+	for _, action := range actions {
+		counts[action.Action]++
+		channel := strings.TrimPrefix(action.Imported.Channel, "~")
+		if action.Imported.Team != "" {
+			channel = action.Imported.Team + "/" + channel
+		}
+		details := action.Details
+		if details == "" && action.Action == importActionCreate {
+			details = shortenMessage(action.Schedule.Message)
+		}
+		rows = append(rows, fmt.Sprintf("| %d | %s | %s | %s | %s | %s |\n", action.Row, action.Action, channel,
+			strings.TrimPrefix(action.Imported.Owner, "@"), action.Imported.Cron, details))
+	}
+
+	summary := []string{}
+	for _, name := range []string{importActionCreate, importActionUpdate, importActionUnchanged, importActionSkip, importActionError} {
+		if counts[name] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[name], name))
+		}
+	}
+	header := fmt.Sprintf("Imported the scheduled messages of %s: %s\n", fileName, strings.Join(summary, ", "))
+	if dryRun {
+		header = fmt.Sprintf("Dry run of importing the scheduled messages of %s, nothing has been changed: %s\n", fileName, strings.Join(summary, ", "))
+	}
+	if len(rows) == 0 {
+		return header + "The file does not contain any scheduled messages..."
+	}
+	header = header + "| Row | Action | Channel | Owner | Cron | Details |\n"
+	header = header + "| :-- | :----- | :------ | :---- | :--- | :------ |\n"
+	pages := paginateRows(rows, len(header)+200)
+	message := header + strings.Join(pages[0], "")
+	if len(pages) > 1 {
+		message = message + fmt.Sprintf("\nShowing the first %d of %d rows.", len(pages[0]), len(rows))
+	}
+	return message
+}
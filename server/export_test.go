@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestExportSchedules(t *testing.T) {
+	messages := []ScheduledMessage{
+		ScheduledMessage{ID: "schedule1", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@daily", Message: "Hello"},
+		ScheduledMessage{ID: "schedule2", Creator: "TestUser", ChannelID: "TestChannel", Cron: "CRON_TZ=Europe/Berlin 0 0 9 * * MON", Message: "Standup\nin 5 minutes", State: statePaused},
+	}
+	setupAPI := func() *plugintest.API {
+		api := &plugintest.API{}
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", TeamId: "TestTeam", Name: "town-square"}, nil)
+		api.On("GetTeam", "TestTeam").Return(&model.Team{Id: "TestTeam", Name: "staging"}, nil)
+		api.On("GetUser", "TestUser").Return(&model.User{Id: "TestUser", Username: "tester"}, nil)
+		return api
+	}
+	expected := []exportedSchedule{
+		exportedSchedule{ID: "schedule1", Team: "staging", Channel: "town-square", Owner: "tester", Cron: "@daily", Message: "Hello"},
+		exportedSchedule{ID: "schedule2", Team: "staging", Channel: "town-square", Owner: "tester", Cron: "CRON_TZ=Europe/Berlin 0 0 9 * * MON", Message: "Standup\nin 5 minutes", State: statePaused},
+	}
+
+	for _, format := range []string{exportFormatJSON, exportFormatYAML} {
+		t.Run("Round trip as "+format, func(t *testing.T) {
+			plugin := &Plugin{}
+			plugin.SetAPI(setupAPI())
+
+			content, err := plugin.exportSchedules(messages, format)
+			assert.NoError(t, err)
+			export, err := parseScheduleExport(content, format)
+			assert.NoError(t, err)
+			assert.Equal(t, exportVersion, export.Version)
+			assert.Equal(t, expected, export.Schedules)
+		})
+	}
+	t.Run("Newer format version", func(t *testing.T) {
+		_, err := parseScheduleExport([]byte(`{"version": 2, "schedules": []}`), exportFormatJSON)
+		assert.EqualError(t, err, "the file has been exported by a newer version of the plugin with format version 2, this version supports up to 1")
+	})
+	t.Run("Only system admins export all schedules", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := setupAPI()
+		api.On("HasPermissionTo", "TestUser", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		plugin.SetAPI(api)
+
+		result, _ := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler export all", UserId: "TestUser"})
+		assert.Equal(t, "Error: Only system admins can export all scheduled messages", result.Text)
+	})
+	t.Run("Unknown format", func(t *testing.T) {
+		plugin := &Plugin{}
+		plugin.SetAPI(setupAPI())
+
+		result, _ := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler export mine --format=xml", UserId: "TestUser"})
+		assert.True(t, strings.HasSuffix(result.Text, "(unknown format xml)"))
+	})
+}
+
+func TestPlanImport(t *testing.T) {
+	existing := []ScheduledMessage{
+		ScheduledMessage{ID: "schedule1", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@daily", Message: "Hello"},
+		ScheduledMessage{ID: "schedule2", Creator: "OtherUser", ChannelID: "TestChannel", Cron: "@weekly", Message: "Weekly report"},
+	}
+	setupPlugin := func(isAdmin bool) *Plugin {
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("HasPermissionTo", mock.AnythingOfType("string"), model.PERMISSION_MANAGE_SYSTEM).Return(isAdmin)
+		api.On("HasPermissionToChannel", mock.AnythingOfType("string"), "TestChannel", mock.Anything).Return(true)
+		api.On("GetTeamByName", "production").Return(&model.Team{Id: "TestTeam", Name: "production"}, nil)
+		api.On("GetTeamByName", mock.AnythingOfType("string")).Return(nil, &model.AppError{Message: "not found"})
+		api.On("GetChannelByName", "TestTeam", "town-square", false).Return(&model.Channel{Id: "TestChannel", TeamId: "TestTeam", Name: "town-square"}, nil)
+		api.On("GetChannelByName", "TestTeam", mock.AnythingOfType("string"), false).Return(nil, &model.AppError{Message: "not found"})
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", TeamId: "TestTeam", Name: "town-square"}, nil)
+		api.On("GetUserByUsername", "tester").Return(&model.User{Id: "TestUser", Username: "tester"}, nil)
+		api.On("GetUserByUsername", "other").Return(&model.User{Id: "OtherUser", Username: "other"}, nil)
+		api.On("GetUser", "TestUser").Return(&model.User{Id: "TestUser", Username: "tester"}, nil)
+		plugin.SetAPI(api)
+		plugin.setConfiguration(&configuration{})
+		return plugin
+	}
+	plan := func(plugin *Plugin, conflict string, schedules ...exportedSchedule) []importAction {
+		return plugin.planImport("TestUser", &scheduleExport{Version: exportVersion, Schedules: schedules}, existing, &importOptions{Conflict: conflict})
+	}
+
+	t.Run("Creates new schedules", func(t *testing.T) {
+		actions := plan(setupPlugin(false), conflictSkip,
+			exportedSchedule{Team: "production", Channel: "town-square", Owner: "tester", Cron: "@hourly", Message: "Drink water", State: statePaused})
+		if assert.Len(t, actions, 1) {
+			assert.Equal(t, importActionCreate, actions[0].Action)
+			assert.Equal(t, ScheduledMessage{Creator: "TestUser", ChannelID: "TestChannel", Cron: "@hourly", Message: "Drink water", State: statePaused}, actions[0].Schedule)
+		}
+	})
+	t.Run("Resolves the names", func(t *testing.T) {
+		actions := plan(setupPlugin(false), conflictSkip,
+			exportedSchedule{Team: "staging", Channel: "town-square", Owner: "tester", Cron: "@hourly", Message: "Hello"},
+			exportedSchedule{Team: "production", Channel: "off-topic", Owner: "tester", Cron: "@hourly", Message: "Hello"},
+			exportedSchedule{Channel: "town-square", Owner: "tester", Cron: "@hourly", Message: "Hello"},
+			exportedSchedule{Team: "production", Channel: "town-square", Owner: "tester", Cron: "every day", Message: "Hello"})
+		if assert.Len(t, actions, 4) {
+			for _, action := range actions {
+				assert.Equal(t, importActionError, action.Action)
+			}
+			assert.Equal(t, "There is no team staging", actions[0].Details)
+			assert.Equal(t, "There is no channel ~off-topic in the team production", actions[1].Details)
+			assert.Equal(t, "The team of the channel is missing, direct messages cannot be imported", actions[2].Details)
+			assert.True(t, strings.HasPrefix(actions[3].Details, "Invalid cron-syntax"))
+		}
+	})
+	t.Run("Only system admins import schedules of other users", func(t *testing.T) {
+		schedule := exportedSchedule{Team: "production", Channel: "town-square", Owner: "@other", Cron: "@hourly", Message: "Drink water"}
+
+		actions := plan(setupPlugin(false), conflictSkip, schedule)
+		assert.Equal(t, "Only system admins can import the scheduled messages of other users", actions[0].Details)
+		actions = plan(setupPlugin(true), conflictSkip, schedule)
+		assert.Equal(t, importActionCreate, actions[0].Action)
+		assert.Equal(t, "OtherUser", actions[0].Schedule.Creator)
+	})
+	t.Run("Handles conflicts", func(t *testing.T) {
+		schedules := []exportedSchedule{
+			exportedSchedule{Team: "production", Channel: "town-square", Owner: "tester", Cron: "@daily", Message: "Hello"},
+			exportedSchedule{ID: "schedule1", Team: "production", Channel: "town-square", Owner: "tester", Cron: "@hourly", Message: "Hello again"},
+		}
+
+		actions := plan(setupPlugin(false), conflictSkip, schedules...)
+		assert.Equal(t, importActionUnchanged, actions[0].Action)
+		assert.Equal(t, importActionSkip, actions[1].Action)
+		assert.Equal(t, "Conflicts with the scheduled message schedule1, add `--conflict=update` to change it", actions[1].Details)
+
+		actions = plan(setupPlugin(false), conflictUpdate, schedules[1])
+		assert.Equal(t, importActionUpdate, actions[0].Action)
+		assert.Equal(t, "schedule1", actions[0].Schedule.ID)
+		assert.Equal(t, "cron: @daily → @hourly, message: Hello → Hello again", actions[0].Details)
+	})
+	t.Run("Reports duplicates in the file", func(t *testing.T) {
+		schedule := exportedSchedule{Team: "production", Channel: "town-square", Owner: "tester", Cron: "@hourly", Message: "Drink water"}
+
+		actions := plan(setupPlugin(false), conflictSkip, schedule, schedule)
+		assert.Equal(t, importActionCreate, actions[0].Action)
+		assert.Equal(t, importActionError, actions[1].Action)
+		assert.Equal(t, "Duplicate of the scheduled message in row 1", actions[1].Details)
+	})
+}
+
+func TestImportCommand(t *testing.T) {
+	schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+		ScheduledMessage{ID: "schedule1", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@daily", Message: "Hello"},
+	}}
+	reqBodyBytes := new(bytes.Buffer)
+	json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+	content := []byte("version: 1\nschedules:\n- team: production\n  channel: town-square\n  owner: tester\n  cron: '@daily'\n  message: Hello\n- team: production\n  channel: town-square\n  owner: tester\n  cron: '@hourly'\n  message: Drink water\n")
+
+	setupPlugin := func() (*Plugin, *plugintest.API) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		api := &plugintest.API{}
+		api.On("GetPostsSince", "TestChannel", mock.AnythingOfType("int64")).Return(&model.PostList{Posts: map[string]*model.Post{
+			"post1": &model.Post{Id: "post1", UserId: "TestUser", FileIds: []string{"file1", "file2"}},
+			"post2": &model.Post{Id: "post2", UserId: "OtherUser", FileIds: []string{"file3"}},
+		}}, nil)
+		api.On("GetFileInfo", "file1").Return(&model.FileInfo{Id: "file1", Name: "notes.txt", Extension: "txt", CreateAt: 2}, nil)
+		api.On("GetFileInfo", "file2").Return(&model.FileInfo{Id: "file2", Name: "schedules.yml", Extension: "yml", CreateAt: 1}, nil)
+		api.On("GetFileInfo", "file3").Return(&model.FileInfo{Id: "file3", Name: "plan.csv", Extension: "csv", CreateAt: 3}, nil)
+		api.On("GetFile", "file2").Return(content, nil)
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("HasPermissionTo", "TestUser", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		api.On("HasPermissionToChannel", "TestUser", "TestChannel", mock.Anything).Return(true)
+		api.On("GetTeamByName", "production").Return(&model.Team{Id: "TestTeam", Name: "production"}, nil)
+		api.On("GetChannelByName", "TestTeam", "town-square", false).Return(&model.Channel{Id: "TestChannel", TeamId: "TestTeam", Name: "town-square"}, nil)
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", TeamId: "TestTeam", Name: "town-square"}, nil)
+		api.On("GetUserByUsername", "tester").Return(&model.User{Id: "TestUser", Username: "tester"}, nil)
+		plugin.SetAPI(api)
+		plugin.setConfiguration(&configuration{})
+		return plugin, api
+	}
+
+	t.Run("Dry run", func(t *testing.T) {
+		plugin, api := setupPlugin()
+
+		result, _ := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler import --dry-run", UserId: "TestUser", ChannelId: "TestChannel"})
+		lines := strings.Split(result.Text, "\n")
+		assert.Equal(t, "Dry run of importing the scheduled messages of schedules.yml, nothing has been changed: 1 create, 1 unchanged", lines[0])
+		assert.Equal(t, "| 1 | unchanged | production/town-square | tester | @daily |  |", lines[3])
+		assert.Equal(t, "| 2 | create | production/town-square | tester | @hourly | Drink water |", lines[4])
+		api.AssertNotCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
+	})
+	t.Run("Creates the schedules", func(t *testing.T) {
+		plugin, api := setupPlugin()
+		api.On("KVSetWithOptions", KVKEY, mock.Anything, mock.Anything).Return(true, nil)
+		expectSchedulerStorage(api)
+		recorded := expectAudit(api)
+
+		result, _ := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler import", UserId: "TestUser", ChannelId: "TestChannel"})
+		assert.True(t, strings.HasPrefix(result.Text, "Imported the scheduled messages of schedules.yml: 1 create, 1 unchanged\n"))
+		api.AssertNumberOfCalls(t, "KVSet", 1+1) //revision and audit
+		api.AssertCalled(t, "KVSetWithOptions", KVKEY, mock.Anything, mock.Anything)
+		if assert.Len(t, recorded(), 1) {
+			assert.Equal(t, auditActionCreate, recorded()[0].Action)
+			assert.Equal(t, "Drink water", recorded()[0].After.Message)
+		}
+	})
+	t.Run("No file uploaded", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+
+		result, _ := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler import", UserId: "OtherUser", ChannelId: "TestChannel"})
+		assert.Equal(t, "Error: Cannot import the scheduled messages, please upload a JSON or YAML file into this channel first, files uploaded more than 10m0s ago are not imported", result.Text)
+	})
+}
@@ -2,52 +2,140 @@ package main
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mattermost/mattermost-server/v5/model"
 )
 
-func getIndex(command string, givenArray []ScheduledMessage) (int, *model.CommandResponse) {
-	commandFields := strings.Fields(command)
-
-	for _, field := range commandFields {
-		index, err := strconv.Atoi(field)
-		if err != nil {
-			continue //the field we got is not a valid index, let's check the next fields...
-		}
-		if (len(givenArray) <= index) || (index < 0) {
-			return -1, &model.CommandResponse{
-				ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
-				Text:         fmt.Sprintf("Error: Your given index of %d is not valid", index),
-			}
-		}
-		return index, nil
-	}
-
-	return -1, &model.CommandResponse{
-		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
-		Text:         "Error: Please enter a valid index",
+// shortenMessage makes the given message fit into a single cell of a markdown table
+func shortenMessage(message string) string {
+	const maxLength = 50
+	message = strings.Join(strings.Fields(message), " ")
+	if runes := []rune(message); len(runes) > maxLength {
+		message = string(runes[:maxLength]) + "..."
 	}
+	return strings.Replace(message, "|", "\\|", -1)
 }
 
-func (p *Plugin) postMessage(msg ScheduledMessage) *model.CommandResponse {
-	post := &model.Post{
+// buildPost renders the post that is created when the given message is delivered
+func buildPost(msg ScheduledMessage) *model.Post {
+	return &model.Post{
 		ChannelId: msg.ChannelID,
 		RootId:    msg.TeamID,
 		UserId:    msg.Creator,
 		Message:   msg.Message,
 	}
+}
+
+// postMessage delivers the given message and records the run in its history
+func (p *Plugin) postMessage(msg ScheduledMessage, scheduledAt time.Time, trigger string) (*model.Post, *model.CommandResponse) {
+	createdPost, _, errResponse := p.postMessageWithOutcome(msg, scheduledAt, trigger)
+	return createdPost, errResponse
+}
+
+// postMessageWithOutcome is postMessage, which also returns the outcome recorded in the history
+func (p *Plugin) postMessageWithOutcome(msg ScheduledMessage, scheduledAt time.Time, trigger string) (*model.Post, string, *model.CommandResponse) {
+	post := buildPost(msg)
+	if trigger == triggerCron {
+		//the key of the occurrence allows to find duplicated posts
+		post.AddProp(deliveryKeyProp, deliveryKey(msg.ID, scheduledAt))
+	}
+
+	record := RunRecord{
+		ScheduledAt: toMillis(scheduledAt),
+		ExecutedAt:  toMillis(time.Now()),
+		Node:        nodeName(),
+		Trigger:     trigger,
+		Outcome:     outcomeSuccess,
+	}
+	defer func() {
+		p.appendRunRecord(msg.ID, record)
+		p.metrics.observeFire(trigger, record.Outcome, fromMillis(record.ExecutedAt).Sub(scheduledAt))
+	}()
+
+	//the restrictions may have changed since the message has been scheduled
+	if err := p.checkDestination(msg.ChannelID); err != nil {
+		p.API.LogWarn("Skipped scheduled post", "id", msg.ID, "err", err.Error())
+		p.recordError("Skipped the scheduled message %s: %s", msg.ID, err.Error())
+		record.Outcome = outcomeSkipped
+		record.Error = err.Error()
+		return nil, record.Outcome, &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         fmt.Sprintf("Error: Cannot post the scheduled message, %s", err.Error()),
+		}
+	}
 
 	//TODO: This posts every given text as simple text, even when the text should be a command like `/topic Test123`. How can I post a command?
-	if _, err := p.API.CreatePost(post); err != nil {
+	createdPost, err := p.API.CreatePost(post)
+	if err != nil {
 		const errorMessage = "Error: Failed to create scheduled post"
 		p.API.LogError(errorMessage, "err", err.Error())
-		return &model.CommandResponse{
+		p.recordError("Failed to post the scheduled message %s: %s", msg.ID, err.Error())
+		record.Outcome = outcomeFailed
+		record.Error = err.Error()
+		return nil, record.Outcome, &model.CommandResponse{
 			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
 			Text:         errorMessage,
 		}
 	}
+	record.PostID = createdPost.Id
 
-	return &model.CommandResponse{}
+	return createdPost, record.Outcome, nil
+}
+
+// getPermalink returns the link to the given post, or an empty string if the site URL is not configured
+func (p *Plugin) getPermalink(teamID string, postID string) string {
+	config := p.API.GetConfig()
+	if config == nil || config.ServiceSettings.SiteURL == nil || *config.ServiceSettings.SiteURL == "" {
+		return ""
+	}
+	team, err := p.API.GetTeam(teamID)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/pl/%s", strings.TrimSuffix(*config.ServiceSettings.SiteURL, "/"), team.Name, postID)
+}
+
+// getUserLocation returns the timezone configured by the given user, falling back to the servers timezone
+func (p *Plugin) getUserLocation(userID string) *time.Location {
+	user, err := p.API.GetUser(userID)
+	if err != nil {
+		return time.Local
+	}
+	location, locErr := time.LoadLocation(user.GetPreferredTimezone())
+	if locErr != nil {
+		return time.Local
+	}
+	return location
+}
+
+// getUsername returns the username of the given user, or the ID if the user cannot be found
+func (p *Plugin) getUsername(userID string) string {
+	user, err := p.API.GetUser(userID)
+	if err != nil {
+		return userID
+	}
+	return user.Username
+}
+
+// sendFile sends the given content to the user as a file in a direct message from the plugins bot
+func (p *Plugin) sendFile(userID string, fileName string, content []byte, message string) error {
+	channel, appErr := p.API.GetDirectChannel(userID, p.botUserID)
+	if appErr != nil {
+		return appErr
+	}
+	fileInfo, appErr := p.API.UploadFile(content, channel.Id, fileName)
+	if appErr != nil {
+		return appErr
+	}
+	if _, appErr := p.API.CreatePost(&model.Post{
+		ChannelId: channel.Id,
+		UserId:    p.botUserID,
+		Message:   message,
+		FileIds:   []string{fileInfo.Id},
+	}); appErr != nil {
+		return appErr
+	}
+	return nil
 }
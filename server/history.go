@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	//maxHistoryRecords limits how many runs are kept per schedule, older runs are dropped
+	maxHistoryRecords = 20
+
+	outcomeSuccess = "success"
+	outcomeFailed  = "failed"
+	outcomeSkipped = "skipped"
+
+	triggerCron     = "cron"
+	triggerManual   = "manual"
+	triggerTransfer = "transfer"
+
+	//timeFormat is used whenever we show a time to the user
+	timeFormat = "2006-01-02 15:04:05 MST"
+)
+
+// RunRecord stores information about a single execution of a scheduled message
+type RunRecord struct {
+	ScheduledAt int64  `json:"scheduledAt"` //time in millis the run was due
+	ExecutedAt  int64  `json:"executedAt"`  //time in millis the run actually happened
+	Node        string `json:"node"`        //hostname of the server that executed the run
+	Trigger     string `json:"trigger"`     //tells whether the run has been triggered by cron or manually
+	Outcome     string `json:"outcome"`
+	PostID      string `json:"postID,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Note        string `json:"note,omitempty"` //describes changes to the schedule that are recorded in the history
+}
+
+// appendRunRecord adds the given record to the history of the given schedule, dropping the oldest records if needed.
+// Like the schedules, the history is read again if someone else recorded a run in the meantime.
+func (p *Plugin) appendRunRecord(scheduleID string, record RunRecord) {
+	for attempt := 0; attempt < kvCompareAttempts; attempt++ {
+		history, oldValue := p.readHistory(scheduleID)
+		history = append(history, record)
+		if len(history) > maxHistoryRecords {
+			history = history[len(history)-maxHistoryRecords:]
+		}
+		ok, err := p.WriteHistoryToStorage(scheduleID, history, oldValue)
+		if err != nil {
+			p.API.LogError("Failed to record a run", "id", scheduleID, "err", err.Error())
+			p.recordError("Failed to record a run of the scheduled message %s: %s", scheduleID, err.Error())
+			return
+		}
+		if ok {
+			return
+		}
+	}
+	p.API.LogError("Failed to record a run, the history kept changing", "id", scheduleID)
+	p.recordError("Failed to record a run of the scheduled message %s: the history kept changing", scheduleID)
+}
+
+// nodeName returns a name identifying the server node the plugin is running on
+func nodeName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return hostname
+}
+
+// toMillis converts the given time into the millisecond timestamps used by Mattermost
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// fromMillis converts the given millisecond timestamp into a time
+func fromMillis(millis int64) time.Time {
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
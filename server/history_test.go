@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAppendRunRecord(t *testing.T) {
+	t.Run("Keeps runs recorded at the same time", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		//another run is recorded after the history has been read the first time
+		reads := 0
+		stored, _ := json.Marshal([]RunRecord{{PostID: "post1", Outcome: outcomeSuccess}})
+		api.On("KVGet", HISTORYKEYPREFIX+"schedule1").Return(func(string) []byte {
+			reads++
+			if reads == 1 {
+				return nil
+			}
+			return stored
+		}, nil)
+		api.On("KVSetWithOptions", HISTORYKEYPREFIX+"schedule1", mock.Anything, model.PluginKVSetOptions{Atomic: true}).Return(false, nil)
+		api.On("KVSetWithOptions", HISTORYKEYPREFIX+"schedule1", mock.Anything, mock.Anything).Return(true, nil)
+		plugin.SetAPI(api)
+
+		plugin.appendRunRecord("schedule1", RunRecord{PostID: "post2", Outcome: outcomeSuccess})
+		api.AssertNumberOfCalls(t, "KVSetWithOptions", 2)
+		history := []RunRecord{}
+		json.Unmarshal(api.Calls[len(api.Calls)-1].Arguments.Get(1).([]byte), &history)
+		if assert.Len(t, history, 2) {
+			assert.Equal(t, "post1", history[0].PostID)
+			assert.Equal(t, "post2", history[1].PostID)
+		}
+	})
+	t.Run("Gives up when the history keeps changing", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", HISTORYKEYPREFIX+"schedule1").Return(nil, nil)
+		api.On("KVSetWithOptions", HISTORYKEYPREFIX+"schedule1", mock.Anything, mock.Anything).Return(false, nil)
+		api.On("LogError", mock.AnythingOfType("string"), "id", "schedule1").Return()
+		plugin.SetAPI(api)
+
+		plugin.appendRunRecord("schedule1", RunRecord{PostID: "post2", Outcome: outcomeSuccess})
+		api.AssertNumberOfCalls(t, "KVSetWithOptions", kvCompareAttempts)
+		api.AssertCalled(t, "LogError", mock.AnythingOfType("string"), "id", "schedule1")
+		assert.Len(t, plugin.recentErrors, 1)
+	})
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+// ServeHTTP handles HTTP requests to the plugin, which are sent to /plugins/<plugin id>/. Other plugins send their
+// requests using PluginHTTP, in this case they set the Mattermost-User-Id header themselves.
+func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == routeApproval && r.Method == http.MethodPost:
+		p.handleApproval(w, r)
+	case r.URL.Path == routeMetrics && r.Method == http.MethodGet:
+		p.handleMetrics(w, r)
+	case r.URL.Path == routeAPIStatus && r.Method == http.MethodGet:
+		p.handleStatus(w, r)
+	case r.URL.Path == routeAPISchedules || strings.HasPrefix(r.URL.Path, routeAPISchedules+"/"):
+		p.handleAPI(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	integrityActionDisable  = "disable"
+	integrityActionTransfer = "transfer"
+	integrityActionDelete   = "delete"
+
+	//integritySweepSchedule is the cron-syntax of the sweep checking all schedules for deactivated users and archived channels
+	integritySweepSchedule = "@every 1h"
+
+	//channelMembersPerPage is the number of channel members requested at once when looking for a channel admin
+	channelMembersPerPage = 100
+)
+
+// integrityProblem describes why a schedule cannot post anymore
+type integrityProblem struct {
+	Reason string
+	//CreatorInactive is set if the creator has been deactivated or deleted
+	CreatorInactive bool
+	//ChannelGone is set if the channel has been archived or deleted
+	ChannelGone bool
+}
+
+// integrityChange is how the integrity sweep changes a schedule with a problem
+type integrityChange struct {
+	Before  ScheduledMessage
+	After   *ScheduledMessage //nil if the schedule is deleted
+	Problem *integrityProblem
+	//Notified are the users told about the change, besides the new owner of a transferred schedule
+	Notified []string
+	Note     string
+}
+
+// findIntegrityProblem checks whether the creator of the given schedule and its channel still exist
+func (p *Plugin) findIntegrityProblem(msg ScheduledMessage) *integrityProblem {
+	channel, appErr := p.API.GetChannel(msg.ChannelID)
+	if appErr != nil {
+		if appErr.StatusCode != http.StatusNotFound {
+			return nil //we cannot tell whether the channel is gone, let's check again during the next sweep
+		}
+		return &integrityProblem{Reason: "The channel has been deleted", ChannelGone: true}
+	}
+	if channel.DeleteAt != 0 {
+		return &integrityProblem{Reason: fmt.Sprintf("The channel ~%s has been archived", channel.Name), ChannelGone: true}
+	}
+
+	user, appErr := p.API.GetUser(msg.Creator)
+	if appErr != nil {
+		if appErr.StatusCode != http.StatusNotFound {
+			return nil
+		}
+		return &integrityProblem{Reason: "The creator has been deleted", CreatorInactive: true}
+	}
+	if user.DeleteAt != 0 {
+		return &integrityProblem{Reason: fmt.Sprintf("The creator @%s has been deactivated", user.Username), CreatorInactive: true}
+	}
+
+	if !p.isMemberOf(msg.Creator, msg.ChannelID) {
+		return &integrityProblem{Reason: fmt.Sprintf("The creator @%s has left the channel ~%s", user.Username, channel.Name)}
+	}
+
+	return nil
+}
+
+// findChannelAdmins returns the IDs of all active admins of the given channel
+func (p *Plugin) findChannelAdmins(channelID string) []string {
+	admins := []string{}
+	for page := 0; ; page++ {
+		members, appErr := p.API.GetChannelMembers(channelID, page, channelMembersPerPage)
+		if appErr != nil || members == nil {
+			break
+		}
+		for _, member := range *members {
+			if !member.SchemeAdmin {
+				continue
+			}
+			if user, appErr := p.API.GetUser(member.UserId); appErr == nil && user.DeleteAt == 0 && !user.IsBot {
+				admins = append(admins, member.UserId)
+			}
+		}
+		if len(*members) < channelMembersPerPage {
+			break
+		}
+	}
+	return admins
+}
+
+// runIntegritySweep checks all schedules for deactivated creators, archived channels and creators who left the
+// channel and applies the action configured by the admins to them
+func (p *Plugin) runIntegritySweep() {
+	//the sweep runs on every node of a cluster, but only the leader notifies the users and changes the schedules
+	if !p.isLeader() {
+		return
+	}
+	action := p.getConfiguration().IntegrityAction
+	if action == "" {
+		action = integrityActionDisable
+	}
+
+	//the problems are looked up before the schedules are stored, as it takes several requests per schedule
+	changes := []integrityChange{}
+	for _, msg := range p.ReadFromStorage().ScheduledMessages {
+		if msg.GetState() == stateDisabled {
+			continue
+		}
+		problem := p.findIntegrityProblem(msg)
+		if problem == nil {
+			continue
+		}
+		change := integrityChange{Before: msg, Problem: problem}
+
+		//channel admins are told about the problem when the creator cannot act on it anymore
+		if !problem.CreatorInactive {
+			change.Notified = append(change.Notified, msg.Creator)
+		} else if !problem.ChannelGone {
+			change.Notified = append(change.Notified, p.findChannelAdmins(msg.ChannelID)...)
+		}
+
+		after := msg
+		switch {
+		case action == integrityActionDelete:
+			changes = append(changes, change)
+			continue
+		case action == integrityActionTransfer && !problem.ChannelGone:
+			if newOwner := p.findNewOwner(msg); newOwner != "" {
+				after.Creator = newOwner
+				change.After = &after
+				change.Note = fmt.Sprintf("Transferred from @%s to @%s: %s", p.getUsername(msg.Creator), p.getUsername(newOwner), problem.Reason)
+				changes = append(changes, change)
+				continue
+			}
+		}
+
+		//disabling is the fallback when the schedule cannot be transferred
+		after.State = stateDisabled
+		after.Reason = problem.Reason
+		change.After = &after
+		changes = append(changes, change)
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	applied := []integrityChange{}
+	err := p.updateStorage(func(data *SchedulerData) error {
+		applied = []integrityChange{}
+		remaining := []ScheduledMessage{}
+		for _, msg := range data.ScheduledMessages {
+			change := findIntegrityChange(changes, msg)
+			if change == nil {
+				remaining = append(remaining, msg)
+				continue
+			}
+			applied = append(applied, *change)
+			if change.After != nil {
+				remaining = append(remaining, *change.After)
+			}
+		}
+		if len(applied) == 0 {
+			return errStorageUnchanged
+		}
+		data.ScheduledMessages = remaining
+		return nil
+	})
+	if err != nil {
+		p.recordError("Failed to store the schedules changed by the integrity sweep: %s", err.Error())
+		return
+	}
+
+	for _, change := range applied {
+		msg, problem := change.Before, change.Problem
+		switch {
+		case change.After == nil:
+			p.ClearHistoryFromStorage(msg.ID)
+			p.recordAudit(auditActorSystem, auditActionDelete, &msg, nil, problem.Reason)
+			p.notifyUsers(change.Notified, fmt.Sprintf("The scheduled message %s has been removed: %s.", msg.ID, problem.Reason))
+			p.API.LogInfo("Removed scheduled message", "id", msg.ID, "reason", problem.Reason)
+		case change.After.Creator != msg.Creator:
+			newOwner := change.After.Creator
+			p.recordTransfer(msg.ID, change.Note)
+			p.recordAudit(auditActorSystem, auditActionTransfer, &msg, change.After, change.Note)
+			p.notifyUsers(append(change.Notified, newOwner), fmt.Sprintf("The scheduled message %s has been transferred to you: %s.", msg.ID, problem.Reason))
+			p.API.LogInfo("Transferred scheduled message", "id", msg.ID, "owner", newOwner, "reason", problem.Reason)
+		default:
+			p.recordAudit(auditActorSystem, auditActionDisable, &msg, change.After, problem.Reason)
+			p.notifyUsers(change.Notified, fmt.Sprintf("The scheduled message %s has been disabled: %s.", msg.ID, problem.Reason))
+			p.API.LogInfo("Disabled scheduled message", "id", msg.ID, "reason", problem.Reason)
+		}
+	}
+}
+
+// findIntegrityChange returns the change found by the integrity sweep for the given schedule, or nil if there is none
+// or the schedule has been changed since it has been checked
+func findIntegrityChange(changes []integrityChange, msg ScheduledMessage) *integrityChange {
+	for index := range changes {
+		if changes[index].Before == msg {
+			return &changes[index]
+		}
+	}
+	return nil
+}
+
+// findNewOwner returns a channel admin who can take over the given schedule, or an empty string if there is none
+func (p *Plugin) findNewOwner(msg ScheduledMessage) string {
+	for _, admin := range p.findChannelAdmins(msg.ChannelID) {
+		if admin != msg.Creator {
+			return admin
+		}
+	}
+	return ""
+}
+
+// notifyUsers sends the given message to the given users as a direct message from the plugins bot
+func (p *Plugin) notifyUsers(userIDs []string, message string) {
+	for _, userID := range userIDs {
+		channel, appErr := p.API.GetDirectChannel(userID, p.botUserID)
+		if appErr != nil {
+			p.API.LogError("Failed to get direct channel", "user", userID, "err", appErr.Error())
+			continue
+		}
+		if _, appErr := p.API.CreatePost(&model.Post{
+			ChannelId: channel.Id,
+			UserId:    p.botUserID,
+			Message:   message,
+		}); appErr != nil {
+			p.API.LogError("Failed to notify user", "user", userID, "err", appErr.Error())
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestIntegritySweep(t *testing.T) {
+	schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+		ScheduledMessage{ID: "healthy", Creator: "ActiveUser", ChannelID: "OpenChannel", Cron: "@daily"},
+		ScheduledMessage{ID: "deactivated", Creator: "DeactivatedUser", ChannelID: "OpenChannel", Cron: "@daily"},
+		ScheduledMessage{ID: "archived", Creator: "ActiveUser", ChannelID: "ArchivedChannel", Cron: "@daily"},
+		ScheduledMessage{ID: "left", Creator: "LeftUser", ChannelID: "OpenChannel", Cron: "@daily"},
+		ScheduledMessage{ID: "disabled", Creator: "DeactivatedUser", ChannelID: "OpenChannel", Cron: "@daily", State: stateDisabled},
+	}}
+	reqBodyBytes := new(bytes.Buffer)
+	json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+	setupAPI := func(checkStored func(data SchedulerData) bool) *plugintest.API {
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		expectAudit(api)
+		expectSchedulerStorage(api)
+		api.On("KVSetWithOptions", KVKEY, mock.MatchedBy(func(value []byte) bool {
+			data := SchedulerData{}
+			json.Unmarshal(value, &data)
+			return checkStored(data)
+		}), mock.Anything).Return(true, nil)
+		api.On("GetChannel", "OpenChannel").Return(&model.Channel{Id: "OpenChannel", Name: "open"}, nil)
+		api.On("GetChannel", "ArchivedChannel").Return(&model.Channel{Id: "ArchivedChannel", Name: "archived", DeleteAt: 1}, nil)
+		api.On("GetUser", "ActiveUser").Return(&model.User{Id: "ActiveUser", Username: "active"}, nil)
+		api.On("GetUser", "LeftUser").Return(&model.User{Id: "LeftUser", Username: "left"}, nil)
+		api.On("GetUser", "DeactivatedUser").Return(&model.User{Id: "DeactivatedUser", Username: "deactivated", DeleteAt: 1}, nil)
+		api.On("GetUser", "AdminUser").Return(&model.User{Id: "AdminUser", Username: "admin"}, nil)
+		api.On("GetChannelMember", "OpenChannel", "ActiveUser").Return(&model.ChannelMember{}, nil)
+		api.On("GetChannelMember", "OpenChannel", "LeftUser").Return(nil, &model.AppError{StatusCode: http.StatusNotFound})
+		api.On("GetChannelMembers", "OpenChannel", 0, channelMembersPerPage).Return(&model.ChannelMembers{
+			model.ChannelMember{UserId: "ActiveUser"},
+			model.ChannelMember{UserId: "AdminUser", SchemeAdmin: true},
+		}, nil)
+		api.On("GetDirectChannel", mock.AnythingOfType("string"), "BotUser").Return(&model.Channel{Id: "DirectChannel"}, nil)
+		api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+		api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+		api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+		return api
+	}
+	states := func(data SchedulerData) map[string]string {
+		result := map[string]string{}
+		for _, msg := range data.ScheduledMessages {
+			result[msg.ID] = msg.GetState() + ":" + msg.Creator
+		}
+		return result
+	}
+
+	t.Run("Disable", func(t *testing.T) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds()), botUserID: "BotUser", leaderUntil: time.Now().Add(time.Minute)}
+		api := setupAPI(func(data SchedulerData) bool {
+			return assert.ObjectsAreEqual(map[string]string{
+				"healthy":     "active:ActiveUser",
+				"deactivated": "disabled:DeactivatedUser",
+				"archived":    "disabled:ActiveUser",
+				"left":        "disabled:LeftUser",
+				"disabled":    "disabled:DeactivatedUser",
+			}, states(data))
+		})
+		plugin.SetAPI(api)
+		plugin.setConfiguration(&configuration{IntegrityAction: integrityActionDisable})
+
+		plugin.runIntegritySweep()
+		api.AssertExpectations(t)
+		//the channel admin is notified about the deactivated creator, the others about their own schedules
+		api.AssertCalled(t, "GetDirectChannel", "AdminUser", "BotUser")
+		api.AssertCalled(t, "GetDirectChannel", "ActiveUser", "BotUser")
+		api.AssertCalled(t, "GetDirectChannel", "LeftUser", "BotUser")
+		api.AssertNotCalled(t, "GetDirectChannel", "DeactivatedUser", "BotUser")
+	})
+	t.Run("Transfer", func(t *testing.T) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds()), botUserID: "BotUser", leaderUntil: time.Now().Add(time.Minute)}
+		api := setupAPI(func(data SchedulerData) bool {
+			return assert.ObjectsAreEqual(map[string]string{
+				"healthy":     "active:ActiveUser",
+				"deactivated": "active:AdminUser",
+				"archived":    "disabled:ActiveUser",
+				"left":        "active:AdminUser",
+				"disabled":    "disabled:DeactivatedUser",
+			}, states(data))
+		})
+		api.On("KVGet", mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, HISTORYKEYPREFIX) })).Return(nil, nil)
+		api.On("KVSetWithOptions", mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, HISTORYKEYPREFIX) }), mock.MatchedBy(func(value []byte) bool {
+			history := []RunRecord{}
+			json.Unmarshal(value, &history)
+			return len(history) == 1 && history[0].Trigger == triggerTransfer && strings.Contains(history[0].Note, " to @admin: The creator @")
+		}), mock.Anything).Return(true, nil)
+		plugin.SetAPI(api)
+		plugin.setConfiguration(&configuration{IntegrityAction: integrityActionTransfer})
+
+		plugin.runIntegritySweep()
+		api.AssertExpectations(t)
+		assert.Equal(t, 3, len(plugin.registry))
+		assert.Equal(t, "AdminUser", plugin.registry["deactivated"].msg.Creator)
+	})
+	t.Run("Delete", func(t *testing.T) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds()), botUserID: "BotUser", leaderUntil: time.Now().Add(time.Minute)}
+		api := setupAPI(func(data SchedulerData) bool {
+			return assert.ObjectsAreEqual(map[string]string{
+				"healthy":  "active:ActiveUser",
+				"disabled": "disabled:DeactivatedUser",
+			}, states(data))
+		})
+		api.On("KVDelete", mock.AnythingOfType("string")).Return(nil)
+		plugin.SetAPI(api)
+		plugin.setConfiguration(&configuration{IntegrityAction: integrityActionDelete})
+
+		plugin.runIntegritySweep()
+		api.AssertExpectations(t)
+		api.AssertCalled(t, "KVDelete", HISTORYKEYPREFIX+"deactivated")
+	})
+	t.Run("Leaves the schedules to the leader", func(t *testing.T) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds()), botUserID: "BotUser"}
+		api := &plugintest.API{}
+		plugin.SetAPI(api)
+
+		plugin.runIntegritySweep()
+		api.AssertNotCalled(t, "KVGet", KVKEY)
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	//leaderLeaseDuration is how long a node stays the leader without renewing its lease
+	leaderLeaseDuration = 60 * time.Second
+	//leaderRenewSchedule is the cron-syntax of the job renewing the lease, it has to run well within the lease duration
+	leaderRenewSchedule = "@every 20s"
+)
+
+// leaderLease is stored in the KVStore by the node that posts the scheduled messages. In a cluster every node runs
+// the plugin, but only the leader delivers schedules triggered by cron, so messages are not posted once per node.
+type leaderLease struct {
+	NodeID    string `json:"nodeID"`    //random ID of the plugin instance, which is unique even for nodes sharing a hostname
+	Node      string `json:"node"`      //hostname of the leader
+	ExpiresAt int64  `json:"expiresAt"` //time in millis the lease ends if it is not renewed
+}
+
+// readLeaderLease returns the current lease and its stored value, or nil if there is none
+func (p *Plugin) readLeaderLease() (*leaderLease, []byte) {
+	kvData, appErr := p.API.KVGet(LEADERKEY)
+	if appErr != nil || kvData == nil {
+		return nil, nil
+	}
+	lease := &leaderLease{}
+	if err := json.Unmarshal(kvData, lease); err != nil {
+		return nil, kvData
+	}
+	return lease, kvData
+}
+
+// renewLeadership acquires the lease if it is free or expired and renews it if this node holds it already. A node
+// that just became the leader applies the current configuration, which may have changed while there was no leader.
+func (p *Plugin) renewLeadership() {
+	wasLeader := p.isLeader()
+	now := time.Now()
+	current, currentValue := p.readLeaderLease()
+	if current != nil && current.NodeID != p.nodeID && current.ExpiresAt > toMillis(now) {
+		p.setLeaderUntil(time.Time{})
+		return
+	}
+
+	expiresAt := now.Add(leaderLeaseDuration)
+	newValue, _ := json.Marshal(leaderLease{NodeID: p.nodeID, Node: nodeName(), ExpiresAt: toMillis(expiresAt)})
+	//the lease is only written if nobody else changed it in the meantime
+	ok, appErr := p.API.KVSetWithOptions(LEADERKEY, newValue, model.PluginKVSetOptions{Atomic: true, OldValue: currentValue})
+	if appErr != nil {
+		p.recordError("Failed to renew the leader lease: %s", appErr.Error())
+	}
+	if appErr != nil || !ok {
+		p.setLeaderUntil(time.Time{})
+		return
+	}
+	p.setLeaderUntil(expiresAt)
+	if !wasLeader {
+		p.applyConfigurationToSchedules()
+	}
+}
+
+// releaseLeadership gives up the lease, so another node can take over right away
+func (p *Plugin) releaseLeadership() {
+	current, currentValue := p.readLeaderLease()
+	if current != nil && current.NodeID == p.nodeID {
+		p.API.KVCompareAndDelete(LEADERKEY, currentValue)
+	}
+	p.setLeaderUntil(time.Time{})
+}
+
+// isLeader tells whether this node currently holds the lease and should post the scheduled messages
+func (p *Plugin) isLeader() bool {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	return time.Now().Before(p.leaderUntil)
+}
+
+func (p *Plugin) setLeaderUntil(until time.Time) {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	p.leaderUntil = until
+}
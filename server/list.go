@@ -0,0 +1,180 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	listScopeHere = "here"
+	listScopeTeam = "team"
+	listScopeMine = "mine"
+	listScopeAll  = "all"
+
+	optionOwner = "owner"
+	optionState = "state"
+	optionText  = "text"
+	optionPage  = "page"
+
+	//listPageSize is the maximum number of schedules shown on one page
+	listPageSize = 20
+	//listMaxLength keeps a page of the list below the size limit of a post
+	listMaxLength = model.POST_MESSAGE_MAX_RUNES_V1
+)
+
+// listFilter describes which schedules the list command shows
+type listFilter struct {
+	Scope   string
+	OwnerID string
+	State   string
+	Text    string
+	Page    int
+}
+
+// listEntry is a single schedule shown by the list command
+type listEntry struct {
+	Index   int //index of the schedule in the stored data
+	Message ScheduledMessage
+	NextRun time.Time //zero if the schedule will not run
+}
+
+// parseListFilter reads the filter from the arguments given to the list command
+func (p *Plugin) parseListFilter(text string) (*listFilter, error) {
+	arguments, options, err := parseArguments(text, optionOwner, optionState, optionText, optionPage)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &listFilter{Scope: listScopeHere, Page: 1}
+	if len(arguments) > 1 {
+		return nil, errors.New("only a single scope can be given")
+	}
+	if len(arguments) == 1 {
+		filter.Scope = arguments[0]
+		if !containsString([]string{listScopeHere, listScopeTeam, listScopeMine, listScopeAll}, filter.Scope) {
+			return nil, errors.Errorf("unknown scope %s", filter.Scope)
+		}
+	}
+
+	if owner, ok := options[optionOwner]; ok {
+		user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(owner, "@"))
+		if appErr != nil {
+			return nil, errors.Errorf("unknown user %s", owner)
+		}
+		filter.OwnerID = user.Id
+	}
+	if state, ok := options[optionState]; ok {
+		if !containsString([]string{stateActive, statePaused, stateDisabled, statePending}, state) {
+			return nil, errors.Errorf("unknown state %s", state)
+		}
+		filter.State = state
+	}
+	filter.Text = strings.ToLower(options[optionText])
+	if page, ok := options[optionPage]; ok {
+		filter.Page, err = strconv.Atoi(page)
+		if err != nil || filter.Page < 1 {
+			return nil, errors.Errorf("invalid page %s", page)
+		}
+	}
+
+	return filter, nil
+}
+
+// filterSchedules returns the schedules matching the given filter, sorted by their next run
+func (p *Plugin) filterSchedules(messages []ScheduledMessage, filter *listFilter, args *model.CommandArgs) []listEntry {
+	channels := map[string]*model.Channel{}
+	getChannel := func(channelID string) *model.Channel {
+		if _, ok := channels[channelID]; !ok {
+			channel, err := p.API.GetChannel(channelID)
+			if err != nil {
+				channel = nil
+			}
+			channels[channelID] = channel
+		}
+		return channels[channelID]
+	}
+
+	members := map[string]bool{}
+	isMember := func(channelID string) bool {
+		if _, ok := members[channelID]; !ok {
+			members[channelID] = p.isMemberOf(args.UserId, channelID)
+		}
+		return members[channelID]
+	}
+
+	now := time.Now()
+	entries := []listEntry{}
+	for index, msg := range messages {
+		switch filter.Scope {
+		case listScopeHere:
+			if msg.ChannelID != args.ChannelId {
+				continue
+			}
+		case listScopeTeam:
+			channel := getChannel(msg.ChannelID)
+			if channel == nil || channel.TeamId != args.TeamId {
+				continue
+			}
+		case listScopeMine:
+			if msg.Creator != args.UserId {
+				continue
+			}
+		}
+		if filter.OwnerID != "" && msg.Creator != filter.OwnerID {
+			continue
+		}
+		if filter.State != "" && msg.GetState() != filter.State {
+			continue
+		}
+		if filter.Text != "" && !strings.Contains(strings.ToLower(msg.Message), filter.Text) {
+			continue
+		}
+		//users only see the schedules of channels they are members of, admins see everything when listing all of them
+		if filter.Scope != listScopeAll && msg.Creator != args.UserId && !isMember(msg.ChannelID) {
+			continue
+		}
+
+		entry := listEntry{Index: index, Message: msg}
+		if msg.GetState() == stateActive {
+			if runs, err := nextRuns(msg.Cron, now, 1); err == nil && len(runs) == 1 {
+				entry.NextRun = runs[0]
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	//schedules that do not run at all are listed last
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].NextRun.IsZero() || entries[j].NextRun.IsZero() {
+			return !entries[i].NextRun.IsZero() && entries[j].NextRun.IsZero()
+		}
+		return entries[i].NextRun.Before(entries[j].NextRun)
+	})
+	return entries
+}
+
+// paginateRows splits the given rows into pages, each page holds at most listPageSize rows and stays below listMaxLength
+func paginateRows(rows []string, reservedLength int) [][]string {
+	pages := [][]string{}
+	page := []string{}
+	pageLength := reservedLength
+	for _, row := range rows {
+		rowLength := len([]rune(row))
+		if len(page) > 0 && (len(page) >= listPageSize || pageLength+rowLength > listMaxLength) {
+			pages = append(pages, page)
+			page = []string{}
+			pageLength = reservedLength
+		}
+		page = append(page, row)
+		pageLength += rowLength
+	}
+	if len(page) > 0 {
+		pages = append(pages, page)
+	}
+	return pages
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaginateRows(t *testing.T) {
+	t.Run("Rows per page", func(t *testing.T) {
+		rows := make([]string, listPageSize*2+1)
+		pages := paginateRows(rows, 0)
+		assert.Equal(t, 3, len(pages))
+		assert.Equal(t, listPageSize, len(pages[0]))
+		assert.Equal(t, 1, len(pages[2]))
+	})
+	t.Run("Length per page", func(t *testing.T) {
+		longRow := strings.Repeat("x", listMaxLength/2)
+		pages := paginateRows([]string{longRow, longRow, longRow}, 100)
+		assert.Equal(t, 3, len(pages))
+	})
+	t.Run("No rows", func(t *testing.T) {
+		assert.Equal(t, 0, len(paginateRows([]string{}, 0)))
+	})
+}
@@ -16,7 +16,7 @@ const manifestStr = `
   "name": "Scheduler Plugin",
   "description": "This plugin lets users schedule messages using cron-syntax",
   "version": "1.0.0",
-  "min_server_version": "5.12.0",
+  "min_server_version": "5.20.0",
   "server": {
     "executables": {
       "linux-amd64": "server/dist/plugin-linux-amd64",
@@ -24,6 +24,138 @@ const manifestStr = `
       "windows-amd64": "server/dist/plugin-windows-amd64.exe"
     },
     "executable": ""
+  },
+  "settings_schema": {
+    "header": "Limits and restrictions for the scheduled messages. Set a limit to 0 to disable it. Schedules that violate a changed restriction are disabled.",
+    "footer": "",
+    "settings": [
+      {
+        "key": "MaxSchedulesPerUser",
+        "display_name": "Maximum schedules per user:",
+        "type": "number",
+        "help_text": "The maximum number of scheduled messages a single user can create.",
+        "placeholder": "",
+        "default": 0
+      },
+      {
+        "key": "MaxSchedulesPerChannel",
+        "display_name": "Maximum schedules per channel:",
+        "type": "number",
+        "help_text": "The maximum number of scheduled messages posting into a single channel.",
+        "placeholder": "",
+        "default": 0
+      },
+      {
+        "key": "MinIntervalSeconds",
+        "display_name": "Minimum interval (seconds):",
+        "type": "number",
+        "help_text": "The minimum time between two posts of a scheduled message. Use this to prevent schedules that run every second from flooding channels.",
+        "placeholder": "",
+        "default": 60
+      },
+      {
+        "key": "MaxMessageLength",
+        "display_name": "Maximum message length:",
+        "type": "number",
+        "help_text": "The maximum number of characters of a scheduled message.",
+        "placeholder": "",
+        "default": 0
+      },
+      {
+        "key": "AllowedTeams",
+        "display_name": "Allowed teams:",
+        "type": "text",
+        "help_text": "Comma-separated names of the teams schedules may post into. Leave empty to allow all teams.",
+        "placeholder": "",
+        "default": ""
+      },
+      {
+        "key": "DeniedTeams",
+        "display_name": "Denied teams:",
+        "type": "text",
+        "help_text": "Comma-separated names of the teams schedules must never post into.",
+        "placeholder": "",
+        "default": ""
+      },
+      {
+        "key": "AllowedChannels",
+        "display_name": "Allowed channels:",
+        "type": "text",
+        "help_text": "Comma-separated names of the channels schedules may post into, e.g. town-square. Leave empty to allow all channels.",
+        "placeholder": "",
+        "default": ""
+      },
+      {
+        "key": "DeniedChannels",
+        "display_name": "Denied channels:",
+        "type": "text",
+        "help_text": "Comma-separated names of the channels schedules must never post into, e.g. announcements.",
+        "placeholder": "",
+        "default": ""
+      },
+      {
+        "key": "DenyDirectMessages",
+        "display_name": "Deny direct messages:",
+        "type": "bool",
+        "help_text": "When true, schedules cannot post into direct and group messages.",
+        "placeholder": "",
+        "default": false
+      },
+      {
+        "key": "ApprovalChannels",
+        "display_name": "Channels requiring approval:",
+        "type": "text",
+        "help_text": "Comma-separated names of channels, e.g. announcements. New or changed schedules of these channels are only posted once a channel admin approves them.",
+        "placeholder": "",
+        "default": ""
+      },
+      {
+        "key": "MetricsToken",
+        "display_name": "Metrics token:",
+        "type": "generated",
+        "help_text": "Prometheus can scrape the metrics at /plugins/com.nilsbrinkmann.scheduler/metrics?token=\u003ctoken\u003e. System admins can see the metrics without the token. The metrics can only be scraped by system admins if no token has been generated.",
+        "placeholder": "",
+        "default": ""
+      },
+      {
+        "key": "IntegrityAction",
+        "display_name": "Action for orphaned schedules:",
+        "type": "dropdown",
+        "help_text": "What happens to schedules whose creator has been deactivated or left the channel, or whose channel has been archived. Schedules of archived channels cannot be transferred and are disabled instead.",
+        "placeholder": "",
+        "default": "disable",
+        "options": [
+          {
+            "display_name": "Disable the schedule",
+            "value": "disable"
+          },
+          {
+            "display_name": "Transfer the schedule to a channel admin",
+            "value": "transfer"
+          },
+          {
+            "display_name": "Delete the schedule",
+            "value": "delete"
+          }
+        ]
+      },
+      {
+        "key": "DeliveryWorkers",
+        "display_name": "Parallel deliveries:",
+        "type": "number",
+        "help_text": "The number of scheduled messages posted at the same time. Schedules due at the same time wait for a free worker and are posted in the order they are due.",
+        "placeholder": "",
+        "default": 4
+      },
+      {
+        "key": "DeliveryJitterSeconds",
+        "display_name": "Delivery jitter (seconds):",
+        "type": "number",
+        "help_text": "Delays every schedule by up to this many seconds, so schedules due at the same time, e.g. Mondays at 09:00, don't post all at once. Every schedule is always delayed by the same time. Set to 0 to post schedules right when they are due.",
+        "placeholder": "",
+        "default": 0
+      }
+    ]
   }
 }
 `
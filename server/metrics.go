@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	routeMetrics = "/metrics"
+
+	metricsNamespace = "mattermost_plugin_scheduler"
+
+	storageOperationRead          = "read"
+	storageOperationWrite         = "write"
+	storageOperationReadHistory   = "read_history"
+	storageOperationWriteHistory  = "write_history"
+	storageOperationWriteAudit    = "write_audit"
+	storageOperationReadAudit     = "read_audit"
+	storageOperationReadDue       = "read_due"
+	storageOperationWriteDue      = "write_due"
+	storageOperationReadDelivery  = "read_delivery"
+	storageOperationWriteDelivery = "write_delivery"
+	storageOperationReadBatch     = "read_batch"
+	storageOperationWriteBatch    = "write_batch"
+)
+
+var (
+	//latencyBuckets are the upper bounds in seconds of the histogram of the delivery latency
+	latencyBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300}
+	//durationBuckets are the upper bounds in seconds of the histograms of storage operations and commands
+	durationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+)
+
+// histogram counts observations in buckets, like the Prometheus histogram type
+type histogram struct {
+	buckets []float64
+	counts  []uint64 //counts[i] is the number of observations <= buckets[i]
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(value float64) {
+	for index, bound := range h.buckets {
+		if value <= bound {
+			h.counts[index]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// metrics collects what is exposed to Prometheus. All methods can be called on a nil instance, which does nothing.
+type metrics struct {
+	lock sync.Mutex
+
+	fires           map[string]uint64 //keyed by the labels trigger and outcome
+	retries         uint64
+	deliveryLatency *histogram
+	storage         map[string]*histogram //keyed by the label operation
+	commands        map[string]*histogram //keyed by the label command
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		fires:           map[string]uint64{},
+		deliveryLatency: newHistogram(latencyBuckets),
+		storage:         map[string]*histogram{},
+		commands:        map[string]*histogram{},
+	}
+}
+
+// observeFire records a delivery of a schedule. The latency is only recorded for deliveries triggered by cron,
+// it is the time between the time the delivery was due and the time the post has been created.
+func (m *metrics) observeFire(trigger string, outcome string, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.fires[labels("trigger", trigger, "outcome", outcome)]++
+	if trigger == triggerCron {
+		m.deliveryLatency.observe(latency.Seconds())
+	}
+}
+
+// observeRetry records that the delivery of a schedule is tried again
+func (m *metrics) observeRetry() {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.retries++
+}
+
+// observeStorage records the duration of a storage operation that started at the given time, use it with defer
+func (m *metrics) observeStorage(operation string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.observeDuration(m.storage, labels("operation", operation), start)
+}
+
+// observeCommand records the duration of a command that started at the given time, use it with defer
+func (m *metrics) observeCommand(command string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.observeDuration(m.commands, labels("command", command), start)
+}
+
+func (m *metrics) observeDuration(histograms map[string]*histogram, key string, start time.Time) {
+	duration := time.Since(start).Seconds()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := histograms[key]; !ok {
+		histograms[key] = newHistogram(durationBuckets)
+	}
+	histograms[key].observe(duration)
+}
+
+// labels renders the given pairs of label names and values in the format of the Prometheus exposition
+func labels(pairs ...string) string {
+	rendered := []string{}
+	for index := 0; index+1 < len(pairs); index += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[index+1])
+		rendered = append(rendered, fmt.Sprintf(`%s="%s"`, pairs[index], value))
+	}
+	return strings.Join(rendered, ",")
+}
+
+// write renders all metrics in the Prometheus text format, schedules contains the number of schedules per state and
+// queued the number of schedules waiting to be posted
+func (m *metrics) write(w io.Writer, schedules map[string]int, queued int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	writeHeader := func(name string, metricType string, help string) {
+		fmt.Fprintf(w, "# HELP %s_%s %s\n# TYPE %s_%s %s\n", metricsNamespace, name, help, metricsNamespace, name, metricType)
+	}
+	writeHistogram := func(name string, key string, h *histogram) {
+		separator := ""
+		if key != "" {
+			separator = ","
+		}
+		for index, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_%s_bucket{%s%sle=\"%g\"} %d\n", metricsNamespace, name, key, separator, bound, h.counts[index])
+		}
+		fmt.Fprintf(w, "%s_%s_bucket{%s%sle=\"+Inf\"} %d\n", metricsNamespace, name, key, separator, h.count)
+		fmt.Fprintf(w, "%s_%s_sum{%s} %g\n", metricsNamespace, name, key, h.sum)
+		fmt.Fprintf(w, "%s_%s_count{%s} %d\n", metricsNamespace, name, key, h.count)
+	}
+	writeHistograms := func(name string, histograms map[string]*histogram) {
+		for _, key := range sortedKeys(histograms) {
+			writeHistogram(name, key, histograms[key])
+		}
+	}
+
+	writeHeader("schedules", "gauge", "Number of stored schedules per state.")
+	for _, state := range []string{stateActive, statePaused, stateDisabled, statePending} {
+		fmt.Fprintf(w, "%s_schedules{%s} %d\n", metricsNamespace, labels("state", state), schedules[state])
+	}
+
+	writeHeader("fires_total", "counter", "Number of deliveries of schedules per trigger and outcome.")
+	fireKeys := []string{}
+	for key := range m.fires {
+		fireKeys = append(fireKeys, key)
+	}
+	sort.Strings(fireKeys)
+	for _, key := range fireKeys {
+		fmt.Fprintf(w, "%s_fires_total{%s} %d\n", metricsNamespace, key, m.fires[key])
+	}
+
+	writeHeader("delivery_queue_length", "gauge", "Number of due schedules waiting to be posted or being posted.")
+	fmt.Fprintf(w, "%s_delivery_queue_length %d\n", metricsNamespace, queued)
+
+	writeHeader("delivery_retries_total", "counter", "Number of deliveries that have been tried again.")
+	fmt.Fprintf(w, "%s_delivery_retries_total %d\n", metricsNamespace, m.retries)
+
+	writeHeader("delivery_latency_seconds", "histogram", "Time between the time a delivery was due and the time it has been posted.")
+	writeHistogram("delivery_latency_seconds", "", m.deliveryLatency)
+
+	writeHeader("storage_duration_seconds", "histogram", "Duration of the operations on the KV store.")
+	writeHistograms("storage_duration_seconds", m.storage)
+
+	writeHeader("command_duration_seconds", "histogram", "Duration of the slash commands.")
+	writeHistograms("command_duration_seconds", m.commands)
+}
+
+func sortedKeys(histograms map[string]*histogram) []string {
+	keys := []string{}
+	for key := range histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// handleMetrics exposes the metrics to Prometheus. Requests have to be made by a system admin or have to send the
+// token from the plugin settings as query parameter token, the server removes the Authorization header from requests
+// to plugins.
+func (p *Plugin) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	token := p.getConfiguration().MetricsToken
+	givenToken := r.URL.Query().Get("token")
+	tokenValid := token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(givenToken)) == 1
+	userID := r.Header.Get("Mattermost-User-Id")
+	if !tokenValid && (userID == "" || !p.isSystemAdmin(userID)) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	if p.metrics == nil {
+		http.Error(w, "Metrics are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	schedules := map[string]int{}
+	for _, msg := range p.ReadFromStorage().ScheduledMessages {
+		schedules[msg.GetState()]++
+	}
+	queued := 0
+	if pool := p.getDeliveryPool(); pool != nil {
+		queued = pool.size()
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	p.metrics.write(w, schedules, queued)
+}
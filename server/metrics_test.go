@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("Histogram", func(t *testing.T) {
+		h := newHistogram([]float64{1, 5})
+		h.observe(0.5)
+		h.observe(3)
+		h.observe(10)
+		assert.Equal(t, []uint64{1, 2}, h.counts)
+		assert.Equal(t, uint64(3), h.count)
+		assert.Equal(t, 13.5, h.sum)
+	})
+	t.Run("Nil metrics do nothing", func(t *testing.T) {
+		var m *metrics
+		m.observeFire(triggerCron, outcomeSuccess, time.Second)
+		m.observeRetry()
+		m.observeStorage(storageOperationRead, time.Now())
+		m.observeCommand("add", time.Now())
+	})
+	t.Run("Exposition", func(t *testing.T) {
+		m := newMetrics()
+		m.observeFire(triggerCron, outcomeSuccess, 2*time.Second)
+		m.observeFire(triggerCron, outcomeSuccess, 20*time.Millisecond)
+		m.observeFire(triggerManual, outcomeFailed, time.Hour)
+		m.observeStorage(storageOperationRead, time.Now())
+
+		buffer := new(bytes.Buffer)
+		m.write(buffer, map[string]int{stateActive: 3, statePaused: 1}, 2)
+		output := buffer.String()
+		assert.Contains(t, output, `mattermost_plugin_scheduler_schedules{state="active"} 3`+"\n")
+		assert.Contains(t, output, `mattermost_plugin_scheduler_schedules{state="pending"} 0`+"\n")
+		assert.Contains(t, output, `mattermost_plugin_scheduler_fires_total{trigger="cron",outcome="success"} 2`+"\n")
+		assert.Contains(t, output, "mattermost_plugin_scheduler_delivery_queue_length 2\n")
+		assert.Contains(t, output, `mattermost_plugin_scheduler_fires_total{trigger="manual",outcome="failed"} 1`+"\n")
+		//manual runs are not due at a certain time, so they have no latency
+		assert.Contains(t, output, `mattermost_plugin_scheduler_delivery_latency_seconds_bucket{le="0.05"} 1`+"\n")
+		assert.Contains(t, output, `mattermost_plugin_scheduler_delivery_latency_seconds_bucket{le="+Inf"} 2`+"\n")
+		assert.Contains(t, output, `mattermost_plugin_scheduler_storage_duration_seconds_count{operation="read"} 1`+"\n")
+		assert.Contains(t, output, "# TYPE mattermost_plugin_scheduler_command_duration_seconds histogram\n")
+	})
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+		ScheduledMessage{ID: "active", Cron: "@daily"},
+		ScheduledMessage{ID: "disabled", Cron: "@daily", State: stateDisabled},
+	}}
+	reqBodyBytes := new(bytes.Buffer)
+	json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+	setupPlugin := func() *Plugin {
+		plugin := &Plugin{metrics: newMetrics()}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("HasPermissionTo", "AdminUser", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+		api.On("HasPermissionTo", "TestUser", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		plugin.SetAPI(api)
+		plugin.setConfiguration(&configuration{MetricsToken: "secret"})
+		return plugin
+	}
+	scrape := func(plugin *Plugin, userID string, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if userID != "" {
+			r.Header.Set("Mattermost-User-Id", userID)
+		}
+		w := httptest.NewRecorder()
+		plugin.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("With token", func(t *testing.T) {
+		w := scrape(setupPlugin(), "", routeMetrics+"?token=secret")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `mattermost_plugin_scheduler_schedules{state="disabled"} 1`)
+	})
+	t.Run("As system admin", func(t *testing.T) {
+		w := scrape(setupPlugin(), "AdminUser", routeMetrics)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+	t.Run("Unauthorized", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, scrape(setupPlugin(), "", routeMetrics+"?token=wrong").Code)
+		assert.Equal(t, http.StatusUnauthorized, scrape(setupPlugin(), "TestUser", routeMetrics).Code)
+	})
+	t.Run("Deliveries are counted", func(t *testing.T) {
+		plugin := setupPlugin()
+		api := plugin.API.(*plugintest.API)
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", Name: "town-square", Type: model.CHANNEL_OPEN}, nil)
+		api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{Id: "CreatedPost"}, nil)
+		api.On("KVGet", HISTORYKEYPREFIX+"schedule1").Return(nil, nil)
+		api.On("KVSetWithOptions", HISTORYKEYPREFIX+"schedule1", mock.Anything, mock.Anything).Return(true, nil)
+
+		plugin.postMessage(ScheduledMessage{ID: "schedule1", ChannelID: "TestChannel", Message: "Hello"}, time.Now(), triggerCron)
+		output := scrape(plugin, "AdminUser", routeMetrics).Body.String()
+		assert.Contains(t, output, `mattermost_plugin_scheduler_fires_total{trigger="cron",outcome="success"} 1`)
+		assert.Contains(t, output, `mattermost_plugin_scheduler_storage_duration_seconds_count{operation="write_history"} 1`)
+	})
+}
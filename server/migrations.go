@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	//schemaVersion is the version of the stored schedules written by this version of the plugin
+	schemaVersion = 2
+	//maxMigrationAttempts is how often the migration is tried when another node changes the schedules meanwhile
+	maxMigrationAttempts = 3
+)
+
+// migration upgrades stored schedules from the previous version to its version. Migrations work on the raw JSON, so
+// they keep working when the types of the plugin change.
+type migration struct {
+	version     int
+	description string
+	migrate     func(data map[string]interface{}) error
+}
+
+// migrations contains all migrations in the order they are applied. Add a migration and increase schemaVersion
+// whenever the structure of the stored schedules changes.
+var migrations = []migration{
+	{1, "assign IDs to schedules stored without one", migrateAssignIDs},
+	{2, "remove the IDs of cron-jobs stored by the first versions", migrateRemoveCronIDs},
+}
+
+// migrateStorage upgrades the stored schedules to the current schema version. The stored schedules are backed up
+// before they are changed. Schedules stored by a newer version of the plugin are left alone and an error is returned.
+func (p *Plugin) migrateStorage() error {
+	for attempt := 0; attempt < maxMigrationAttempts; attempt++ {
+		kvData, appErr := p.API.KVGet(KVKEY)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to read the schedules")
+		}
+		if kvData == nil {
+			return nil //nothing has been stored yet
+		}
+
+		migrated, version, err := migrateSchedulerData(kvData)
+		if err != nil {
+			return err
+		}
+		if version == schemaVersion {
+			return nil
+		}
+
+		if appErr := p.API.KVSet(fmt.Sprintf("%s%d", BACKUPKEYPREFIX, version), kvData); appErr != nil {
+			return errors.Wrap(appErr, "failed to back up the schedules")
+		}
+		//another node of the cluster may migrate the schedules at the same time
+		ok, appErr := p.API.KVSetWithOptions(KVKEY, migrated, model.PluginKVSetOptions{Atomic: true, OldValue: kvData})
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to store the migrated schedules")
+		}
+		if ok {
+			p.API.LogInfo("Migrated the stored schedules", "from", version, "to", schemaVersion)
+			return nil
+		}
+	}
+	return errors.New("the schedules kept changing while they were migrated")
+}
+
+// migrateSchedulerData applies all migrations the given stored schedules need. It returns the migrated schedules and
+// the version they had, or an error if they have been stored by a newer version of the plugin.
+func migrateSchedulerData(kvData []byte) ([]byte, int, error) {
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(kvData, &data); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to parse the stored schedules")
+	}
+
+	version := 0
+	if value, ok := data["version"].(float64); ok {
+		version = int(value)
+	}
+	if version > schemaVersion {
+		return nil, version, errors.Errorf("the schedules have been stored by a newer version of the plugin with schema version %d, this version supports up to %d", version, schemaVersion)
+	}
+
+	for _, step := range migrations {
+		if step.version <= version {
+			continue
+		}
+		if err := step.migrate(data); err != nil {
+			return nil, version, errors.Wrapf(err, "failed to %s", step.description)
+		}
+		data["version"] = step.version
+	}
+
+	migrated, err := json.Marshal(data)
+	if err != nil {
+		return nil, version, errors.Wrap(err, "failed to encode the migrated schedules")
+	}
+	return migrated, version, nil
+}
+
+// storedSchedules returns the schedules of the given raw stored data
+func storedSchedules(data map[string]interface{}) ([]map[string]interface{}, error) {
+	list, ok := data["ScheduledMessage"].([]interface{})
+	if !ok {
+		if data["ScheduledMessage"] != nil {
+			return nil, errors.New("the schedules are not a list")
+		}
+		return nil, nil
+	}
+	schedules := []map[string]interface{}{}
+	for _, item := range list {
+		schedule, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("a schedule is not an object")
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+// migrateAssignIDs gives every schedule an ID, the first versions of the plugin identified schedules by their index
+func migrateAssignIDs(data map[string]interface{}) error {
+	schedules, err := storedSchedules(data)
+	if err != nil {
+		return err
+	}
+	for _, schedule := range schedules {
+		if id, _ := schedule["id"].(string); id == "" {
+			schedule["id"] = model.NewId()
+		}
+	}
+	return nil
+}
+
+// migrateRemoveCronIDs removes the IDs of the cron-jobs, the first versions of the plugin stored them although they
+// are only valid while the plugin is running
+func migrateRemoveCronIDs(data map[string]interface{}) error {
+	schedules, err := storedSchedules(data)
+	if err != nil {
+		return err
+	}
+	for _, schedule := range schedules {
+		delete(schedule, "CronID")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// firstVersionData are schedules as they have been stored by the first versions of the plugin
+const firstVersionData = `{"ScheduledMessage":[` +
+	`{"creator":"TestUser","teamID":"","channelID":"TestChannel","cron":"@daily","message":"Hello","CronID":1},` +
+	`{"creator":"TestUser","teamID":"","channelID":"TestChannel","cron":"@hourly","message":"Again","CronID":2}]}`
+
+func TestMigrations(t *testing.T) {
+	parse := func(value string) map[string]interface{} {
+		data := map[string]interface{}{}
+		json.Unmarshal([]byte(value), &data)
+		return data
+	}
+
+	t.Run("Versions are in order", func(t *testing.T) {
+		for index, step := range migrations {
+			assert.Equal(t, index+1, step.version)
+		}
+		assert.Equal(t, schemaVersion, migrations[len(migrations)-1].version)
+	})
+	t.Run("1: Assigns IDs", func(t *testing.T) {
+		data := parse(`{"ScheduledMessage":[{"message":"Without ID"},{"id":"schedule2","message":"With ID"}]}`)
+		assert.Nil(t, migrateAssignIDs(data))
+
+		schedules, _ := storedSchedules(data)
+		assert.Len(t, schedules[0]["id"], 26)
+		assert.Equal(t, "schedule2", schedules[1]["id"])
+	})
+	t.Run("1: Nothing stored", func(t *testing.T) {
+		data := parse(`{}`)
+		assert.Nil(t, migrateAssignIDs(data))
+		assert.Nil(t, data["ScheduledMessage"])
+	})
+	t.Run("1: Invalid schedules", func(t *testing.T) {
+		assert.NotNil(t, migrateAssignIDs(parse(`{"ScheduledMessage":{"message":"Not a list"}}`)))
+		assert.NotNil(t, migrateAssignIDs(parse(`{"ScheduledMessage":["Not an object"]}`)))
+	})
+	t.Run("2: Removes the IDs of cron-jobs", func(t *testing.T) {
+		data := parse(`{"ScheduledMessage":[{"id":"schedule1","message":"Hello","CronID":3}]}`)
+		assert.Nil(t, migrateRemoveCronIDs(data))
+
+		schedules, _ := storedSchedules(data)
+		assert.Equal(t, map[string]interface{}{"id": "schedule1", "message": "Hello"}, schedules[0])
+	})
+	t.Run("Migrates the first version", func(t *testing.T) {
+		migrated, version, err := migrateSchedulerData([]byte(firstVersionData))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, version)
+
+		data := SchedulerData{}
+		assert.Nil(t, json.Unmarshal(migrated, &data))
+		assert.Equal(t, schemaVersion, data.Version)
+		if assert.Len(t, data.ScheduledMessages, 2) {
+			assert.NotEmpty(t, data.ScheduledMessages[0].ID)
+			assert.NotEqual(t, data.ScheduledMessages[0].ID, data.ScheduledMessages[1].ID)
+			assert.Equal(t, "@hourly", data.ScheduledMessages[1].Cron)
+		}
+		assert.NotContains(t, string(migrated), "CronID")
+	})
+	t.Run("Only applies newer migrations", func(t *testing.T) {
+		migrated, version, err := migrateSchedulerData([]byte(`{"version":1,"ScheduledMessage":[{"message":"Hello","CronID":1}]}`))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, version)
+		assert.Equal(t, `{"ScheduledMessage":[{"message":"Hello"}],"version":2}`, string(migrated))
+	})
+	t.Run("Refuses newer versions", func(t *testing.T) {
+		_, version, err := migrateSchedulerData([]byte(`{"version":99,"ScheduledMessage":[]}`))
+		assert.Equal(t, 99, version)
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "the schedules have been stored by a newer version of the plugin with schema version 99, this version supports up to 2", err.Error())
+		}
+	})
+	t.Run("Invalid data", func(t *testing.T) {
+		_, _, err := migrateSchedulerData([]byte(`not json`))
+		assert.NotNil(t, err)
+	})
+}
+
+func TestMigrateStorage(t *testing.T) {
+	t.Run("Backs up and migrates old schedules", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return([]byte(firstVersionData), nil)
+		api.On("KVSet", BACKUPKEYPREFIX+"0", []byte(firstVersionData)).Return(nil)
+		api.On("KVSetWithOptions", KVKEY, mock.MatchedBy(func(value []byte) bool {
+			data := SchedulerData{}
+			json.Unmarshal(value, &data)
+			return data.Version == schemaVersion && len(data.ScheduledMessages) == 2
+		}), model.PluginKVSetOptions{Atomic: true, OldValue: []byte(firstVersionData)}).Return(true, nil)
+		api.On("LogInfo", "Migrated the stored schedules", "from", 0, "to", schemaVersion)
+		plugin.SetAPI(api)
+
+		assert.Nil(t, plugin.migrateStorage())
+		api.AssertExpectations(t)
+	})
+	t.Run("Tries again if another node changed the schedules", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return([]byte(firstVersionData), nil).Once()
+		api.On("KVGet", KVKEY).Return([]byte(`{"version":2,"ScheduledMessage":[]}`), nil).Once()
+		api.On("KVSet", BACKUPKEYPREFIX+"0", mock.Anything).Return(nil)
+		api.On("KVSetWithOptions", KVKEY, mock.Anything, mock.Anything).Return(false, nil)
+		plugin.SetAPI(api)
+
+		assert.Nil(t, plugin.migrateStorage())
+		api.AssertNumberOfCalls(t, "KVSetWithOptions", 1)
+	})
+	t.Run("Leaves current schedules alone", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return([]byte(`{"version":2,"ScheduledMessage":[]}`), nil)
+		plugin.SetAPI(api)
+
+		assert.Nil(t, plugin.migrateStorage())
+		api.AssertNotCalled(t, "KVSet", mock.Anything, mock.Anything)
+	})
+	t.Run("Nothing stored", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(nil, nil)
+		plugin.SetAPI(api)
+
+		assert.Nil(t, plugin.migrateStorage())
+	})
+	t.Run("Refuses schedules of a newer version", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return([]byte(`{"version":99,"ScheduledMessage":[]}`), nil)
+		plugin.SetAPI(api)
+
+		assert.NotNil(t, plugin.migrateStorage())
+		api.AssertNotCalled(t, "KVSet", mock.Anything, mock.Anything)
+	})
+	t.Run("Refuses to activate", func(t *testing.T) {
+		plugin := &Plugin{}
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return([]byte(`{"version":99,"ScheduledMessage":[]}`), nil)
+		plugin.SetAPI(api)
+
+		err := plugin.OnActivate()
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "failed to migrate the stored schedules")
+		}
+		api.AssertNotCalled(t, "RegisterCommand", mock.Anything)
+	})
+}
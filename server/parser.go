@@ -0,0 +1,290 @@
+package main
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	optionTimezone = "tz"
+)
+
+// addArguments contains everything that has been given to the add command
+type addArguments struct {
+	Cron    string
+	Message string
+	Options map[string]string
+}
+
+// argumentScanner reads the arguments of a command rune by rune
+type argumentScanner struct {
+	text []rune
+	pos  int
+}
+
+func newArgumentScanner(text string) *argumentScanner {
+	return &argumentScanner{text: []rune(text)}
+}
+
+func (s *argumentScanner) done() bool {
+	return s.pos >= len(s.text)
+}
+
+func (s *argumentScanner) peek() rune {
+	if s.done() {
+		return 0
+	}
+	return s.text[s.pos]
+}
+
+func (s *argumentScanner) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(string(s.text[s.pos:]), prefix)
+}
+
+func (s *argumentScanner) skipSpaces() {
+	for !s.done() && unicode.IsSpace(s.peek()) {
+		s.pos++
+	}
+}
+
+// consume skips the given rune if it is the next one and tells whether it has been skipped
+func (s *argumentScanner) consume(r rune) bool {
+	if s.peek() == r {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+// readUntil reads everything up to the given rune, the rune itself is not consumed
+func (s *argumentScanner) readUntil(r rune) string {
+	start := s.pos
+	for !s.done() && s.peek() != r {
+		s.pos++
+	}
+	return string(s.text[start:s.pos])
+}
+
+// readWord reads a single word which ends at whitespace or at the given stop rune. Parts of the word can be quoted
+// with single or double quotes to include whitespace, double quotes support escaping with a backslash.
+func (s *argumentScanner) readWord(stop rune) (string, error) {
+	word := []rune{}
+	for !s.done() {
+		r := s.peek()
+		if unicode.IsSpace(r) || r == stop {
+			break
+		}
+		s.pos++
+		if r != '"' && r != '\'' {
+			word = append(word, r)
+			continue
+		}
+
+		quote := r
+		closed := false
+		for !s.done() {
+			c := s.text[s.pos]
+			s.pos++
+			if c == quote {
+				closed = true
+				break
+			}
+			if c == '\\' && quote == '"' && !s.done() {
+				c = s.text[s.pos]
+				s.pos++
+			}
+			word = append(word, c)
+		}
+		if !closed {
+			return "", errors.Errorf("missing closing %c", quote)
+		}
+	}
+	return string(word), nil
+}
+
+// rest returns everything that has not been read yet
+func (s *argumentScanner) rest() string {
+	rest := string(s.text[s.pos:])
+	s.pos = len(s.text)
+	return rest
+}
+
+// readOption reads an option in the format --name=value, options without a value are set to "true"
+func (s *argumentScanner) readOption() (string, string, error) {
+	s.pos += len("--")
+	word, err := s.readWord(0)
+	if err != nil {
+		return "", "", err
+	}
+	fields := strings.SplitN(word, "=", 2)
+	if fields[0] == "" {
+		return "", "", errors.New("missing option name")
+	}
+	if len(fields) == 1 {
+		return fields[0], "true", nil
+	}
+	return fields[0], fields[1], nil
+}
+
+// parseAddArguments parses the arguments of the add command in the format [--option=value ...] <cron>: <message>.
+// The cron-syntax can be quoted and descriptors like @every 1h30m, @at 2026-10-20T09:00 and recurrence rules
+// starting with RRULE: are supported. The message is kept as given.
+func parseAddArguments(text string, allowedOptions ...string) (*addArguments, error) {
+	if !utf8.ValidString(text) {
+		return nil, errors.New("invalid characters")
+	}
+	s := newArgumentScanner(text)
+	arguments := &addArguments{Options: map[string]string{}}
+
+	for s.skipSpaces(); s.hasPrefix("--"); s.skipSpaces() {
+		name, value, err := s.readOption()
+		if err != nil {
+			return nil, err
+		}
+		if !containsString(allowedOptions, name) {
+			return nil, errors.Errorf("unknown option --%s", name)
+		}
+		arguments.Options[name] = value
+	}
+
+	//the timezone prefix is followed by the recurrence
+	timezonePrefix := ""
+	if s.hasPrefix("CRON_TZ=") || s.hasPrefix("TZ=") {
+		prefix, err := s.readWord(0)
+		if err != nil {
+			return nil, err
+		}
+		timezonePrefix = prefix + " "
+		s.skipSpaces()
+	}
+
+	separatorRequired := true
+	switch {
+	case s.hasPrefix(rrulePrefix):
+		//the rule itself contains a colon, so it is read as a single word after it
+		s.pos += len(rrulePrefix)
+		rule, err := s.readWord(':')
+		if err != nil {
+			return nil, err
+		}
+		arguments.Cron = rrulePrefix + rule
+		separatorRequired = false
+	case s.peek() == '"' || s.peek() == '\'':
+		cron, err := s.readWord(':')
+		if err != nil {
+			return nil, err
+		}
+		arguments.Cron = cron
+		separatorRequired = false
+	case s.peek() == '@':
+		descriptor, err := s.readWord(':')
+		if err != nil {
+			return nil, err
+		}
+		switch descriptor + " " {
+		case "@every ":
+			s.skipSpaces()
+			duration, err := s.readWord(':')
+			if err != nil {
+				return nil, err
+			}
+			descriptor = descriptor + " " + duration
+		case oneShotPrefix:
+			//the time contains colons, so it ends at whitespace and the separator may follow right after it
+			s.skipSpaces()
+			at, err := s.readWord(0)
+			if err != nil {
+				return nil, err
+			}
+			descriptor = descriptor + " " + strings.TrimSuffix(at, ":")
+		}
+		arguments.Cron = descriptor
+		separatorRequired = false
+	default:
+		arguments.Cron = s.readUntil(':')
+	}
+	arguments.Cron = strings.TrimSpace(arguments.Cron)
+	if arguments.Cron != "" {
+		arguments.Cron = timezonePrefix + arguments.Cron
+	}
+
+	s.skipSpaces()
+	if !s.consume(':') && separatorRequired {
+		return nil, errors.New("missing : between cron and message")
+	}
+	arguments.Message = strings.TrimLeft(s.rest(), " \t")
+
+	if arguments.Cron == "" {
+		return nil, errors.New("missing cron")
+	}
+	if strings.TrimSpace(arguments.Message) == "" {
+		return nil, errors.New("missing message")
+	}
+
+	if timezone, ok := arguments.Options[optionTimezone]; ok {
+		cron, err := applyTimezone(arguments.Cron, timezone)
+		if err != nil {
+			return nil, err
+		}
+		arguments.Cron = cron
+	}
+
+	return arguments, nil
+}
+
+// applyTimezone makes the given cron-syntax run in the given timezone
+func applyTimezone(cron string, timezone string) (string, error) {
+	if existingTimezone, _ := splitCronTimezone(cron); existingTimezone != "" {
+		return "", errors.New("the timezone is given twice")
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return "", errors.Errorf("unknown timezone %s", timezone)
+	}
+	return "CRON_TZ=" + timezone + " " + cron, nil
+}
+
+// parseArguments splits the given text into positional arguments and options in the format --name=value.
+// Arguments can be quoted to include whitespace.
+func parseArguments(text string, allowedOptions ...string) ([]string, map[string]string, error) {
+	if !utf8.ValidString(text) {
+		return nil, nil, errors.New("invalid characters")
+	}
+	s := newArgumentScanner(text)
+	arguments := []string{}
+	options := map[string]string{}
+
+	for s.skipSpaces(); !s.done(); s.skipSpaces() {
+		if s.hasPrefix("--") {
+			name, value, err := s.readOption()
+			if err != nil {
+				return nil, nil, err
+			}
+			if !containsString(allowedOptions, name) {
+				return nil, nil, errors.Errorf("unknown option --%s", name)
+			}
+			options[name] = value
+			continue
+		}
+
+		word, err := s.readWord(0)
+		if err != nil {
+			return nil, nil, err
+		}
+		arguments = append(arguments, word)
+	}
+
+	return arguments, options, nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
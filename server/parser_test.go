@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAddArguments(t *testing.T) {
+	testCases := []struct {
+		name    string
+		text    string
+		cron    string
+		message string
+		options map[string]string
+	}{
+		{"Simple", " 0 0 12 * * *: Lunch time!", "0 0 12 * * *", "Lunch time!", map[string]string{}},
+		{"Colons in the message", "0 0 9 * * MON: Meeting at 10:30: bring notes", "0 0 9 * * MON", "Meeting at 10:30: bring notes", map[string]string{}},
+		{"URL in the message", "0 0 9 * * *: see https://example.com:8080/path", "0 0 9 * * *", "see https://example.com:8080/path", map[string]string{}},
+		{"Multiline message", "@daily: first line\nsecond line\n", "@daily", "first line\nsecond line\n", map[string]string{}},
+		{"Quoted cron", `"0 0 12 * * *": Lunch time!`, "0 0 12 * * *", "Lunch time!", map[string]string{}},
+		{"Quoted cron without separator", `'0 0 12 * * *' Lunch time!`, "0 0 12 * * *", "Lunch time!", map[string]string{}},
+		{"Every descriptor", "@every 1h30m: Stretch your legs", "@every 1h30m", "Stretch your legs", map[string]string{}},
+		{"Every descriptor without separator", "@every 1h30m Stretch your legs", "@every 1h30m", "Stretch your legs", map[string]string{}},
+		{"Descriptor", "@midnight Another day another dollar :)", "@midnight", "Another day another dollar :)", map[string]string{}},
+		{"Cron with timezone prefix", "CRON_TZ=Europe/Berlin 0 0 12 * * *: Mahlzeit", "CRON_TZ=Europe/Berlin 0 0 12 * * *", "Mahlzeit", map[string]string{}},
+		{"Timezone option", "--tz=Europe/Berlin 0 0 12 * * *: Mahlzeit", "CRON_TZ=Europe/Berlin 0 0 12 * * *", "Mahlzeit", map[string]string{"tz": "Europe/Berlin"}},
+		{"Recurrence rule", "RRULE:FREQ=MONTHLY;BYDAY=-1FR;BYHOUR=17: Weekend!", "RRULE:FREQ=MONTHLY;BYDAY=-1FR;BYHOUR=17", "Weekend!", map[string]string{}},
+		{"Recurrence rule with timezone", "CRON_TZ=Europe/Berlin RRULE:FREQ=DAILY Guten Morgen", "CRON_TZ=Europe/Berlin RRULE:FREQ=DAILY", "Guten Morgen", map[string]string{}},
+		{"Once", "@at 2026-10-20T09:00: Release at 10:00", "@at 2026-10-20T09:00", "Release at 10:00", map[string]string{}},
+		{"Once without separator", "@at 2026-10-20T09:00 Release", "@at 2026-10-20T09:00", "Release", map[string]string{}},
+		{"Quoted option", `--tz="America/New_York" @daily: Hi`, "CRON_TZ=America/New_York @daily", "Hi", map[string]string{"tz": "America/New_York"}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			arguments, err := parseAddArguments(testCase.text, optionTimezone)
+			assert.Nil(t, err)
+			assert.Equal(t, testCase.cron, arguments.Cron)
+			assert.Equal(t, testCase.message, arguments.Message)
+			assert.Equal(t, testCase.options, arguments.Options)
+		})
+	}
+}
+
+func TestParseAddArguments_fail(t *testing.T) {
+	testCases := []struct {
+		name string
+		text string
+		err  string
+	}{
+		{"Empty", "", "missing : between cron and message"},
+		{"Missing separator", "0 0 12 * * * Lunch time!", "missing : between cron and message"},
+		{"Missing cron", ": Lunch time!", "missing cron"},
+		{"Missing message", "0 0 12 * * *:   ", "missing message"},
+		{"Unclosed quote", `"0 0 12 * * *: Lunch time!`, "missing closing \""},
+		{"Unknown option", "--channel=town-square @daily: Hi", "unknown option --channel"},
+		{"Unknown timezone", "--tz=Mars/Olympus @daily: Hi", "unknown timezone Mars/Olympus"},
+		{"Timezone given twice", "--tz=UTC CRON_TZ=UTC @daily: Hi", "the timezone is given twice"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := parseAddArguments(testCase.text, optionTimezone)
+			if assert.NotNil(t, err) {
+				assert.Equal(t, testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func FuzzParseAddArguments(f *testing.F) {
+	f.Add("0 0 12 * * *: Lunch time!")
+	f.Add("0 0 9 * * MON: Meeting at 10:30: bring notes")
+	f.Add(`"0 0 12 * * *": Lunch time!`)
+	f.Add("@every 1h30m: Stretch your legs")
+	f.Add(`--tz="Europe/Berlin" @daily: Hi`)
+	f.Add(`'unclosed: quote`)
+	f.Add("--: nothing")
+
+	f.Fuzz(func(t *testing.T, text string) {
+		arguments, err := parseAddArguments(text, optionTimezone)
+		if err != nil {
+			return
+		}
+		if arguments.Cron == "" {
+			t.Errorf("parsed an empty cron from %q", text)
+		}
+		if strings.TrimSpace(arguments.Message) == "" {
+			t.Errorf("parsed an empty message from %q", text)
+		}
+		//the message must never be truncated, so it always is the end of the given text
+		if !strings.HasSuffix(text, arguments.Message) {
+			t.Errorf("message %q is not the end of %q", arguments.Message, text)
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// isSystemAdmin tells whether the given user is allowed to manage the whole system
+func (p *Plugin) isSystemAdmin(userID string) bool {
+	return p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM)
+}
+
+// canPostIn tells whether the given user is allowed to post into the given channel
+func (p *Plugin) canPostIn(userID string, channelID string) bool {
+	return p.API.HasPermissionToChannel(userID, channelID, model.PERMISSION_CREATE_POST)
+}
+
+// isMemberOf tells whether the given user is a member of the given channel
+func (p *Plugin) isMemberOf(userID string, channelID string) bool {
+	_, err := p.API.GetChannelMember(channelID, userID)
+	return err == nil
+}
+
+// canView tells whether the given user is allowed to see the given schedule.
+// This is the case for the creator, for members of the channel it posts to and for system admins.
+func (p *Plugin) canView(userID string, msg ScheduledMessage) bool {
+	return msg.Creator == userID || p.isMemberOf(userID, msg.ChannelID) || p.isSystemAdmin(userID)
+}
+
+// canManage tells whether the given user is allowed to change or remove the given schedule.
+// This is the case for the creator and for admins of the channel it posts to, which includes team and system admins.
+func (p *Plugin) canManage(userID string, msg ScheduledMessage) bool {
+	return msg.Creator == userID || p.isChannelAdmin(userID, msg.ChannelID)
+}
+
+// getPermittedScheduleIndex returns the index of the schedule given in the command, if the user is allowed to see it.
+// Set manage if the command changes the schedule, so it is only allowed for users who can manage the schedule.
+func (p *Plugin) getPermittedScheduleIndex(args *model.CommandArgs, trigger string, givenArray []ScheduledMessage, manage bool) (int, *model.CommandResponse) {
+	givenText := strings.TrimPrefix(args.Command, fmt.Sprintf("/%s", trigger))
+	commandFields := strings.Fields(givenText)
+	if len(commandFields) == 0 {
+		return -1, &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: Please enter a valid schedule ID",
+		}
+	}
+
+	index, scheduleErr := p.findPermittedSchedule(args.UserId, commandFields[0], givenArray, manage)
+	if scheduleErr != nil {
+		return -1, &model.CommandResponse{
+			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+			Text:         "Error: " + scheduleErr.Message,
+		}
+	}
+	return index, nil
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"sync"
+	"time"
 
+	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
 	"github.com/pkg/errors"
 	"github.com/robfig/cron/v3"
@@ -19,59 +21,157 @@ type Plugin struct {
 	// setConfiguration for usage.
 	configuration *configuration
 
-	//This is our cron-instance, triggering the right messages at the right time
+	//cronLock synchronizes access to pluginCron
+	cronLock sync.Mutex
+	//This is our cron-instance, running the jobs posting the due schedules and keeping everything in shape
 	pluginCron *cron.Cron
+
+	//poolLock synchronizes access to deliveryPool and drained
+	poolLock sync.Mutex
+	//deliveryPool posts the due schedules, nil while the plugin is not active
+	deliveryPool *deliveryPool
+	//drained is closed once the jobs and deliveries of the last activation finished
+	drained chan struct{}
+
+	//registryLock synchronizes access to the registry
+	registryLock sync.Mutex
+	//registry contains the active schedules and their next runs, keyed by their ID
+	registry map[string]registration
+
+	//botUserID is the user the plugin uses to notify users
+	botUserID string
+
+	//metrics are exposed to Prometheus, nil until the plugin is activated
+	metrics *metrics
+
+	//nodeID identifies this instance of the plugin in the leader lease and the published changes
+	nodeID string
+
+	//clusterLock synchronizes access to seenRevision
+	clusterLock sync.Mutex
+	//seenRevision is the latest change of the schedules applied to our registry
+	seenRevision string
+
+	//statusLock synchronizes access to the fields below, which are reported by the status command
+	statusLock   sync.Mutex
+	cronRunning  bool
+	leaderUntil  time.Time //this node is the leader until the given time
+	recentErrors []statusError
 }
 
-//ScheduledMessage stores information about a message that has been scheduled with the plugin
+// ScheduledMessage stores information about a message that has been scheduled with the plugin
 type ScheduledMessage struct {
-	Creator   string       `json:"creator"` //userID of the author
-	TeamID    string       `json:"teamID"`
-	ChannelID string       `json:"channelID"`
-	Cron      string       `json:"cron"`
-	Message   string       `json:"message"`
-	CronID    cron.EntryID `json:"CronID"` //needed so we know which cron-job we need to stop
+	ID        string `json:"id"`
+	Creator   string `json:"creator"` //userID of the author
+	TeamID    string `json:"teamID"`
+	ChannelID string `json:"channelID"`
+	Cron      string `json:"cron"`
+	Message   string `json:"message"`
+	State     string `json:"state,omitempty"`
+	Reason    string `json:"reason,omitempty"` //explains why a schedule has been paused or disabled
+}
+
+const (
+	stateActive   = "active"
+	statePaused   = "paused"
+	stateDisabled = "disabled"
+	statePending  = "pending" //waiting for the approval of a channel admin
+)
+
+// GetState returns the state of the scheduled message, messages stored without a state are active
+func (m *ScheduledMessage) GetState() string {
+	if m.State == "" {
+		return stateActive
+	}
+	return m.State
 }
 
-//SchedulerData contains all data necessary to be stored for the Scheduler Plugin
+// SchedulerData contains all data necessary to be stored for the Scheduler Plugin
 type SchedulerData struct {
+	Version           int                `json:"version"` //schema version, see migrations
 	ScheduledMessages []ScheduledMessage `json:"ScheduledMessage"`
 }
 
 // OnActivate is invoked when the plugin is activated.
 func (p *Plugin) OnActivate() error {
+	p.metrics = newMetrics()
+	p.nodeID = model.NewId()
+
+	//refuse to start if the schedules cannot be migrated, e.g. after a downgrade, instead of overwriting them
+	if err := p.migrateStorage(); err != nil {
+		return errors.Wrap(err, "failed to migrate the stored schedules")
+	}
+
 	//register all our commands
 	if err := p.registerCommands(); err != nil {
 		return errors.Wrap(err, "failed to register commands")
 	}
 
-	if p.pluginCron != nil {
-		p.pluginCron.Stop()
+	botUserID, err := p.Helpers.EnsureBot(&model.Bot{
+		Username:    "scheduler",
+		DisplayName: "Scheduler",
+		Description: "Notifies users about changes to their scheduled messages.",
+	}, plugin.ProfileImagePath("assets/scheduler.png"))
+	if err != nil {
+		return errors.Wrap(err, "failed to ensure bot")
+	}
+	p.botUserID = botUserID
+
+	//the previous activation may still be posting, which must finish before this one starts posting
+	if !p.stopDeliveries(drainTimeout) {
+		p.API.LogWarn("Activated the plugin while scheduled messages of the previous activation are still being posted")
+	}
+	//a job is skipped while its previous run is still busy, e.g. posting many schedules that are due at once
+	pluginCron := cron.New(cron.WithSeconds(), cron.WithChain(cron.SkipIfStillRunning(cron.DiscardLogger)))
+	p.cronLock.Lock()
+	p.pluginCron = pluginCron
+	p.cronLock.Unlock()
+	p.registryLock.Lock()
+	p.registry = map[string]registration{}
+	p.registryLock.Unlock()
+	p.poolLock.Lock()
+	p.deliveryPool = newDeliveryPool(p.deliveryWorkers, p.deliverQueued)
+	p.poolLock.Unlock()
+	//registers all active schedules, the limits and restrictions are enforced by the leader once it acquired the lease
+	p.reconcileSchedules(p.ReadFromStorage())
+	if _, err := pluginCron.AddFunc(duePollSchedule, p.pollDueSchedules); err != nil {
+		return errors.Wrap(err, "failed to schedule posting of due schedules")
 	}
-	data := p.ReadFromStorage()
-	p.pluginCron = cron.New(cron.WithSeconds())
-	for index := range data.ScheduledMessages {
-		entryID, err := p.pluginCron.AddFunc(data.ScheduledMessages[index].Cron, func() { p.postMessage(data.ScheduledMessages[index]) })
-		if err == nil {
-			data.ScheduledMessages[index].CronID = entryID
-		}
+	if _, err := pluginCron.AddFunc(integritySweepSchedule, p.runIntegritySweep); err != nil {
+		return errors.Wrap(err, "failed to schedule integrity sweep")
 	}
-	p.WriteToStorage(&data)
-	p.pluginCron.Start()
+	if _, err := pluginCron.AddFunc(reconcileSchedule, p.reconcileFromStorage); err != nil {
+		return errors.Wrap(err, "failed to schedule reconciliation")
+	}
+	if _, err := pluginCron.AddFunc(changePollSchedule, p.pollScheduleChanges); err != nil {
+		return errors.Wrap(err, "failed to schedule polling for changes")
+	}
+	p.renewLeadership()
+	if _, err := pluginCron.AddFunc(leaderRenewSchedule, p.renewLeadership); err != nil {
+		return errors.Wrap(err, "failed to schedule renewal of the leader lease")
+	}
+	pluginCron.Start()
+
+	p.statusLock.Lock()
+	p.cronRunning = true
+	p.statusLock.Unlock()
 
 	return nil
 }
 
 // OnDeactivate is invoked when the plugin is deactivated.
 func (p *Plugin) OnDeactivate() error {
-	data := p.ReadFromStorage()
-	for _, msg := range data.ScheduledMessages {
-		p.pluginCron.Remove(msg.CronID)
-		msg.CronID = -1
+	if !p.stopDeliveries(drainTimeout) {
+		p.API.LogWarn("Deactivated the plugin while scheduled messages are still being posted", "timeout", drainTimeout.String())
 	}
-	p.WriteToStorage(&data)
-	p.pluginCron.Stop()
-	p.pluginCron = nil
+	p.registryLock.Lock()
+	p.registry = nil
+	p.registryLock.Unlock()
+	p.releaseLeadership()
+
+	p.statusLock.Lock()
+	p.cronRunning = false
+	p.statusLock.Unlock()
 	return nil
 }
 
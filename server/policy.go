@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+// intervalSamples is the number of upcoming runs that are checked against the minimum interval
+const intervalSamples = 100
+
+// checkPolicy checks the given schedule against the limits configured by the admins. The given schedules are the
+// ones that already exist, a schedule with the same ID as the checked one is ignored.
+func (p *Plugin) checkPolicy(msg ScheduledMessage, existing []ScheduledMessage) error {
+	config := p.getConfiguration()
+
+	if config.MaxMessageLength > 0 && len([]rune(msg.Message)) > config.MaxMessageLength {
+		return errors.Errorf("the message is longer than %d characters", config.MaxMessageLength)
+	}
+
+	if config.MinIntervalSeconds > 0 {
+		interval, err := minimumInterval(msg.Cron, time.Now(), intervalSamples)
+		if err != nil {
+			return errors.Wrap(err, "invalid cron-syntax")
+		}
+		minInterval := time.Duration(config.MinIntervalSeconds) * time.Second
+		if interval > 0 && interval < minInterval {
+			return errors.Errorf("the message would be posted every %s, but the minimum interval is %s", interval, minInterval)
+		}
+	}
+
+	userCount := 0
+	channelCount := 0
+	for _, other := range existing {
+		//disabled schedules do not count against the limits
+		if other.ID == msg.ID || other.GetState() == stateDisabled {
+			continue
+		}
+		if other.Creator == msg.Creator {
+			userCount++
+		}
+		if other.ChannelID == msg.ChannelID {
+			channelCount++
+		}
+	}
+	if config.MaxSchedulesPerUser > 0 && userCount >= config.MaxSchedulesPerUser {
+		return errors.Errorf("the user already has %d scheduled messages, which is the maximum", userCount)
+	}
+	if config.MaxSchedulesPerChannel > 0 && channelCount >= config.MaxSchedulesPerChannel {
+		return errors.Errorf("this channel already has %d scheduled messages, which is the maximum", channelCount)
+	}
+
+	return nil
+}
+
+// disabledSchedule is a schedule disabled because it violates the configuration
+type disabledSchedule struct {
+	Before ScheduledMessage
+	After  ScheduledMessage
+}
+
+// enforcePolicy disables every active schedule that violates the limits configured by the admins.
+// The schedules are checked in the order they have been created, so the most recent ones are disabled first
+// when there are too many of them. Returns the disabled schedules.
+func (p *Plugin) enforcePolicy(data *SchedulerData) []disabledSchedule {
+	disabled := []disabledSchedule{}
+	for index := range data.ScheduledMessages {
+		msg := &data.ScheduledMessages[index]
+		if msg.GetState() != stateActive {
+			continue
+		}
+		if err := p.checkPolicy(*msg, data.ScheduledMessages[:index]); err != nil {
+			before := *msg
+			msg.State = stateDisabled
+			msg.Reason = fmt.Sprintf("Violates the limits set by the admins: %s", err.Error())
+			disabled = append(disabled, disabledSchedule{Before: before, After: *msg})
+		}
+	}
+	return disabled
+}
+
+// splitList splits a comma-separated list from the configuration
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(item), "~"))
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// checkDestination checks whether schedules are allowed to post into the given channel
+func (p *Plugin) checkDestination(channelID string) error {
+	config := p.getConfiguration()
+
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get channel")
+	}
+	if channel.Type == model.CHANNEL_DIRECT || channel.Type == model.CHANNEL_GROUP {
+		if config.DenyDirectMessages {
+			return errors.New("posting into direct messages is not allowed")
+		}
+		return nil
+	}
+
+	allowedTeams, deniedTeams := splitList(config.AllowedTeams), splitList(config.DeniedTeams)
+	if len(allowedTeams) > 0 || len(deniedTeams) > 0 {
+		team, appErr := p.API.GetTeam(channel.TeamId)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to get team")
+		}
+		teamName := strings.ToLower(team.Name)
+		if containsString(deniedTeams, teamName) || (len(allowedTeams) > 0 && !containsString(allowedTeams, teamName)) {
+			return errors.Errorf("posting into the team %s is not allowed", team.Name)
+		}
+	}
+
+	allowedChannels, deniedChannels := splitList(config.AllowedChannels), splitList(config.DeniedChannels)
+	channelName := strings.ToLower(channel.Name)
+	if containsString(deniedChannels, channelName) || (len(allowedChannels) > 0 && !containsString(allowedChannels, channelName)) {
+		return errors.Errorf("posting into the channel ~%s is not allowed", channel.Name)
+	}
+
+	return nil
+}
+
+// enforceDestinations disables every active schedule posting into a channel schedules are not allowed to post into.
+// Returns the disabled schedules.
+func (p *Plugin) enforceDestinations(data *SchedulerData) []disabledSchedule {
+	disabled := []disabledSchedule{}
+	for index := range data.ScheduledMessages {
+		msg := &data.ScheduledMessages[index]
+		if msg.GetState() != stateActive {
+			continue
+		}
+		if err := p.checkDestination(msg.ChannelID); err != nil {
+			before := *msg
+			msg.State = stateDisabled
+			msg.Reason = fmt.Sprintf("Violates the restrictions set by the admins: %s", err.Error())
+			disabled = append(disabled, disabledSchedule{Before: before, After: *msg})
+		}
+	}
+	return disabled
+}
+
+// applyConfigurationToSchedules disables the stored schedules that violate the current configuration. Only the leader
+// applies it, so the schedules are not disabled and audited once per node of a cluster.
+func (p *Plugin) applyConfigurationToSchedules() {
+	if !p.isLeader() {
+		return
+	}
+	disabled := []disabledSchedule{}
+	err := p.updateStorage(func(data *SchedulerData) error {
+		disabled = append(p.enforcePolicy(data), p.enforceDestinations(data)...)
+		if len(disabled) == 0 {
+			return errStorageUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		p.recordError("Failed to store the schedules disabled by the configuration: %s", err.Error())
+		return
+	}
+	for index := range disabled {
+		msg := &disabled[index]
+		p.recordAudit(auditActorSystem, auditActionDisable, &msg.Before, &msg.After, msg.After.Reason)
+		p.API.LogWarn("Disabled scheduled message", "id", msg.After.ID, "reason", msg.After.Reason)
+	}
+}
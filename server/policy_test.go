@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	existing := []ScheduledMessage{
+		ScheduledMessage{ID: "schedule1", Creator: "TestUser", ChannelID: "TestChannel"},
+		ScheduledMessage{ID: "schedule2", Creator: "OtherUser", ChannelID: "TestChannel"},
+		ScheduledMessage{ID: "schedule3", Creator: "TestUser", ChannelID: "OtherChannel", State: stateDisabled},
+	}
+
+	testCases := []struct {
+		name   string
+		config configuration
+		msg    ScheduledMessage
+		err    string
+	}{
+		{"No limits", configuration{}, ScheduledMessage{Creator: "TestUser", ChannelID: "TestChannel", Cron: "* * * * * *"}, ""},
+		{"Too often", configuration{MinIntervalSeconds: 60}, ScheduledMessage{Cron: "*/30 * * * * *"}, "the message would be posted every 30s, but the minimum interval is 1m0s"},
+		{"Often enough", configuration{MinIntervalSeconds: 60}, ScheduledMessage{Cron: "0 * * * * *"}, ""},
+		{"Irregular cron", configuration{MinIntervalSeconds: 60}, ScheduledMessage{Cron: "0 0,1 9 * * *"}, ""},
+		{"Too long", configuration{MaxMessageLength: 5}, ScheduledMessage{Message: "Hello World"}, "the message is longer than 5 characters"},
+		{"Too many per user", configuration{MaxSchedulesPerUser: 1}, ScheduledMessage{Creator: "TestUser", ChannelID: "OtherChannel"}, "the user already has 1 scheduled messages, which is the maximum"},
+		{"Too many per channel", configuration{MaxSchedulesPerChannel: 2}, ScheduledMessage{Creator: "ThirdUser", ChannelID: "TestChannel"}, "this channel already has 2 scheduled messages, which is the maximum"},
+		{"Editing does not count itself", configuration{MaxSchedulesPerUser: 1}, ScheduledMessage{ID: "schedule1", Creator: "TestUser", ChannelID: "TestChannel"}, ""},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			plugin := &Plugin{}
+			plugin.setConfiguration(&testCase.config)
+
+			err := plugin.checkPolicy(testCase.msg, existing)
+			if testCase.err == "" {
+				assert.Nil(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Equal(t, testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestEnforcePolicy(t *testing.T) {
+	data := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+		ScheduledMessage{ID: "schedule1", Creator: "TestUser", Cron: "@daily"},
+		ScheduledMessage{ID: "schedule2", Creator: "TestUser", Cron: "@daily"},
+		ScheduledMessage{ID: "schedule3", Creator: "OtherUser", Cron: "* * * * * *"},
+	}}
+
+	plugin := &Plugin{}
+	plugin.SetAPI(&plugintest.API{})
+	plugin.setConfiguration(&configuration{MaxSchedulesPerUser: 1, MinIntervalSeconds: 60})
+
+	disabled := plugin.enforcePolicy(data)
+	if assert.Len(t, disabled, 2) {
+		assert.Equal(t, stateActive, disabled[0].Before.GetState())
+		assert.Equal(t, stateDisabled, disabled[0].After.GetState())
+	}
+	assert.Equal(t, stateActive, data.ScheduledMessages[0].GetState())
+	assert.Equal(t, stateDisabled, data.ScheduledMessages[1].GetState())
+	assert.Equal(t, stateDisabled, data.ScheduledMessages[2].GetState())
+	assert.Contains(t, data.ScheduledMessages[2].Reason, "minimum interval")
+
+	assert.Empty(t, plugin.enforcePolicy(data))
+}
+
+func TestCheckDestination(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  configuration
+		channel string
+		err     string
+	}{
+		{"No restrictions", configuration{}, "TownSquare", ""},
+		{"Denied channel", configuration{DeniedChannels: "~announcements, compliance"}, "Announcements", "posting into the channel ~announcements is not allowed"},
+		{"Not an allowed channel", configuration{AllowedChannels: "town-square"}, "Announcements", "posting into the channel ~announcements is not allowed"},
+		{"Allowed channel", configuration{AllowedChannels: "Town-Square"}, "TownSquare", ""},
+		{"Denied team", configuration{DeniedTeams: "team-a"}, "TownSquare", "posting into the team team-a is not allowed"},
+		{"Not an allowed team", configuration{AllowedTeams: "team-b"}, "TownSquare", "posting into the team team-a is not allowed"},
+		{"Allowed team", configuration{AllowedTeams: "team-a,team-b"}, "TownSquare", ""},
+		{"Direct messages allowed", configuration{AllowedChannels: "town-square"}, "Direct", ""},
+		{"Direct messages denied", configuration{DenyDirectMessages: true}, "Direct", "posting into direct messages is not allowed"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			plugin := &Plugin{}
+			api := &plugintest.API{}
+			api.On("GetChannel", "TownSquare").Return(&model.Channel{Id: "TownSquare", Name: "town-square", TeamId: "TeamA", Type: model.CHANNEL_OPEN}, nil)
+			api.On("GetChannel", "Announcements").Return(&model.Channel{Id: "Announcements", Name: "announcements", TeamId: "TeamA", Type: model.CHANNEL_OPEN}, nil)
+			api.On("GetChannel", "Direct").Return(&model.Channel{Id: "Direct", Name: "user1__user2", Type: model.CHANNEL_DIRECT}, nil)
+			api.On("GetTeam", "TeamA").Return(&model.Team{Id: "TeamA", Name: "team-a"}, nil)
+			plugin.SetAPI(api)
+			plugin.setConfiguration(&testCase.config)
+
+			err := plugin.checkDestination(testCase.channel)
+			if testCase.err == "" {
+				assert.Nil(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Equal(t, testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestOnConfigurationChange(t *testing.T) {
+	t.Run("Disable schedules that became forbidden", func(t *testing.T) {
+		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+			ScheduledMessage{ID: "schedule1", ChannelID: "TownSquare", Cron: "@daily"},
+			ScheduledMessage{ID: "schedule2", ChannelID: "Announcements", Cron: "@daily"},
+		}}
+		reqBodyBytes := new(bytes.Buffer)
+		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds()), leaderUntil: time.Now().Add(time.Minute)}
+		api := &plugintest.API{}
+		api.On("LoadPluginConfiguration", mock.AnythingOfType("*main.configuration")).Return(nil).Run(func(args mock.Arguments) {
+			args.Get(0).(*configuration).DeniedChannels = "announcements"
+		})
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("GetChannel", "TownSquare").Return(&model.Channel{Id: "TownSquare", Name: "town-square", Type: model.CHANNEL_OPEN}, nil)
+		api.On("GetChannel", "Announcements").Return(&model.Channel{Id: "Announcements", Name: "announcements", Type: model.CHANNEL_OPEN}, nil)
+		api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+		recorded := expectAudit(api)
+		expectSchedulerStorage(api)
+		api.On("KVSetWithOptions", KVKEY, mock.MatchedBy(func(value []byte) bool {
+			data := SchedulerData{}
+			json.Unmarshal(value, &data)
+			return len(data.ScheduledMessages) == 2 && data.ScheduledMessages[0].GetState() == stateActive && data.ScheduledMessages[1].GetState() == stateDisabled
+		}), mock.Anything).Return(true, nil)
+		plugin.SetAPI(api)
+
+		assert.Nil(t, plugin.OnConfigurationChange())
+		api.AssertExpectations(t)
+		if assert.Len(t, recorded(), 1) {
+			assert.Equal(t, "schedule2", recorded()[0].ScheduleID)
+		}
+	})
+	t.Run("Leaves the schedules to the leader", func(t *testing.T) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		api := &plugintest.API{}
+		api.On("LoadPluginConfiguration", mock.AnythingOfType("*main.configuration")).Return(nil)
+		plugin.SetAPI(api)
+
+		assert.Nil(t, plugin.OnConfigurationChange())
+		api.AssertNotCalled(t, "KVGet", KVKEY)
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/oleiade/lane"
+)
+
+const (
+	//defaultDeliveryWorkers is the number of schedules posted at the same time if the admins did not configure it
+	defaultDeliveryWorkers = 4
+)
+
+// deliveryPool posts the queued schedules with a limited number of workers, so many schedules due at the same time
+// don't create all their posts at once. Queued schedules are posted in the order they are due.
+type deliveryPool struct {
+	queue   *lane.PQueue
+	limit   func() int //returns the number of workers, it is read before every delivery to pick up configuration changes
+	deliver func(registration)
+
+	//lock synchronizes access to the fields below
+	lock     sync.Mutex
+	slotFree *sync.Cond //signaled whenever a worker finished a delivery
+	wake     chan struct{}
+	running  int
+	queued   map[string]bool //IDs of the schedules that are queued or being posted
+	stopped  bool
+	//pending counts the queued deliveries and the deliveries being posted
+	pending sync.WaitGroup
+}
+
+// newDeliveryPool starts a pool posting the queued schedules with the given function
+func newDeliveryPool(limit func() int, deliver func(registration)) *deliveryPool {
+	pool := &deliveryPool{
+		queue:   lane.NewPQueue(lane.MINPQ),
+		limit:   limit,
+		deliver: deliver,
+		wake:    make(chan struct{}, 1),
+		queued:  map[string]bool{},
+	}
+	pool.slotFree = sync.NewCond(&pool.lock)
+	go pool.dispatch()
+	return pool
+}
+
+// enqueue queues the given schedule, which is posted once a worker is free. Schedules due earlier are posted first.
+// It tells whether the schedule has been queued, a schedule is only queued once at a time.
+func (d *deliveryPool) enqueue(registered registration, dueAt time.Time) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.stopped || d.queued[registered.msg.ID] {
+		return false
+	}
+	d.queued[registered.msg.ID] = true
+	d.pending.Add(1)
+	d.queue.Push(registered, int(toMillis(dueAt)))
+	select {
+	case d.wake <- struct{}{}:
+	default: //the dispatcher has been woken up already
+	}
+	return true
+}
+
+// size returns the number of schedules that are queued or being posted
+func (d *deliveryPool) size() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return len(d.queued)
+}
+
+// dispatch hands the queued schedules to the workers until the pool is stopped. A schedule is only taken from the
+// queue once a worker is free, so schedules queued in the meantime are still posted in the order they are due.
+func (d *deliveryPool) dispatch() {
+	for range d.wake {
+		for {
+			d.lock.Lock()
+			for d.running >= d.limit() && !d.stopped {
+				d.slotFree.Wait()
+			}
+			if d.stopped {
+				d.lock.Unlock()
+				return
+			}
+			item, _ := d.queue.Pop()
+			if item == nil {
+				d.lock.Unlock()
+				break
+			}
+			d.running++
+			d.lock.Unlock()
+
+			go d.work(item.(registration))
+		}
+	}
+}
+
+// work posts the given schedule and frees the worker afterwards
+func (d *deliveryPool) work(registered registration) {
+	defer d.pending.Done()
+	d.deliver(registered)
+
+	d.lock.Lock()
+	d.running--
+	delete(d.queued, registered.msg.ID)
+	d.slotFree.Signal()
+	d.lock.Unlock()
+}
+
+// stop stops dispatching schedules. Schedules that have not been handed to a worker yet are dropped, they are still
+// due and posted by the next leader.
+func (d *deliveryPool) stop() {
+	d.lock.Lock()
+	if d.stopped {
+		d.lock.Unlock()
+		return
+	}
+	d.stopped = true
+	close(d.wake)
+	d.slotFree.Broadcast()
+	d.lock.Unlock()
+
+	for item, _ := d.queue.Pop(); item != nil; item, _ = d.queue.Pop() {
+		d.lock.Lock()
+		delete(d.queued, item.(registration).msg.ID)
+		d.lock.Unlock()
+		d.pending.Done()
+	}
+}
+
+// wait blocks until all queued schedules have been posted or dropped
+func (d *deliveryPool) wait() {
+	d.pending.Wait()
+}
+
+// deliveryWorkers returns the number of schedules posted at the same time
+func (p *Plugin) deliveryWorkers() int {
+	if workers := p.getConfiguration().DeliveryWorkers; workers > 0 {
+		return workers
+	}
+	return defaultDeliveryWorkers
+}
+
+// deliveryJitter returns how long the schedule with the given ID is delayed, so schedules due at the same time are
+// spread over the configured jitter. Every schedule has its own delay, which is the same for all of its runs.
+func (p *Plugin) deliveryJitter(scheduleID string) time.Duration {
+	maxJitter := p.getConfiguration().DeliveryJitterSeconds
+	if maxJitter <= 0 {
+		return 0
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(scheduleID))
+	return time.Duration(hash.Sum32()%uint32(maxJitter*1000)) * time.Millisecond
+}
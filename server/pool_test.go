@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliveryPool(t *testing.T) {
+	schedule := func(id string) registration {
+		return registration{msg: ScheduledMessage{ID: id}}
+	}
+	base := time.Date(2020, time.June, 1, 9, 0, 0, 0, time.UTC)
+
+	t.Run("Limits the workers", func(t *testing.T) {
+		lock := sync.Mutex{}
+		running, maxRunning := 0, 0
+		release := make(chan struct{})
+		pool := newDeliveryPool(func() int { return 2 }, func(registered registration) {
+			lock.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			lock.Unlock()
+			<-release
+			lock.Lock()
+			running--
+			lock.Unlock()
+		})
+
+		for _, id := range []string{"a", "b", "c", "d", "e"} {
+			assert.True(t, pool.enqueue(schedule(id), base))
+		}
+		assert.Equal(t, 5, pool.size())
+		assert.Eventually(t, func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+			return running == 2
+		}, time.Second, time.Millisecond)
+		close(release)
+		pool.wait()
+		assert.Equal(t, 2, maxRunning)
+		assert.Equal(t, 0, pool.size())
+	})
+	t.Run("Posts the earliest due first", func(t *testing.T) {
+		delivered := []string{}
+		started := make(chan struct{})
+		release := make(chan struct{})
+		pool := newDeliveryPool(func() int { return 1 }, func(registered registration) {
+			if registered.msg.ID == "busy" {
+				close(started)
+				<-release
+			}
+			delivered = append(delivered, registered.msg.ID)
+		})
+
+		pool.enqueue(schedule("busy"), base.Add(time.Hour))
+		<-started
+		pool.enqueue(schedule("third"), base.Add(3*time.Second))
+		pool.enqueue(schedule("first"), base.Add(time.Second))
+		pool.enqueue(schedule("second"), base.Add(2*time.Second))
+		close(release)
+		pool.wait()
+		assert.Equal(t, []string{"busy", "first", "second", "third"}, delivered)
+	})
+	t.Run("Queues a schedule only once", func(t *testing.T) {
+		release := make(chan struct{})
+		pool := newDeliveryPool(func() int { return 1 }, func(registered registration) { <-release })
+
+		assert.True(t, pool.enqueue(schedule("a"), base))
+		assert.False(t, pool.enqueue(schedule("a"), base))
+		close(release)
+		pool.wait()
+		assert.True(t, pool.enqueue(schedule("a"), base), "posted schedules can be queued again")
+		pool.wait()
+	})
+	t.Run("Drops queued schedules when stopped", func(t *testing.T) {
+		delivered := []string{}
+		started := make(chan struct{})
+		release := make(chan struct{})
+		pool := newDeliveryPool(func() int { return 1 }, func(registered registration) {
+			close(started)
+			<-release
+			delivered = append(delivered, registered.msg.ID)
+		})
+
+		pool.enqueue(schedule("busy"), base)
+		<-started
+		pool.enqueue(schedule("queued"), base)
+		pool.stop()
+		assert.False(t, pool.enqueue(schedule("late"), base))
+		close(release)
+		pool.wait()
+		assert.Equal(t, []string{"busy"}, delivered)
+	})
+}
+
+func TestDeliveryJitter(t *testing.T) {
+	plugin := &Plugin{}
+	plugin.setConfiguration(&configuration{})
+	assert.Equal(t, time.Duration(0), plugin.deliveryJitter("schedule1"))
+	assert.Equal(t, defaultDeliveryWorkers, plugin.deliveryWorkers())
+
+	plugin.setConfiguration(&configuration{DeliveryJitterSeconds: 30, DeliveryWorkers: 10})
+	jitters := map[time.Duration]bool{}
+	for _, id := range []string{"schedule1", "schedule2", "schedule3", "schedule4"} {
+		jitter := plugin.deliveryJitter(id)
+		assert.True(t, jitter >= 0 && jitter < 30*time.Second, jitter)
+		assert.Equal(t, jitter, plugin.deliveryJitter(id), "the jitter of a schedule is always the same")
+		jitters[jitter] = true
+	}
+	assert.True(t, len(jitters) > 1, "schedules are spread")
+	assert.Equal(t, 10, plugin.deliveryWorkers())
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	//rrulePrefix starts recurrence rules in the format of RFC 5545, e.g. RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO
+	rrulePrefix = "RRULE:"
+	//oneShotPrefix starts schedules posting only once, e.g. @at 2026-10-20T09:00
+	oneShotPrefix = "@at "
+
+	rruleFrequencyDaily   = "DAILY"
+	rruleFrequencyWeekly  = "WEEKLY"
+	rruleFrequencyMonthly = "MONTHLY"
+	rruleFrequencyYearly  = "YEARLY"
+
+	//maxRecurrenceDays limits how far a recurrence rule is searched for its next occurrence
+	maxRecurrenceDays = 366 * 20
+)
+
+var (
+	//oneShotFormats are the accepted formats of the time of a schedule posting only once
+	oneShotFormats = []string{"2006-01-02T15:04:05Z07:00", "2006-01-02T15:04:05", "2006-01-02T15:04"}
+	//rruleTimeFormats are the accepted formats of DTSTART and UNTIL
+	rruleTimeFormats = []string{"20060102T150405Z", "20060102T150405", "20060102"}
+
+	rruleWeekdays = map[string]time.Weekday{
+		"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+	}
+	rruleFrequencyNames = map[string]string{
+		rruleFrequencyDaily: "Daily", rruleFrequencyWeekly: "Weekly", rruleFrequencyMonthly: "Monthly", rruleFrequencyYearly: "Yearly",
+	}
+	rruleIntervalUnits = map[string]string{
+		rruleFrequencyDaily: "days", rruleFrequencyWeekly: "weeks", rruleFrequencyMonthly: "months", rruleFrequencyYearly: "years",
+	}
+)
+
+// recurrence tells when a schedule posts. Cron-syntax, recurrence rules and schedules posting only once are
+// supported, all of them can start with a CRON_TZ=<timezone> prefix.
+type recurrence interface {
+	// Next returns the first time after the given time the schedule posts, or the zero time if it never posts again
+	Next(after time.Time) time.Time
+}
+
+// parseRecurrence parses the given cron-syntax, recurrence rule or time of a schedule posting only once
+func parseRecurrence(spec string) (recurrence, error) {
+	timezone, rest := splitCronTimezone(spec)
+	location := time.Local
+	if timezone != "" {
+		loaded, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, errors.Errorf("unknown timezone %s", timezone)
+		}
+		location = loaded
+	}
+
+	switch {
+	case strings.HasPrefix(rest, rrulePrefix):
+		return parseRRule(strings.TrimPrefix(rest, rrulePrefix), location)
+	case strings.HasPrefix(rest, oneShotPrefix):
+		return parseOneShot(strings.TrimSpace(strings.TrimPrefix(rest, oneShotPrefix)), location)
+	default:
+		return cronParser.Parse(spec)
+	}
+}
+
+// oneShot is a schedule posting only once
+type oneShot struct {
+	at time.Time
+}
+
+func parseOneShot(value string, location *time.Location) (*oneShot, error) {
+	for _, format := range oneShotFormats {
+		if at, err := time.ParseInLocation(format, value, location); err == nil {
+			return &oneShot{at: at}, nil
+		}
+	}
+	return nil, errors.Errorf("invalid time %s, use the format 2006-01-02T15:04", value)
+}
+
+func (o *oneShot) Next(after time.Time) time.Time {
+	if o.at.After(after) {
+		return o.at
+	}
+	return time.Time{}
+}
+
+// rruleDay is a weekday of BYDAY, the ordinal selects e.g. the second (2) or the last (-1) weekday of a month
+type rruleDay struct {
+	weekday time.Weekday
+	ordinal int
+}
+
+// rrule is a subset of the recurrence rules of RFC 5545. The start of the rule can be given as DTSTART part, it is
+// needed for intervals and counts.
+type rrule struct {
+	location   *time.Location
+	frequency  string
+	interval   int
+	start      time.Time //zero if not given
+	until      time.Time //zero if not given
+	count      int
+	byDay      []rruleDay
+	byMonthDay []int
+	byMonth    []int
+	byHour     []int
+	byMinute   []int
+	bySecond   []int
+}
+
+func parseRRule(value string, location *time.Location) (*rrule, error) {
+	rule := &rrule{location: location, interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		fields := strings.SplitN(part, "=", 2)
+		if len(fields) != 2 || fields[1] == "" {
+			return nil, errors.Errorf("invalid part %s", part)
+		}
+		name, value := strings.ToUpper(fields[0]), strings.ToUpper(fields[1])
+
+		var err error
+		switch name {
+		case "FREQ":
+			if _, ok := rruleFrequencyNames[value]; !ok {
+				return nil, errors.Errorf("unsupported frequency %s", value)
+			}
+			rule.frequency = value
+		case "INTERVAL":
+			rule.interval, err = strconv.Atoi(value)
+			if err == nil && rule.interval < 1 {
+				err = errors.New("must be at least 1")
+			}
+		case "COUNT":
+			rule.count, err = strconv.Atoi(value)
+			if err == nil && rule.count < 1 {
+				err = errors.New("must be at least 1")
+			}
+		case "DTSTART":
+			rule.start, err = parseRRuleTime(value, location)
+		case "UNTIL":
+			rule.until, err = parseRRuleTime(value, location)
+		case "BYDAY":
+			rule.byDay, err = parseRRuleDays(value)
+		case "BYMONTHDAY":
+			rule.byMonthDay, err = parseRRuleNumbers(value, -31, 31)
+		case "BYMONTH":
+			rule.byMonth, err = parseRRuleNumbers(value, 1, 12)
+		case "BYHOUR":
+			rule.byHour, err = parseRRuleNumbers(value, 0, 23)
+		case "BYMINUTE":
+			rule.byMinute, err = parseRRuleNumbers(value, 0, 59)
+		case "BYSECOND":
+			rule.bySecond, err = parseRRuleNumbers(value, 0, 59)
+		case "WKST":
+			//weeks always start on Monday
+		default:
+			return nil, errors.Errorf("unsupported part %s", name)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", name)
+		}
+	}
+
+	if rule.frequency == "" {
+		return nil, errors.New("missing FREQ")
+	}
+	if rule.start.IsZero() && (rule.interval > 1 || rule.count > 0) {
+		return nil, errors.New("INTERVAL and COUNT need a DTSTART")
+	}
+	for _, day := range rule.byDay {
+		if day.ordinal != 0 && rule.frequency != rruleFrequencyMonthly && rule.frequency != rruleFrequencyYearly {
+			return nil, errors.New("ordinal weekdays are only supported for monthly and yearly rules")
+		}
+		//a month has at most 5 of each weekday, a year at most 53
+		if !rule.countsWeekdaysInYear() && (day.ordinal < -5 || day.ordinal > 5) {
+			return nil, errors.Errorf("invalid BYDAY: the ordinal %d is not between -5 and 5", day.ordinal)
+		}
+	}
+
+	//the time of day defaults to the time of the start, or to midnight
+	if rule.byHour == nil {
+		rule.byHour = []int{rule.start.Hour()}
+	}
+	if rule.byMinute == nil {
+		rule.byMinute = []int{rule.start.Minute()}
+	}
+	if rule.bySecond == nil {
+		rule.bySecond = []int{rule.start.Second()}
+	}
+	return rule, nil
+}
+
+func parseRRuleTime(value string, location *time.Location) (time.Time, error) {
+	for _, format := range rruleTimeFormats {
+		if strings.HasSuffix(format, "Z") {
+			if t, err := time.Parse(format, value); err == nil {
+				return t.In(location), nil
+			}
+			continue
+		}
+		if t, err := time.ParseInLocation(format, value, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("%s is not in the format 20060102T150405", value)
+}
+
+func parseRRuleDays(value string) ([]rruleDay, error) {
+	days := []rruleDay{}
+	for _, item := range strings.Split(value, ",") {
+		if len(item) < 2 {
+			return nil, errors.Errorf("unknown weekday %s", item)
+		}
+		weekday, ok := rruleWeekdays[item[len(item)-2:]]
+		if !ok {
+			return nil, errors.Errorf("unknown weekday %s", item)
+		}
+		day := rruleDay{weekday: weekday}
+		if ordinal := item[:len(item)-2]; ordinal != "" {
+			number, err := strconv.Atoi(ordinal)
+			if err != nil || number == 0 || number < -53 || number > 53 {
+				return nil, errors.Errorf("invalid ordinal %s", ordinal)
+			}
+			day.ordinal = number
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+func parseRRuleNumbers(value string, min int, max int) ([]int, error) {
+	numbers := []int{}
+	for _, item := range strings.Split(value, ",") {
+		number, err := strconv.Atoi(item)
+		if err != nil || number < min || number > max || number == 0 && min < 0 {
+			return nil, errors.Errorf("%s is not between %d and %d", item, min, max)
+		}
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func (r *rrule) Next(after time.Time) time.Time {
+	after = after.In(r.location)
+	day := startOfDay(after)
+	if !r.start.IsZero() && (r.count > 0 || r.start.After(after)) {
+		//occurrences are counted from the start
+		day = startOfDay(r.start)
+	}
+
+	occurrences := 0
+	for i := 0; i < maxRecurrenceDays; i++ {
+		if r.matchesDay(day) {
+			for _, occurrence := range r.times(day) {
+				if occurrence.Before(r.start) {
+					continue
+				}
+				if !r.until.IsZero() && occurrence.After(r.until) {
+					return time.Time{}
+				}
+				occurrences++
+				if r.count > 0 && occurrences > r.count {
+					return time.Time{}
+				}
+				if occurrence.After(after) {
+					return occurrence
+				}
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+// times returns the times of the given day the rule posts at, in ascending order
+func (r *rrule) times(day time.Time) []time.Time {
+	times := []time.Time{}
+	for _, hour := range r.byHour {
+		for _, minute := range r.byMinute {
+			for _, second := range r.bySecond {
+				times = append(times, time.Date(day.Year(), day.Month(), day.Day(), hour, minute, second, 0, r.location))
+			}
+		}
+	}
+	return times
+}
+
+// matchesDay tells whether the rule posts on the given day
+func (r *rrule) matchesDay(day time.Time) bool {
+	if len(r.byMonth) > 0 && !containsInt(r.byMonth, int(day.Month())) {
+		return false
+	}
+	if !r.start.IsZero() && r.periodsSinceStart(day)%r.interval != 0 {
+		return false
+	}
+	if len(r.byMonthDay) > 0 && !r.matchesMonthDay(day) {
+		return false
+	}
+	if len(r.byDay) > 0 {
+		return r.matchesWeekday(day)
+	}
+	if len(r.byMonthDay) > 0 {
+		return true
+	}
+
+	//without BYDAY and BYMONTHDAY the rule posts on the day of the start
+	start := r.start
+	if start.IsZero() {
+		start = time.Date(1970, time.January, 1, 0, 0, 0, 0, r.location)
+	}
+	switch r.frequency {
+	case rruleFrequencyWeekly:
+		return day.Weekday() == start.Weekday()
+	case rruleFrequencyMonthly:
+		return day.Day() == start.Day()
+	case rruleFrequencyYearly:
+		return day.Day() == start.Day() && (len(r.byMonth) > 0 || day.Month() == start.Month())
+	}
+	return true
+}
+
+func (r *rrule) matchesMonthDay(day time.Time) bool {
+	daysInMonth := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, r.location).Day()
+	for _, monthDay := range r.byMonthDay {
+		if monthDay == day.Day() || monthDay < 0 && daysInMonth+monthDay+1 == day.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+// countsWeekdaysInYear tells whether ordinal weekdays are counted within the year instead of the month, which is the
+// case for yearly rules without BYMONTH, e.g. BYDAY=1MO is the first Monday of the year
+func (r *rrule) countsWeekdaysInYear() bool {
+	return r.frequency == rruleFrequencyYearly && len(r.byMonth) == 0
+}
+
+func (r *rrule) matchesWeekday(day time.Time) bool {
+	dayOfPeriod := day.Day()
+	daysInPeriod := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, r.location).Day()
+	if r.countsWeekdaysInYear() {
+		dayOfPeriod = day.YearDay()
+		daysInPeriod = time.Date(day.Year(), time.December, 31, 0, 0, 0, 0, r.location).YearDay()
+	}
+	for _, byDay := range r.byDay {
+		if byDay.weekday != day.Weekday() {
+			continue
+		}
+		switch {
+		case byDay.ordinal == 0:
+			return true
+		case byDay.ordinal > 0 && (dayOfPeriod-1)/7+1 == byDay.ordinal:
+			return true
+		case byDay.ordinal < 0 && (daysInPeriod-dayOfPeriod)/7+1 == -byDay.ordinal:
+			return true
+		}
+	}
+	return false
+}
+
+// periodsSinceStart returns the number of days, weeks, months or years between the start and the given day
+func (r *rrule) periodsSinceStart(day time.Time) int {
+	start := startOfDay(r.start)
+	switch r.frequency {
+	case rruleFrequencyWeekly:
+		//weeks start on Monday
+		return civilDays(mondayOf(start), mondayOf(day)) / 7
+	case rruleFrequencyMonthly:
+		return (day.Year()*12 + int(day.Month())) - (start.Year()*12 + int(start.Month()))
+	case rruleFrequencyYearly:
+		return day.Year() - start.Year()
+	}
+	return civilDays(start, day)
+}
+
+// describe explains the rule in plain English
+func (r *rrule) describe() string {
+	parts := []string{rruleFrequencyNames[r.frequency]}
+	if len(r.byDay) > 0 {
+		days := []string{}
+		for _, day := range r.byDay {
+			name := weekdayNames[day.weekday]
+			switch {
+			case day.ordinal == -1:
+				name = "the last " + name
+			case day.ordinal < 0:
+				name = fmt.Sprintf("the %d. last %s", -day.ordinal, name)
+			case day.ordinal > 0:
+				name = fmt.Sprintf("the %d. %s", day.ordinal, name)
+			}
+			if day.ordinal != 0 && r.countsWeekdaysInYear() {
+				name = name + " of the year"
+			}
+			days = append(days, name)
+		}
+		parts = append(parts, "on "+joinWithAnd(days))
+	}
+	if len(r.byMonthDay) > 0 {
+		parts = append(parts, "on day-of-month "+joinWithAnd(intsToStrings(r.byMonthDay)))
+	}
+	if len(r.byMonth) > 0 {
+		months := []string{}
+		for _, month := range r.byMonth {
+			months = append(months, monthNames[month])
+		}
+		parts = append(parts, "in "+joinWithAnd(months))
+	}
+	if len(r.byHour) == 1 && len(r.byMinute) == 1 && len(r.bySecond) == 1 {
+		parts = append(parts, fmt.Sprintf("at %02d:%02d:%02d", r.byHour[0], r.byMinute[0], r.bySecond[0]))
+	}
+	if r.interval > 1 {
+		parts = append(parts, fmt.Sprintf("every %d %s", r.interval, rruleIntervalUnits[r.frequency]))
+	}
+	if !r.start.IsZero() {
+		parts = append(parts, "starting "+r.start.Format(timeFormat))
+	}
+	if r.count > 0 {
+		parts = append(parts, fmt.Sprintf("%d times", r.count))
+	}
+	if !r.until.IsZero() {
+		parts = append(parts, "until "+r.until.Format(timeFormat))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func mondayOf(day time.Time) time.Time {
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+// civilDays returns the number of calendar days between the given days, which is not affected by daylight saving time
+func civilDays(from time.Time, to time.Time) int {
+	fromUTC := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	toUTC := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(toUTC.Sub(fromUTC).Hours() / 24)
+}
+
+func containsInt(list []int, value int) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func intsToStrings(numbers []int) []string {
+	result := []string{}
+	for _, number := range numbers {
+		result = append(result, strconv.Itoa(number))
+	}
+	return result
+}
+
+func joinWithAnd(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
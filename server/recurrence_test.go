@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRecurrence(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	testCases := []struct {
+		name     string
+		spec     string
+		from     time.Time
+		expected []time.Time
+	}{
+		{
+			"Cron-syntax", "CRON_TZ=UTC 0 0 12 * * *", time.Date(2020, time.June, 1, 12, 0, 0, 0, time.UTC),
+			[]time.Time{time.Date(2020, time.June, 2, 12, 0, 0, 0, time.UTC), time.Date(2020, time.June, 3, 12, 0, 0, 0, time.UTC)},
+		},
+		{
+			"Every other week", "CRON_TZ=UTC RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;DTSTART=20200601T090000", time.Date(2020, time.June, 1, 9, 0, 0, 0, time.UTC),
+			[]time.Time{time.Date(2020, time.June, 3, 9, 0, 0, 0, time.UTC), time.Date(2020, time.June, 15, 9, 0, 0, 0, time.UTC), time.Date(2020, time.June, 17, 9, 0, 0, 0, time.UTC)},
+		},
+		{
+			"Last Friday of the month", "CRON_TZ=UTC RRULE:FREQ=MONTHLY;BYDAY=-1FR;BYHOUR=17", time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC),
+			[]time.Time{time.Date(2020, time.June, 26, 17, 0, 0, 0, time.UTC), time.Date(2020, time.July, 31, 17, 0, 0, 0, time.UTC)},
+		},
+		{
+			"Last day of the month", "CRON_TZ=UTC RRULE:FREQ=MONTHLY;BYMONTHDAY=-1;BYHOUR=8", time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC),
+			[]time.Time{time.Date(2020, time.February, 29, 8, 0, 0, 0, time.UTC), time.Date(2020, time.March, 31, 8, 0, 0, 0, time.UTC)},
+		},
+		{
+			"Count", "CRON_TZ=UTC RRULE:FREQ=DAILY;COUNT=2;DTSTART=20200601T080000", time.Date(2020, time.May, 1, 0, 0, 0, 0, time.UTC),
+			[]time.Time{time.Date(2020, time.June, 1, 8, 0, 0, 0, time.UTC), time.Date(2020, time.June, 2, 8, 0, 0, 0, time.UTC)},
+		},
+		{
+			"Until", "CRON_TZ=UTC RRULE:FREQ=DAILY;BYHOUR=8;UNTIL=20200603T235959Z", time.Date(2020, time.June, 1, 12, 0, 0, 0, time.UTC),
+			[]time.Time{time.Date(2020, time.June, 2, 8, 0, 0, 0, time.UTC), time.Date(2020, time.June, 3, 8, 0, 0, 0, time.UTC)},
+		},
+		{
+			"Timezone", "CRON_TZ=Europe/Berlin RRULE:FREQ=YEARLY;BYMONTH=12;BYMONTHDAY=24;BYHOUR=18", time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC),
+			[]time.Time{time.Date(2020, time.December, 24, 18, 0, 0, 0, berlin), time.Date(2021, time.December, 24, 18, 0, 0, 0, berlin)},
+		},
+		{
+			"First Monday of the year", "CRON_TZ=UTC RRULE:FREQ=YEARLY;BYDAY=1MO;BYHOUR=9", time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC),
+			[]time.Time{time.Date(2021, time.January, 4, 9, 0, 0, 0, time.UTC), time.Date(2022, time.January, 3, 9, 0, 0, 0, time.UTC)},
+		},
+		{
+			"Last Friday of the year", "CRON_TZ=UTC RRULE:FREQ=YEARLY;BYDAY=-1FR;BYHOUR=12", time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC),
+			[]time.Time{time.Date(2020, time.December, 25, 12, 0, 0, 0, time.UTC), time.Date(2021, time.December, 31, 12, 0, 0, 0, time.UTC)},
+		},
+		{
+			"First Monday of a month of a yearly rule", "CRON_TZ=UTC RRULE:FREQ=YEARLY;BYMONTH=9;BYDAY=1MO;BYHOUR=9", time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC),
+			[]time.Time{time.Date(2020, time.September, 7, 9, 0, 0, 0, time.UTC), time.Date(2021, time.September, 6, 9, 0, 0, 0, time.UTC)},
+		},
+		{
+			"Once", "CRON_TZ=Europe/Berlin @at 2020-06-01T09:00", time.Date(2020, time.May, 1, 0, 0, 0, 0, time.UTC),
+			[]time.Time{time.Date(2020, time.June, 1, 7, 0, 0, 0, time.UTC)},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			runs, err := nextRuns(testCase.spec, testCase.from, len(testCase.expected)+1)
+			assert.Nil(t, err)
+			if len(runs) > len(testCase.expected) {
+				runs = runs[:len(testCase.expected)]
+			}
+			if assert.Len(t, runs, len(testCase.expected)) {
+				for i, expected := range testCase.expected {
+					assert.True(t, expected.Equal(runs[i]), "expected %s, got %s", expected, runs[i])
+				}
+			}
+		})
+	}
+
+	t.Run("Count and until end the rule", func(t *testing.T) {
+		runs, _ := nextRuns("CRON_TZ=UTC RRULE:FREQ=DAILY;COUNT=2;DTSTART=20200601T080000", time.Date(2020, time.May, 1, 0, 0, 0, 0, time.UTC), 5)
+		assert.Len(t, runs, 2)
+		runs, _ = nextRuns("CRON_TZ=UTC RRULE:FREQ=DAILY;BYHOUR=8;UNTIL=20200603T235959Z", time.Date(2020, time.June, 1, 12, 0, 0, 0, time.UTC), 5)
+		assert.Len(t, runs, 2)
+		runs, _ = nextRuns("CRON_TZ=UTC @at 2020-06-01T09:00", time.Date(2020, time.May, 1, 0, 0, 0, 0, time.UTC), 5)
+		assert.Len(t, runs, 1)
+	})
+}
+
+func TestParseRecurrence_fail(t *testing.T) {
+	testCases := []struct {
+		name string
+		spec string
+		err  string
+	}{
+		{"Unsupported frequency", "RRULE:FREQ=HOURLY", "unsupported frequency HOURLY"},
+		{"Missing frequency", "RRULE:BYDAY=MO", "missing FREQ"},
+		{"Interval without start", "RRULE:FREQ=DAILY;INTERVAL=2", "INTERVAL and COUNT need a DTSTART"},
+		{"Ordinal weekday of a weekly rule", "RRULE:FREQ=WEEKLY;BYDAY=2MO", "ordinal weekdays are only supported for monthly and yearly rules"},
+		{"Ordinal weekday beyond the month", "RRULE:FREQ=MONTHLY;BYDAY=6MO", "invalid BYDAY: the ordinal 6 is not between -5 and 5"},
+		{"Ordinal weekday beyond the month of a yearly rule", "RRULE:FREQ=YEARLY;BYMONTH=1;BYDAY=-6MO", "invalid BYDAY: the ordinal -6 is not between -5 and 5"},
+		{"Ordinal weekday beyond the year", "RRULE:FREQ=YEARLY;BYDAY=54MO", "invalid BYDAY: invalid ordinal 54"},
+		{"Zero ordinal", "RRULE:FREQ=MONTHLY;BYDAY=0MO", "invalid BYDAY: invalid ordinal 0"},
+		{"Unknown weekday", "RRULE:FREQ=WEEKLY;BYDAY=XX", "invalid BYDAY: unknown weekday XX"},
+		{"Invalid hour", "RRULE:FREQ=DAILY;BYHOUR=24", "invalid BYHOUR: 24 is not between 0 and 23"},
+		{"Invalid time", "@at tomorrow", "invalid time tomorrow, use the format 2006-01-02T15:04"},
+		{"Unknown timezone", "CRON_TZ=Mars/Olympus RRULE:FREQ=DAILY", "unknown timezone Mars/Olympus"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := parseRecurrence(testCase.spec)
+			if assert.NotNil(t, err) {
+				assert.Equal(t, testCase.err, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	//reconcileSchedule is the cron-syntax of the job applying changes made by other nodes to the registry, in
+	//case a node missed a published change
+	reconcileSchedule = "@every 5m"
+)
+
+// registration is a schedule registered with our scheduler
+type registration struct {
+	msg        ScheduledMessage //the schedule as it has been registered, this version is posted
+	recurrence recurrence
+	nextRun    time.Time //zero if the schedule does not post again
+	//claimedUntil is when the claim of another node on the next run ends, the schedule is not queued before
+	claimedUntil time.Time
+}
+
+// registeredChanged tells whether the given stored schedule posts something else than the registered one
+func registeredChanged(registered ScheduledMessage, stored ScheduledMessage) bool {
+	return registered.Creator != stored.Creator ||
+		registered.ChannelID != stored.ChannelID ||
+		registered.TeamID != stored.TeamID ||
+		registered.Cron != stored.Cron ||
+		registered.Message != stored.Message
+}
+
+// getRegistration returns the registration of the schedule with the given ID
+func (p *Plugin) getRegistration(scheduleID string) (registration, bool) {
+	p.registryLock.Lock()
+	defer p.registryLock.Unlock()
+	registered, ok := p.registry[scheduleID]
+	return registered, ok
+}
+
+// setNextRun updates the next run of a registered schedule, e.g. after it has been posted by another node
+func (p *Plugin) setNextRun(scheduleID string, nextRun time.Time) {
+	p.registryLock.Lock()
+	defer p.registryLock.Unlock()
+	if registered, ok := p.registry[scheduleID]; ok {
+		registered.nextRun = nextRun
+		registered.claimedUntil = time.Time{}
+		p.registry[scheduleID] = registered
+	}
+}
+
+// setClaimedUntil remembers until when another node claimed the next run of a registered schedule
+func (p *Plugin) setClaimedUntil(scheduleID string, claimedUntil time.Time) {
+	p.registryLock.Lock()
+	defer p.registryLock.Unlock()
+	if registered, ok := p.registry[scheduleID]; ok {
+		registered.claimedUntil = claimedUntil
+		p.registry[scheduleID] = registered
+	}
+}
+
+// reconcileSchedules makes our registry match the given stored schedules: active schedules are registered, changed
+// ones are registered again and all others are removed. The next runs are kept in the KVStore, so they survive
+// restarts and are shared by all nodes.
+func (p *Plugin) reconcileSchedules(data SchedulerData) {
+	if p.getCron() == nil {
+		return
+	}
+	p.registryLock.Lock()
+	defer p.registryLock.Unlock()
+	if p.registry == nil {
+		p.registry = map[string]registration{}
+	}
+
+	active := map[string]bool{}
+	for _, msg := range data.ScheduledMessages {
+		if msg.GetState() != stateActive {
+			continue
+		}
+		active[msg.ID] = true
+		registered, ok := p.registry[msg.ID]
+		if ok && !registeredChanged(registered.msg, msg) {
+			continue
+		}
+		schedule, err := parseRecurrence(msg.Cron)
+		if err != nil {
+			p.recordError("Failed to schedule the message %s: %s", msg.ID, err.Error())
+			delete(p.registry, msg.ID)
+			continue
+		}
+		p.registry[msg.ID] = registration{msg: msg, recurrence: schedule, nextRun: p.ensureDueRecord(msg, schedule)}
+	}
+
+	for scheduleID := range p.registry {
+		if !active[scheduleID] {
+			delete(p.registry, scheduleID)
+			//a paused schedule must not catch up on the runs it missed when it is resumed
+			p.ClearDueFromStorage(scheduleID)
+		}
+	}
+}
+
+// reconcileFromStorage applies the stored schedules to our registry, which picks up changes made by other nodes
+func (p *Plugin) reconcileFromStorage() {
+	p.reconcileSchedules(p.ReadFromStorage())
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReconcileSchedules(t *testing.T) {
+	daily := ScheduledMessage{ID: "daily", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@daily", Message: "Hello"}
+	weekly := ScheduledMessage{ID: "weekly", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@weekly", Message: "Weekly"}
+
+	setupPlugin := func() (*Plugin, *plugintest.API) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		api := &plugintest.API{}
+		expectSchedulerStorage(api)
+		plugin.SetAPI(api)
+		return plugin, api
+	}
+
+	t.Run("Registers active schedules only", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		paused := weekly
+		paused.State = statePaused
+		plugin.reconcileSchedules(SchedulerData{ScheduledMessages: []ScheduledMessage{daily, paused}})
+
+		assert.Len(t, plugin.registry, 1)
+		assert.Contains(t, plugin.registry, "daily")
+		assert.True(t, plugin.registry["daily"].nextRun.After(time.Now()))
+	})
+	t.Run("Keeps unchanged schedules", func(t *testing.T) {
+		plugin, api := setupPlugin()
+		plugin.reconcileSchedules(SchedulerData{ScheduledMessages: []ScheduledMessage{daily}})
+
+		withReason := daily
+		withReason.Reason = "Only the reason changed"
+		plugin.reconcileSchedules(SchedulerData{ScheduledMessages: []ScheduledMessage{withReason, weekly}})
+		assert.Len(t, plugin.registry, 2)
+		//the next run of the daily schedule has only been stored once
+		api.AssertNumberOfCalls(t, "KVSetWithOptions", 2)
+	})
+	t.Run("Replaces changed schedules", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		plugin.reconcileSchedules(SchedulerData{ScheduledMessages: []ScheduledMessage{daily}})
+
+		edited := daily
+		edited.Cron = "@hourly"
+		plugin.reconcileSchedules(SchedulerData{ScheduledMessages: []ScheduledMessage{edited}})
+		assert.Equal(t, "@hourly", plugin.registry["daily"].msg.Cron)
+		assert.True(t, plugin.registry["daily"].nextRun.Before(time.Now().Add(time.Hour+time.Second)))
+	})
+	t.Run("Removes schedules that are gone or not active anymore", func(t *testing.T) {
+		plugin, api := setupPlugin()
+		plugin.reconcileSchedules(SchedulerData{ScheduledMessages: []ScheduledMessage{daily, weekly}})
+
+		disabled := weekly
+		disabled.State = stateDisabled
+		plugin.reconcileSchedules(SchedulerData{ScheduledMessages: []ScheduledMessage{disabled}})
+		assert.Empty(t, plugin.registry)
+		api.AssertCalled(t, "KVDelete", DUEKEYPREFIX+"daily")
+		api.AssertCalled(t, "KVDelete", DUEKEYPREFIX+"weekly")
+	})
+	t.Run("Records invalid recurrences", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		invalid := daily
+		invalid.Cron = "every day"
+		plugin.reconcileSchedules(SchedulerData{ScheduledMessages: []ScheduledMessage{invalid}})
+
+		assert.Empty(t, plugin.registry)
+		assert.Len(t, plugin.recentErrors, 1)
+	})
+	t.Run("Keeps the stored next run", func(t *testing.T) {
+		plugin := &Plugin{pluginCron: cron.New(cron.WithSeconds())}
+		nextRun := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+		api := &plugintest.API{}
+		api.On("KVGet", DUEKEYPREFIX+"daily").Return(mustMarshal(dueRecord{Spec: "@daily", NextRun: toMillis(nextRun)}), nil)
+		plugin.SetAPI(api)
+
+		//the plugin has been restarted, the missed run is still due
+		plugin.reconcileSchedules(SchedulerData{ScheduledMessages: []ScheduledMessage{daily}})
+		assert.True(t, nextRun.Equal(plugin.registry["daily"].nextRun))
+		api.AssertNotCalled(t, "KVSetWithOptions", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
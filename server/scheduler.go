@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	//duePollSchedule is the cron-syntax of the job posting the schedules that are due
+	duePollSchedule = "@every 1s"
+	//claimDuration is how long a node may take to post a schedule it claimed, afterwards another node may claim it
+	claimDuration = 2 * time.Minute
+	//misfireGracePeriod is how late a run missed while the plugin was not running is posted, later runs are skipped
+	misfireGracePeriod = time.Hour
+	//maxDeliveryAttempts is how often a run that could not be posted is tried, afterwards it is dropped
+	maxDeliveryAttempts = 3
+	//deliveryRetryDelay is how long a run that could not be posted waits before it is tried again
+	deliveryRetryDelay = 30 * time.Second
+)
+
+// dueRecord is stored in the KVStore for every active schedule and contains its next run. A node claims the record
+// before it posts the schedule, so every run is only posted once even if several nodes think they are the leader.
+type dueRecord struct {
+	Spec         string `json:"spec"`                   //recurrence the next run has been computed from
+	NextRun      int64  `json:"nextRun"`                //time in millis, zero if the schedule does not post again
+	ClaimedBy    string `json:"claimedBy,omitempty"`    //ID of the plugin instance posting the schedule
+	ClaimedUntil int64  `json:"claimedUntil,omitempty"` //time in millis the claim ends
+	Attempts     int    `json:"attempts,omitempty"`     //number of failed attempts to post the next run
+}
+
+// ensureDueRecord returns the next run of the given schedule. The stored next run is used if it has been computed
+// from the same recurrence, even if it is in the past because the plugin was not running, otherwise the next run is
+// computed and stored.
+func (p *Plugin) ensureDueRecord(msg ScheduledMessage, schedule recurrence) time.Time {
+	now := time.Now()
+	for attempt := 0; attempt < 2; attempt++ {
+		record, oldValue := p.ReadDueFromStorage(msg.ID)
+		if record != nil && record.Spec == msg.Cron {
+			return millisOrZero(record.NextRun)
+		}
+
+		next := schedule.Next(now)
+		stored := &dueRecord{Spec: msg.Cron, NextRun: toMillisOrZero(next)}
+		if ok := p.WriteDueToStorage(msg.ID, stored, oldValue); ok {
+			return next
+		}
+		//another node stored the next run in the meantime, so use its record
+	}
+	record, _ := p.ReadDueFromStorage(msg.ID)
+	if record == nil {
+		return time.Time{}
+	}
+	return millisOrZero(record.NextRun)
+}
+
+// pollDueSchedules queues all registered schedules that are due, the delivery pool posts them. Only the leader posts
+// schedules.
+func (p *Plugin) pollDueSchedules() {
+	pool := p.getDeliveryPool()
+	if !p.isLeader() || pool == nil {
+		return
+	}
+	now := time.Now()
+
+	p.registryLock.Lock()
+	due := []registration{}
+	for _, registered := range p.registry {
+		//a run claimed by another node is not tried again before the claim ends
+		if registered.nextRun.IsZero() || now.Before(registered.claimedUntil) {
+			continue
+		}
+		if !registered.nextRun.Add(p.deliveryJitter(registered.msg.ID)).After(now) {
+			due = append(due, registered)
+		}
+	}
+	p.registryLock.Unlock()
+
+	for _, registered := range due {
+		pool.enqueue(registered, registered.nextRun.Add(p.deliveryJitter(registered.msg.ID)))
+	}
+}
+
+// deliverQueued posts the given queued schedule, unless it has been changed or posted since it has been queued
+func (p *Plugin) deliverQueued(queued registration) {
+	registered, ok := p.getRegistration(queued.msg.ID)
+	if !ok || !registered.nextRun.Equal(queued.nextRun) {
+		return
+	}
+	p.deliverDue(registered)
+}
+
+// deliverDue claims the next run of the given schedule, posts it and stores the run after it
+func (p *Plugin) deliverDue(registered registration) {
+	msg := registered.msg
+	record, claimedValue := p.claimDue(msg.ID, registered.nextRun)
+	if record == nil {
+		return
+	}
+	scheduledAt := fromMillis(record.NextRun)
+
+	if time.Since(scheduledAt) > misfireGracePeriod {
+		//the run has been missed while the plugin was not running
+		now := toMillis(time.Now())
+		p.appendRunRecord(msg.ID, RunRecord{
+			ScheduledAt: record.NextRun,
+			ExecutedAt:  now,
+			Node:        nodeName(),
+			Trigger:     triggerCron,
+			Outcome:     outcomeSkipped,
+			Error:       fmt.Sprintf("the run has been missed while the plugin was not running and is more than %s late", misfireGracePeriod),
+		})
+	} else if !p.deliverOccurrence(msg, scheduledAt) && record.Attempts+1 < maxDeliveryAttempts {
+		//the run is kept and claimed until it is tried again, so a failure like a lost connection does not drop it
+		retryAt := time.Now().Add(deliveryRetryDelay)
+		retry := *record
+		retry.Attempts++
+		retry.ClaimedUntil = toMillis(retryAt)
+		if !p.WriteDueToStorage(msg.ID, &retry, claimedValue) {
+			p.recordError("Failed to store the next try of the scheduled message %s", msg.ID)
+			return
+		}
+		p.setClaimedUntil(msg.ID, retryAt)
+		return
+	}
+
+	//runs missed in the meantime are skipped
+	after := scheduledAt
+	if now := time.Now(); now.After(after) {
+		after = now
+	}
+	next := registered.recurrence.Next(after)
+	completed := &dueRecord{Spec: record.Spec, NextRun: toMillisOrZero(next)}
+	if !p.WriteDueToStorage(msg.ID, completed, claimedValue) {
+		p.recordError("Failed to store the next run of the scheduled message %s, it took longer than %s to post it", msg.ID, claimDuration)
+		return
+	}
+	p.setNextRun(msg.ID, next)
+}
+
+// claimDue claims the next run of the schedule with the given ID if it is the expected one and nobody else claimed
+// it. It returns the claimed record and its stored value, or nil if the run has not been claimed.
+func (p *Plugin) claimDue(scheduleID string, expected time.Time) (*dueRecord, []byte) {
+	now := time.Now()
+	record, oldValue := p.ReadDueFromStorage(scheduleID)
+	if record == nil {
+		return nil, nil //the schedule has been removed in the meantime
+	}
+	if record.NextRun != toMillisOrZero(expected) {
+		//another node posted the schedule already
+		p.setNextRun(scheduleID, millisOrZero(record.NextRun))
+		return nil, nil
+	}
+	if record.ClaimedBy != "" && record.ClaimedUntil > toMillis(now) {
+		p.setClaimedUntil(scheduleID, fromMillis(record.ClaimedUntil))
+		return nil, nil
+	}
+
+	claimed := *record
+	claimed.ClaimedBy = p.nodeID
+	claimed.ClaimedUntil = toMillis(now.Add(claimDuration))
+	if !p.WriteDueToStorage(scheduleID, &claimed, oldValue) {
+		return nil, nil
+	}
+	claimedValue, _ := json.Marshal(claimed)
+	return &claimed, claimedValue
+}
+
+// toMillisOrZero returns the given time in millis, or zero for the zero time
+func toMillisOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return toMillis(t)
+}
+
+// millisOrZero returns the time of the given millis, or the zero time for zero
+func millisOrZero(millis int64) time.Time {
+	if millis == 0 {
+		return time.Time{}
+	}
+	return fromMillis(millis)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// expectSchedulerStorage mocks the published changes and the next runs of the schedules, which are written whenever
+// the schedules are stored. The next runs are optional, as not every write changes an active schedule.
+func expectSchedulerStorage(api *plugintest.API) {
+	isDueKey := mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, DUEKEYPREFIX) })
+	api.On("KVSet", REVISIONKEY, mock.Anything).Return(nil)
+	api.On("KVGet", isDueKey).Return(nil, nil).Maybe()
+	api.On("KVSetWithOptions", isDueKey, mock.Anything, mock.Anything).Return(true, nil).Maybe()
+	api.On("KVDelete", isDueKey).Return(nil).Maybe()
+}
+
+func TestPollDueSchedules(t *testing.T) {
+	msg := ScheduledMessage{ID: "daily", Creator: "TestUser", ChannelID: "TestChannel", Cron: "@daily", Message: "Hello"}
+	schedule, _ := parseRecurrence(msg.Cron)
+
+	//postErr is returned when the schedule is posted
+	var postErr *model.AppError
+	setupPlugin := func(record dueRecord, nextRun time.Time) (*Plugin, *plugintest.API) {
+		postErr = nil
+		plugin := &Plugin{nodeID: "node1", pluginCron: cron.New(cron.WithSeconds()), leaderUntil: time.Now().Add(time.Minute)}
+		plugin.registry = map[string]registration{"daily": registration{msg: msg, recurrence: schedule, nextRun: nextRun}}
+		api := &plugintest.API{}
+		api.On("KVGet", DUEKEYPREFIX+"daily").Return(mustMarshal(record), nil)
+		api.On("KVSetWithOptions", DUEKEYPREFIX+"daily", mock.Anything, mock.Anything).Return(true, nil)
+		expectDeliveries(api)
+		api.On("KVGet", HISTORYKEYPREFIX+"daily").Return(nil, nil)
+		api.On("KVSetWithOptions", HISTORYKEYPREFIX+"daily", mock.Anything, mock.Anything).Return(true, nil)
+		api.On("GetChannel", "TestChannel").Return(&model.Channel{Id: "TestChannel", Name: "test"}, nil)
+		api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(*model.Post) *model.Post {
+			if postErr != nil {
+				return nil
+			}
+			return &model.Post{Id: "post1"}
+		}, func(*model.Post) *model.AppError {
+			return postErr
+		})
+		api.On("LogError", mock.Anything, mock.Anything, mock.Anything).Maybe()
+		plugin.SetAPI(api)
+		plugin.setConfiguration(&configuration{})
+		plugin.deliveryPool = newDeliveryPool(plugin.deliveryWorkers, plugin.deliverQueued)
+		return plugin, api
+	}
+	poll := func(plugin *Plugin) {
+		plugin.pollDueSchedules()
+		plugin.deliveryPool.wait()
+	}
+	storedRecords := func(api *plugintest.API) []dueRecord {
+		records := []dueRecord{}
+		for _, call := range api.Calls {
+			if call.Method == "KVSetWithOptions" && strings.HasPrefix(call.Arguments.String(0), DUEKEYPREFIX) {
+				record := dueRecord{}
+				json.Unmarshal(call.Arguments.Get(1).([]byte), &record)
+				records = append(records, record)
+			}
+		}
+		return records
+	}
+
+	t.Run("Posts due schedules and stores the next run", func(t *testing.T) {
+		nextRun := time.Now().Add(-time.Second).Truncate(time.Millisecond)
+		plugin, api := setupPlugin(dueRecord{Spec: "@daily", NextRun: toMillis(nextRun)}, nextRun)
+
+		poll(plugin)
+		api.AssertNumberOfCalls(t, "CreatePost", 1)
+		records := storedRecords(api)
+		assert.Len(t, records, 2)
+		assert.Equal(t, "node1", records[0].ClaimedBy, "the run is claimed first")
+		assert.Equal(t, "", records[1].ClaimedBy)
+		assert.Equal(t, toMillis(schedule.Next(time.Now())), records[1].NextRun)
+		assert.True(t, plugin.registry["daily"].nextRun.After(time.Now()))
+	})
+	t.Run("Delays schedules by their jitter", func(t *testing.T) {
+		nextRun := time.Now().Add(-time.Second).Truncate(time.Millisecond)
+		plugin, api := setupPlugin(dueRecord{Spec: "@daily", NextRun: toMillis(nextRun)}, nextRun)
+		plugin.setConfiguration(&configuration{DeliveryJitterSeconds: 3600})
+		assert.True(t, plugin.deliveryJitter("daily") > time.Second)
+
+		poll(plugin)
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+	})
+	t.Run("Only the leader posts", func(t *testing.T) {
+		nextRun := time.Now().Add(-time.Second).Truncate(time.Millisecond)
+		plugin, api := setupPlugin(dueRecord{Spec: "@daily", NextRun: toMillis(nextRun)}, nextRun)
+		plugin.leaderUntil = time.Time{}
+
+		poll(plugin)
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+	})
+	t.Run("Does not post runs claimed by another node", func(t *testing.T) {
+		nextRun := time.Now().Add(-time.Second).Truncate(time.Millisecond)
+		plugin, api := setupPlugin(dueRecord{Spec: "@daily", NextRun: toMillis(nextRun), ClaimedBy: "node2", ClaimedUntil: toMillis(time.Now().Add(time.Minute))}, nextRun)
+
+		poll(plugin)
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+		api.AssertNotCalled(t, "KVSetWithOptions", DUEKEYPREFIX+"daily", mock.Anything, mock.Anything)
+	})
+	t.Run("Waits for the claim of another node to expire", func(t *testing.T) {
+		nextRun := time.Now().Add(-time.Second).Truncate(time.Millisecond)
+		claimedUntil := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+		plugin, api := setupPlugin(dueRecord{Spec: "@daily", NextRun: toMillis(nextRun), ClaimedBy: "node2", ClaimedUntil: toMillis(claimedUntil)}, nextRun)
+
+		poll(plugin)
+		poll(plugin)
+		api.AssertNumberOfCalls(t, "KVGet", 1)
+		assert.True(t, claimedUntil.Equal(plugin.registry["daily"].claimedUntil))
+
+		plugin.setNextRun("daily", nextRun)
+		assert.True(t, plugin.registry["daily"].claimedUntil.IsZero(), "a new next run is not claimed")
+	})
+	t.Run("Tries failed posts again", func(t *testing.T) {
+		nextRun := time.Now().Add(-time.Second).Truncate(time.Millisecond)
+		plugin, api := setupPlugin(dueRecord{Spec: "@daily", NextRun: toMillis(nextRun)}, nextRun)
+		postErr = &model.AppError{Message: "failed"}
+
+		poll(plugin)
+		api.AssertNumberOfCalls(t, "CreatePost", 1)
+		records := storedRecords(api)
+		if assert.Len(t, records, 2) {
+			assert.Equal(t, toMillis(nextRun), records[1].NextRun, "the run is kept")
+			assert.Equal(t, 1, records[1].Attempts)
+			assert.Equal(t, "node1", records[1].ClaimedBy)
+			assert.True(t, fromMillis(records[1].ClaimedUntil).After(time.Now()))
+		}
+		assert.True(t, nextRun.Equal(plugin.registry["daily"].nextRun))
+		assert.True(t, plugin.registry["daily"].claimedUntil.After(time.Now()), "the run waits before it is tried again")
+	})
+	t.Run("Drops failed posts after the last attempt", func(t *testing.T) {
+		nextRun := time.Now().Add(-time.Second).Truncate(time.Millisecond)
+		plugin, api := setupPlugin(dueRecord{Spec: "@daily", NextRun: toMillis(nextRun), Attempts: maxDeliveryAttempts - 1}, nextRun)
+		postErr = &model.AppError{Message: "failed"}
+
+		poll(plugin)
+		api.AssertNumberOfCalls(t, "CreatePost", 1)
+		records := storedRecords(api)
+		if assert.Len(t, records, 2) {
+			assert.Equal(t, toMillis(schedule.Next(time.Now())), records[1].NextRun)
+			assert.Equal(t, 0, records[1].Attempts)
+		}
+		assert.True(t, plugin.registry["daily"].nextRun.After(time.Now()))
+	})
+	t.Run("Takes over expired claims", func(t *testing.T) {
+		nextRun := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+		plugin, api := setupPlugin(dueRecord{Spec: "@daily", NextRun: toMillis(nextRun), ClaimedBy: "node2", ClaimedUntil: toMillis(time.Now().Add(-time.Second))}, nextRun)
+
+		poll(plugin)
+		api.AssertNumberOfCalls(t, "CreatePost", 1)
+	})
+	t.Run("Does not post runs posted by another node", func(t *testing.T) {
+		posted := time.Now().Add(-time.Second).Truncate(time.Millisecond)
+		nextRun := schedule.Next(time.Now()).Truncate(time.Millisecond)
+		plugin, api := setupPlugin(dueRecord{Spec: "@daily", NextRun: toMillis(nextRun)}, posted)
+
+		poll(plugin)
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+		assert.True(t, nextRun.Equal(plugin.registry["daily"].nextRun))
+	})
+	t.Run("Skips runs missed for too long", func(t *testing.T) {
+		nextRun := time.Now().Add(-2 * misfireGracePeriod).Truncate(time.Millisecond)
+		plugin, api := setupPlugin(dueRecord{Spec: "@daily", NextRun: toMillis(nextRun)}, nextRun)
+
+		poll(plugin)
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+		api.AssertCalled(t, "KVSetWithOptions", HISTORYKEYPREFIX+"daily", mock.MatchedBy(func(value []byte) bool {
+			history := []RunRecord{}
+			json.Unmarshal(value, &history)
+			return len(history) == 1 && history[0].Outcome == outcomeSkipped
+		}), mock.Anything)
+		assert.True(t, plugin.registry["daily"].nextRun.After(time.Now()))
+	})
+	t.Run("One-shot schedules do not post again", func(t *testing.T) {
+		nextRun := time.Now().Add(-time.Second).Truncate(time.Second)
+		plugin, api := setupPlugin(dueRecord{Spec: "@at", NextRun: toMillis(nextRun)}, nextRun)
+		once := plugin.registry["daily"]
+		once.recurrence = &oneShot{at: nextRun}
+		plugin.registry["daily"] = once
+
+		poll(plugin)
+		api.AssertNumberOfCalls(t, "CreatePost", 1)
+		assert.True(t, plugin.registry["daily"].nextRun.IsZero())
+		poll(plugin)
+		api.AssertNumberOfCalls(t, "CreatePost", 1)
+	})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// scheduleError is returned by the operations on schedules shared by the slash commands and the REST API.
+// Status is the HTTP status code fitting the error.
+type scheduleError struct {
+	Status  int
+	Message string
+}
+
+func (e *scheduleError) Error() string {
+	return e.Message
+}
+
+func newScheduleError(status int, format string, args ...interface{}) *scheduleError {
+	return &scheduleError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// findPermittedSchedule returns the index of the schedule with the given ID, if the user is allowed to see it.
+// Set manage if the schedule is going to be changed, so it is only returned to users who can manage it.
+func (p *Plugin) findPermittedSchedule(userID string, scheduleID string, messages []ScheduledMessage, manage bool) (int, *scheduleError) {
+	for index, msg := range messages {
+		if msg.ID != scheduleID {
+			continue
+		}
+		//do not tell users about schedules they are not allowed to see
+		if !p.canView(userID, msg) {
+			break
+		}
+		if manage && !p.canManage(userID, msg) {
+			return -1, newScheduleError(http.StatusForbidden, "You are not allowed to change the scheduled message %s", msg.ID)
+		}
+		return index, nil
+	}
+	return -1, newScheduleError(http.StatusNotFound, "There is no schedule with the ID %s", scheduleID)
+}
+
+// createSchedule adds a new schedule for the given user. Schedules of channels requiring an approval are stored as
+// pending and the channel admins are asked to approve them.
+func (p *Plugin) createSchedule(userID string, channelID string, rootID string, cronSpec string, message string) (*ScheduledMessage, *scheduleError) {
+	return p.createScheduleAs(userID, ScheduledMessage{
+		Creator:   userID,
+		ChannelID: channelID,
+		TeamID:    rootID,
+		Cron:      cronSpec,
+		Message:   message,
+	})
+}
+
+// createScheduleAs adds the given schedule with a new ID on behalf of the given actor, who is recorded in the audit
+// log. The owner of the schedule has to be allowed to post in its channel.
+func (p *Plugin) createScheduleAs(actorID string, newMessage ScheduledMessage) (*ScheduledMessage, *scheduleError) {
+	if !p.canPostIn(newMessage.Creator, newMessage.ChannelID) {
+		if newMessage.Creator != actorID {
+			return nil, newScheduleError(http.StatusForbidden, "The owner is not allowed to post in this channel")
+		}
+		return nil, newScheduleError(http.StatusForbidden, "You are not allowed to post in this channel")
+	}
+	if err := p.checkDestination(newMessage.ChannelID); err != nil {
+		return nil, newScheduleError(http.StatusForbidden, "Your message violates the restrictions set by the admins: %s", err.Error())
+	}
+	if _, err := parseRecurrence(newMessage.Cron); err != nil {
+		return nil, newScheduleError(http.StatusBadRequest, "Cannot start cron-job. Is your cron-syntax correct?")
+	}
+	newMessage.ID = model.NewId()
+
+	approvalNeeded := p.needsApproval(newMessage.Creator, newMessage.ChannelID)
+	var approvers []string
+	if approvalNeeded {
+		if approvers = p.findApprovers(newMessage.ChannelID); len(approvers) == 0 {
+			return nil, newScheduleError(http.StatusConflict, noApproversMessage)
+		}
+		newMessage.State = statePending
+		newMessage.Reason = reasonPendingApproval
+	}
+
+	err := p.updateStorage(func(data *SchedulerData) error {
+		if err := p.checkPolicy(newMessage, data.ScheduledMessages); err != nil {
+			return newScheduleError(http.StatusBadRequest, "Your message violates the limits set by the admins: %s", err.Error())
+		}
+		data.ScheduledMessages = append(data.ScheduledMessages, newMessage)
+		return nil
+	})
+	if scheduleErr := toScheduleError(err); scheduleErr != nil {
+		return nil, scheduleErr
+	}
+	p.recordAudit(actorID, auditActionCreate, nil, &newMessage, "")
+	if approvalNeeded {
+		p.requestApproval(newMessage, approvers)
+	}
+
+	return &newMessage, nil
+}
+
+// changeSchedule passes the stored schedules and the index of the one with the given ID to the given function and
+// stores the changes it makes. Like with updateStorage, the function is called again if someone else changed the
+// schedules in the meantime.
+func (p *Plugin) changeSchedule(scheduleID string, change func(data *SchedulerData, index int) *scheduleError) *scheduleError {
+	err := p.updateStorage(func(data *SchedulerData) error {
+		for index := range data.ScheduledMessages {
+			if data.ScheduledMessages[index].ID != scheduleID {
+				continue
+			}
+			if scheduleErr := change(data, index); scheduleErr != nil {
+				return scheduleErr
+			}
+			return nil
+		}
+		return newScheduleError(http.StatusNotFound, "There is no schedule with the ID %s", scheduleID)
+	})
+	return toScheduleError(err)
+}
+
+// toScheduleError turns an error returned while storing the schedules into a scheduleError
+func toScheduleError(err error) *scheduleError {
+	if err == nil {
+		return nil
+	}
+	if scheduleErr, ok := err.(*scheduleError); ok {
+		return scheduleErr
+	}
+	return newScheduleError(http.StatusInternalServerError, "Cannot store the scheduled messages, please try again")
+}
+
+// updateSchedule changes the cron and message of the schedule with the given ID. The caller has to make sure the
+// user is allowed to manage the schedule.
+func (p *Plugin) updateSchedule(userID string, scheduleID string, cronSpec string, message string) (*ScheduledMessage, *scheduleError) {
+	if _, err := parseRecurrence(cronSpec); err != nil {
+		return nil, newScheduleError(http.StatusBadRequest, "Cannot start cron-job. Is your cron-syntax correct?")
+	}
+
+	var before, scheduledMsg ScheduledMessage
+	var approvalNeeded bool
+	var approvers []string
+	scheduleErr := p.changeSchedule(scheduleID, func(data *SchedulerData, index int) *scheduleError {
+		scheduledMsg = data.ScheduledMessages[index]
+		before = scheduledMsg
+
+		scheduledMsg.Cron = cronSpec
+		scheduledMsg.Message = message
+		if err := p.checkPolicy(scheduledMsg, data.ScheduledMessages); err != nil {
+			return newScheduleError(http.StatusBadRequest, "Your message violates the limits set by the admins: %s", err.Error())
+		}
+
+		approvalNeeded = p.needsApproval(userID, scheduledMsg.ChannelID)
+		if approvalNeeded {
+			if approvers = p.findApprovers(scheduledMsg.ChannelID); len(approvers) == 0 {
+				return newScheduleError(http.StatusConflict, noApproversMessage)
+			}
+			scheduledMsg.State = statePending
+			scheduledMsg.Reason = reasonPendingApproval
+		} else if scheduledMsg.GetState() == statePending {
+			//a channel admin or a user of a channel not requiring approval anymore does not have to wait for an approval
+			if err := p.checkDestination(scheduledMsg.ChannelID); err != nil {
+				return newScheduleError(http.StatusForbidden, "Your message violates the restrictions set by the admins: %s", err.Error())
+			}
+			scheduledMsg.State = stateActive
+			scheduledMsg.Reason = ""
+		}
+
+		data.ScheduledMessages[index] = scheduledMsg
+		return nil
+	})
+	if scheduleErr != nil {
+		return nil, scheduleErr
+	}
+	p.recordAudit(userID, auditActionEdit, &before, &scheduledMsg, "")
+	if approvalNeeded {
+		p.requestApproval(scheduledMsg, approvers)
+	}
+
+	return &scheduledMsg, nil
+}
+
+// removeSchedule removes the schedule with the given ID together with its history. The caller has to make sure the
+// user is allowed to manage the schedule.
+func (p *Plugin) removeSchedule(userID string, scheduleID string) *scheduleError {
+	var removedMsg ScheduledMessage
+	scheduleErr := p.changeSchedule(scheduleID, func(data *SchedulerData, index int) *scheduleError {
+		removedMsg = data.ScheduledMessages[index]
+		//from https://stackoverflow.com/a/37335777/199513
+		data.ScheduledMessages = append(data.ScheduledMessages[:index], data.ScheduledMessages[index+1:]...)
+		return nil
+	})
+	if scheduleErr != nil {
+		return scheduleErr
+	}
+	p.ClearHistoryFromStorage(removedMsg.ID)
+	p.recordAudit(userID, auditActionRemove, &removedMsg, nil, "")
+	return nil
+}
+
+// checkRunnable tells whether the given schedule may be posted manually. Only active and paused schedules can be run,
+// schedules waiting for an approval or disabled by an admin or the plugin must not post.
+func checkRunnable(msg ScheduledMessage) *scheduleError {
+	switch msg.GetState() {
+	case stateActive, statePaused:
+		return nil
+	case statePending:
+		return newScheduleError(http.StatusConflict, "The scheduled message %s is waiting for the approval of a channel admin", msg.ID)
+	default:
+		return newScheduleError(http.StatusConflict, "The scheduled message %s is %s and cannot be run", msg.ID, msg.GetState())
+	}
+}
+
+// setSchedulePaused pauses an active schedule or resumes a paused one. The caller has to make sure the user is
+// allowed to manage the schedule.
+func (p *Plugin) setSchedulePaused(userID string, scheduleID string, paused bool) (*ScheduledMessage, *scheduleError) {
+	var before, scheduledMsg ScheduledMessage
+	scheduleErr := p.changeSchedule(scheduleID, func(data *SchedulerData, index int) *scheduleError {
+		scheduledMsg = data.ScheduledMessages[index]
+		before = scheduledMsg
+
+		if paused {
+			if scheduledMsg.GetState() != stateActive {
+				return newScheduleError(http.StatusConflict, "Only active schedules can be paused, the scheduled message %s is %s", scheduledMsg.ID, scheduledMsg.GetState())
+			}
+			scheduledMsg.State = statePaused
+		} else {
+			if scheduledMsg.GetState() != statePaused {
+				return newScheduleError(http.StatusConflict, "Only paused schedules can be resumed, the scheduled message %s is %s", scheduledMsg.ID, scheduledMsg.GetState())
+			}
+			if err := p.checkDestination(scheduledMsg.ChannelID); err != nil {
+				return newScheduleError(http.StatusForbidden, "Your message violates the restrictions set by the admins: %s", err.Error())
+			}
+			if _, err := parseRecurrence(scheduledMsg.Cron); err != nil {
+				return newScheduleError(http.StatusBadRequest, "Cannot start cron-job. Is your cron-syntax correct?")
+			}
+			scheduledMsg.State = stateActive
+		}
+
+		data.ScheduledMessages[index] = scheduledMsg
+		return nil
+	})
+	if scheduleErr != nil {
+		return nil, scheduleErr
+	}
+	action := auditActionResume
+	if paused {
+		action = auditActionPause
+	}
+	p.recordAudit(userID, action, &before, &scheduledMsg, "")
+
+	return &scheduledMsg, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	routeAPIStatus = routeAPI + "/status"
+
+	//maxRecentErrors limits how many errors are kept for the status
+	maxRecentErrors = 10
+	//overdueThreshold is how late a registered schedule may be before the status reports it
+	overdueThreshold = time.Minute
+)
+
+// statusError is an error that happened recently on this node
+type statusError struct {
+	Time    int64  `json:"time"` //time in millis
+	Message string `json:"message"`
+}
+
+// statusEntry describes a registered schedule, or a stored schedule that should be registered
+type statusEntry struct {
+	ScheduleID string `json:"schedule_id"`
+	State      string `json:"state,omitempty"`
+	NextRun    int64  `json:"next_run,omitempty"` //time in millis
+	Problem    string `json:"problem,omitempty"`  //set if the registry does not match the stored schedules
+}
+
+// pluginStatus describes the state of the scheduler on this node
+type pluginStatus struct {
+	Node              string        `json:"node"`
+	CronRunning       bool          `json:"cron_running"`
+	Leader            string        `json:"leader"` //hostname of the node posting the schedules, empty if there is none
+	LeaderExpiresAt   int64         `json:"leader_expires_at,omitempty"`
+	IsLeader          bool          `json:"is_leader"`
+	StoredSchedules   int           `json:"stored_schedules"`
+	ActiveSchedules   int           `json:"active_schedules"`
+	RegisteredEntries int           `json:"registered_entries"`
+	Mismatches        int           `json:"mismatches"`
+	Entries           []statusEntry `json:"entries"`
+	RecentErrors      []statusError `json:"recent_errors"`
+}
+
+// recordError keeps the given error for the status, the oldest errors are dropped
+func (p *Plugin) recordError(format string, args ...interface{}) {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	p.recentErrors = append(p.recentErrors, statusError{Time: toMillis(time.Now()), Message: fmt.Sprintf(format, args...)})
+	if len(p.recentErrors) > maxRecentErrors {
+		p.recentErrors = p.recentErrors[len(p.recentErrors)-maxRecentErrors:]
+	}
+}
+
+// collectStatus compares the registered schedules with the stored ones
+func (p *Plugin) collectStatus() pluginStatus {
+	now := time.Now()
+	status := pluginStatus{Node: nodeName(), IsLeader: p.isLeader(), Entries: []statusEntry{}}
+	if lease, _ := p.readLeaderLease(); lease != nil && lease.ExpiresAt > toMillis(now) {
+		status.Leader = lease.Node
+		status.LeaderExpiresAt = lease.ExpiresAt
+	}
+
+	p.statusLock.Lock()
+	status.CronRunning = p.cronRunning
+	status.RecentErrors = append([]statusError{}, p.recentErrors...)
+	p.statusLock.Unlock()
+
+	p.registryLock.Lock()
+	registry := map[string]registration{}
+	for scheduleID, item := range p.registry {
+		registry[scheduleID] = item
+	}
+	p.registryLock.Unlock()
+	status.RegisteredEntries = len(registry)
+
+	data := p.ReadFromStorage()
+	status.StoredSchedules = len(data.ScheduledMessages)
+	for _, msg := range data.ScheduledMessages {
+		active := msg.GetState() == stateActive
+		if active {
+			status.ActiveSchedules++
+		}
+		item := statusEntry{ScheduleID: msg.ID, State: msg.GetState()}
+		known, ok := registry[msg.ID]
+		switch {
+		case ok:
+			item.NextRun = toMillisOrZero(known.nextRun)
+			switch {
+			case !active:
+				item.Problem = fmt.Sprintf("registered although it is %s", msg.GetState())
+			case registeredChanged(known.msg, msg):
+				item.Problem = "registered with an outdated version"
+			case !known.nextRun.IsZero() && now.Sub(known.nextRun.Add(p.deliveryJitter(known.msg.ID))) > overdueThreshold:
+				item.Problem = "overdue"
+			}
+			delete(registry, msg.ID)
+		case active:
+			item.Problem = "not registered"
+		default:
+			continue //schedules that are not active are not expected to be registered
+		}
+		status.Entries = append(status.Entries, item)
+	}
+	for scheduleID, known := range registry {
+		status.Entries = append(status.Entries, statusEntry{ScheduleID: scheduleID, NextRun: toMillisOrZero(known.nextRun), Problem: "no stored schedule"})
+	}
+	for _, item := range status.Entries {
+		if item.Problem != "" {
+			status.Mismatches++
+		}
+	}
+
+	//mismatches are listed first, then the schedules posting next
+	sort.SliceStable(status.Entries, func(i, j int) bool {
+		if (status.Entries[i].Problem == "") != (status.Entries[j].Problem == "") {
+			return status.Entries[i].Problem != ""
+		}
+		return status.Entries[i].NextRun < status.Entries[j].NextRun
+	})
+	return status
+}
+
+// renderStatus renders the given status as markdown for the status command
+func renderStatus(status pluginStatus, location *time.Location) string {
+	formatTime := func(millis int64) string {
+		if millis == 0 {
+			return "-"
+		}
+		return fromMillis(millis).In(location).Format(timeFormat)
+	}
+	yesNo := func(value bool) string {
+		if value {
+			return "yes"
+		}
+		return "no"
+	}
+
+	leader := "none"
+	if status.Leader != "" {
+		leader = fmt.Sprintf("%s (lease until %s)", status.Leader, formatTime(status.LeaderExpiresAt))
+	}
+	message := fmt.Sprintf("Status of the scheduler on node %s:\n", status.Node)
+	message = message + fmt.Sprintf("* **Cron engine running:** %s\n", yesNo(status.CronRunning))
+	message = message + fmt.Sprintf("* **Leader:** %s, this node is the leader: %s\n", leader, yesNo(status.IsLeader))
+	message = message + fmt.Sprintf("* **Schedules:** %d stored, %d active, %d registered\n", status.StoredSchedules, status.ActiveSchedules, status.RegisteredEntries)
+	message = message + fmt.Sprintf("* **Mismatches:** %d\n", status.Mismatches)
+
+	if len(status.Entries) > 0 {
+		message = message + "\n| ID | State | Next run | Problem |\n"
+		message = message + "| :- | :---- | :------- | :------ |\n"
+		for _, item := range status.Entries {
+			problem := "-"
+			if item.Problem != "" {
+				problem = fmt.Sprintf("**%s**", item.Problem)
+			}
+			message = message + fmt.Sprintf("| %s | %s | %s | %s |\n", item.ScheduleID, item.State, formatTime(item.NextRun), problem)
+		}
+	}
+
+	if len(status.RecentErrors) > 0 {
+		message = message + "\nRecent errors:\n"
+		for index := len(status.RecentErrors) - 1; index >= 0; index-- {
+			recentError := status.RecentErrors[index]
+			message = message + fmt.Sprintf("* %s: %s\n", formatTime(recentError.Time), strings.Replace(recentError.Message, "\n", " ", -1))
+		}
+	}
+	return message
+}
+
+// handleStatus returns the status of the scheduler on this node to system admins
+func (p *Plugin) handleStatus(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		writeAPIError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+	if !p.isSystemAdmin(userID) {
+		writeAPIError(w, http.StatusForbidden, "Only system admins can see the status")
+		return
+	}
+	writeJSON(w, http.StatusOK, p.collectStatus())
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestLeadership(t *testing.T) {
+	t.Run("Acquires a free lease", func(t *testing.T) {
+		plugin := &Plugin{nodeID: "node1"}
+		api := &plugintest.API{}
+		api.On("KVGet", LEADERKEY).Return(nil, nil)
+		api.On("KVSetWithOptions", LEADERKEY, mock.Anything, model.PluginKVSetOptions{Atomic: true}).Return(true, nil)
+		//the new leader applies the configuration to the stored schedules
+		api.On("KVGet", KVKEY).Return(nil, nil).Once()
+		plugin.SetAPI(api)
+
+		plugin.renewLeadership()
+		assert.True(t, plugin.isLeader())
+		api.AssertExpectations(t)
+	})
+	t.Run("Renews its own lease", func(t *testing.T) {
+		plugin := &Plugin{nodeID: "node1", leaderUntil: time.Now().Add(time.Minute)}
+		lease, _ := json.Marshal(leaderLease{NodeID: "node1", Node: "self", ExpiresAt: toMillis(time.Now().Add(time.Minute))})
+		api := &plugintest.API{}
+		api.On("KVGet", LEADERKEY).Return(lease, nil)
+		api.On("KVSetWithOptions", LEADERKEY, mock.Anything, model.PluginKVSetOptions{Atomic: true, OldValue: lease}).Return(true, nil)
+		plugin.SetAPI(api)
+
+		plugin.renewLeadership()
+		assert.True(t, plugin.isLeader())
+		api.AssertNotCalled(t, "KVGet", KVKEY)
+	})
+	t.Run("Respects the lease of another node", func(t *testing.T) {
+		plugin := &Plugin{nodeID: "node1"}
+		lease, _ := json.Marshal(leaderLease{NodeID: "node2", Node: "other", ExpiresAt: toMillis(time.Now().Add(time.Minute))})
+		api := &plugintest.API{}
+		api.On("KVGet", LEADERKEY).Return(lease, nil)
+		plugin.SetAPI(api)
+
+		plugin.renewLeadership()
+		assert.False(t, plugin.isLeader())
+		api.AssertNotCalled(t, "KVSetWithOptions", mock.Anything, mock.Anything, mock.Anything)
+	})
+	t.Run("Takes over an expired lease", func(t *testing.T) {
+		plugin := &Plugin{nodeID: "node1"}
+		lease, _ := json.Marshal(leaderLease{NodeID: "node2", Node: "other", ExpiresAt: toMillis(time.Now().Add(-time.Minute))})
+		api := &plugintest.API{}
+		api.On("KVGet", LEADERKEY).Return(lease, nil)
+		api.On("KVSetWithOptions", LEADERKEY, mock.Anything, model.PluginKVSetOptions{Atomic: true, OldValue: lease}).Return(true, nil)
+		api.On("KVGet", KVKEY).Return(nil, nil)
+		plugin.SetAPI(api)
+
+		plugin.renewLeadership()
+		assert.True(t, plugin.isLeader())
+	})
+	t.Run("Loses the race for the lease", func(t *testing.T) {
+		plugin := &Plugin{nodeID: "node1"}
+		api := &plugintest.API{}
+		api.On("KVGet", LEADERKEY).Return(nil, nil)
+		api.On("KVSetWithOptions", LEADERKEY, mock.Anything, mock.Anything).Return(false, nil)
+		plugin.SetAPI(api)
+
+		plugin.renewLeadership()
+		assert.False(t, plugin.isLeader())
+	})
+	t.Run("Releases its own lease", func(t *testing.T) {
+		plugin := &Plugin{nodeID: "node1", leaderUntil: time.Now().Add(time.Minute)}
+		lease, _ := json.Marshal(leaderLease{NodeID: "node1", ExpiresAt: toMillis(time.Now().Add(time.Minute))})
+		api := &plugintest.API{}
+		api.On("KVGet", LEADERKEY).Return(lease, nil)
+		api.On("KVCompareAndDelete", LEADERKEY, lease).Return(true, nil)
+		plugin.SetAPI(api)
+
+		plugin.releaseLeadership()
+		assert.False(t, plugin.isLeader())
+		api.AssertExpectations(t)
+	})
+}
+
+func TestStatus(t *testing.T) {
+	setupPlugin := func() (*Plugin, *plugintest.API) {
+		plugin := &Plugin{nodeID: "node1", pluginCron: cron.New(cron.WithSeconds())}
+		schedulerData := &SchedulerData{ScheduledMessages: []ScheduledMessage{
+			ScheduledMessage{ID: "registered", Cron: "@daily"},
+			ScheduledMessage{ID: "missing", Cron: "@daily"},
+			ScheduledMessage{ID: "paused", Cron: "@daily", State: statePaused},
+			ScheduledMessage{ID: "disabled", Cron: "@daily", State: stateDisabled},
+			ScheduledMessage{ID: "changed", Cron: "@daily", Message: "New message"},
+			ScheduledMessage{ID: "overdue", Cron: "@daily"},
+		}}
+		tomorrow := time.Now().Add(24 * time.Hour)
+		plugin.registry = map[string]registration{
+			"registered": registration{msg: schedulerData.ScheduledMessages[0], nextRun: tomorrow},
+			"paused":     registration{msg: schedulerData.ScheduledMessages[2], nextRun: tomorrow},
+			"changed":    registration{msg: ScheduledMessage{ID: "changed", Cron: "@daily", Message: "Old message"}, nextRun: tomorrow},
+			"overdue":    registration{msg: schedulerData.ScheduledMessages[5], nextRun: time.Now().Add(-time.Hour)},
+			"removed":    registration{msg: ScheduledMessage{ID: "removed", Cron: "@daily"}, nextRun: tomorrow},
+		}
+		reqBodyBytes := new(bytes.Buffer)
+		json.NewEncoder(reqBodyBytes).Encode(schedulerData)
+		lease, _ := json.Marshal(leaderLease{NodeID: "node2", Node: "other", ExpiresAt: toMillis(time.Now().Add(time.Minute))})
+
+		api := &plugintest.API{}
+		api.On("KVGet", KVKEY).Return(reqBodyBytes.Bytes(), nil)
+		api.On("KVGet", LEADERKEY).Return(lease, nil)
+		api.On("HasPermissionTo", "AdminUser", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+		api.On("HasPermissionTo", "TestUser", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		api.On("GetUser", mock.Anything).Return(&model.User{}, nil)
+		plugin.SetAPI(api)
+		return plugin, api
+	}
+
+	t.Run("Finds mismatches", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		plugin.recordError("Something went wrong")
+		status := plugin.collectStatus()
+
+		assert.False(t, status.CronRunning)
+		assert.Equal(t, "other", status.Leader)
+		assert.False(t, status.IsLeader)
+		assert.Equal(t, 6, status.StoredSchedules)
+		assert.Equal(t, 4, status.ActiveSchedules)
+		assert.Equal(t, 5, status.RegisteredEntries)
+		assert.Equal(t, 5, status.Mismatches)
+		problems := map[string]string{}
+		for _, entry := range status.Entries {
+			problems[entry.ScheduleID] = entry.Problem
+		}
+		assert.Equal(t, map[string]string{
+			"registered": "",
+			"missing":    "not registered",
+			"paused":     "registered although it is paused",
+			"changed":    "registered with an outdated version",
+			"overdue":    "overdue",
+			"removed":    "no stored schedule",
+		}, problems)
+		assert.Equal(t, "", status.Entries[len(status.Entries)-1].Problem, "mismatches are listed first")
+		assert.Len(t, status.RecentErrors, 1)
+	})
+	t.Run("Keeps the most recent errors", func(t *testing.T) {
+		plugin := &Plugin{}
+		for index := 0; index < maxRecentErrors+5; index++ {
+			plugin.recordError("Error %d", index)
+		}
+		assert.Len(t, plugin.recentErrors, maxRecentErrors)
+		assert.Equal(t, "Error 5", plugin.recentErrors[0].Message)
+	})
+	t.Run("Command is only for system admins", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		response, _ := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler status", UserId: "TestUser"})
+		assert.Equal(t, "Error: Only system admins can see the status", response.Text)
+
+		response, _ = plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/scheduler status", UserId: "AdminUser"})
+		assert.Contains(t, response.Text, "* **Mismatches:** 5\n")
+		assert.Contains(t, response.Text, "**not registered**")
+		assert.Contains(t, response.Text, "* **Leader:** other (lease until")
+	})
+	t.Run("Endpoint", func(t *testing.T) {
+		plugin, _ := setupPlugin()
+		request := func(userID string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodGet, routeAPIStatus, nil)
+			if userID != "" {
+				r.Header.Set("Mattermost-User-Id", userID)
+			}
+			w := httptest.NewRecorder()
+			plugin.ServeHTTP(nil, w, r)
+			return w
+		}
+
+		assert.Equal(t, http.StatusUnauthorized, request("").Code)
+		assert.Equal(t, http.StatusForbidden, request("TestUser").Code)
+		w := request("AdminUser")
+		assert.Equal(t, http.StatusOK, w.Code)
+		status := pluginStatus{}
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&status))
+		assert.Equal(t, 5, status.Mismatches)
+	})
+}
@@ -3,37 +3,309 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
 )
 
 const (
 	//KVKEY is the key used for storing the data in the KVStorage
 	KVKEY = "SchedulerData"
+	//HISTORYKEYPREFIX is prepended to the schedule ID to build the key storing its run history
+	HISTORYKEYPREFIX = "History_"
+	//AUDITKEYPREFIX is prepended to the sequence number of an audit entry to build its key, each entry is stored under its
+	//own key so it is never rewritten
+	AUDITKEYPREFIX = "Audit_"
+	//AUDITCOUNTKEY is the key of the number of audit entries, which is the sequence number of the next entry
+	AUDITCOUNTKEY = "AuditCount"
+	//DUEKEYPREFIX is prepended to the schedule ID to build the key storing its next run
+	DUEKEYPREFIX = "Due_"
+	//DELIVERYKEYPREFIX is prepended to the key of an occurrence of a schedule to build the key storing its delivery
+	DELIVERYKEYPREFIX = "Delivery_"
+	//BACKUPKEYPREFIX is prepended to the schema version of the schedules to build the key of their backup, which is
+	//stored before they are migrated
+	BACKUPKEYPREFIX = "Backup_v"
+	//BATCHKEYPREFIX is prepended to the ID of a bulk import to build the key storing the schedules it created
+	BATCHKEYPREFIX = "Batch_"
+	//REVISIONKEY is the key of the latest change of the schedules, which is polled by all nodes of a cluster
+	REVISIONKEY = "Revision"
+	//LEADERKEY is the key of the lease of the node posting the scheduled messages
+	LEADERKEY = "Leader"
+	//kvCompareAttempts is how often a value changed by another node at the same time is read and written again
+	kvCompareAttempts = 10
 )
 
-// ReadFromStorage reads IceBreakerData from the KVStore. Makes sure that data is inited for the given team and channel
+// errStorageUnchanged is returned by the function passed to updateStorage if the schedules don't have to be stored
+var errStorageUnchanged = errors.New("the schedules have not been changed")
+
+// ReadFromStorage reads the SchedulerData from the KVStore. If nothing has been stored yet, there are no schedules.
 func (p *Plugin) ReadFromStorage() SchedulerData {
+	data, _ := p.readSchedulerData()
+	return data
+}
+
+// readSchedulerData reads the schedules and the value they have been read from, which is nil if nothing is stored
+func (p *Plugin) readSchedulerData() (SchedulerData, []byte) {
+	defer p.metrics.observeStorage(storageOperationRead, time.Now())
 	data := SchedulerData{}
 	kvData, err := p.API.KVGet(KVKEY)
 	if err != nil {
-		//do nothing.. we'll return an empty IceBreakerData then...
+		//do nothing.. we'll return an empty SchedulerData then...
 	}
 	if kvData != nil {
 		json.Unmarshal(kvData, &data)
 	}
 
-	return data
+	return data, kvData
 }
 
-// WriteToStorage writes the given data to storage
-func (p *Plugin) WriteToStorage(data *SchedulerData) {
+// updateStorage reads the schedules, passes them to the given function and stores them unless it returns an error.
+// The changes are applied to the cron-instances of all nodes. If someone else changed the schedules in the meantime,
+// they are read and passed to the function again, so it must not have any side effects besides changing the data.
+// Return errStorageUnchanged from the function if nothing has to be stored.
+func (p *Plugin) updateStorage(update func(data *SchedulerData) error) error {
+	for attempt := 0; attempt < kvCompareAttempts; attempt++ {
+		data, oldValue := p.readSchedulerData()
+		if err := update(&data); err != nil {
+			if err == errStorageUnchanged {
+				return nil
+			}
+			return err
+		}
+		ok, err := p.writeSchedulerData(&data, oldValue)
+		if err != nil {
+			return err
+		}
+		if ok {
+			p.reconcileSchedules(data)
+			p.publishScheduleChange()
+			return nil
+		}
+	}
+	return errors.New("the schedules kept changing while they were stored")
+}
+
+// writeSchedulerData writes the given data if the stored value is still the given old value, which is nil if nothing
+// has been stored. It tells whether the data has been written.
+func (p *Plugin) writeSchedulerData(data *SchedulerData, oldValue []byte) (bool, error) {
+	defer p.metrics.observeStorage(storageOperationWrite, time.Now())
+	data.Version = schemaVersion
 	reqBodyBytes := new(bytes.Buffer)
 	json.NewEncoder(reqBodyBytes).Encode(data)
-	p.API.KVSet(KVKEY, reqBodyBytes.Bytes())
+	ok, appErr := p.API.KVSetWithOptions(KVKEY, reqBodyBytes.Bytes(), model.PluginKVSetOptions{Atomic: true, OldValue: oldValue})
+	if appErr != nil {
+		p.recordError("Failed to store the scheduled messages: %s", appErr.Error())
+		return false, appErr
+	}
+	return ok, nil
 }
 
 // ClearStorage removes all stored data from KVStorage
 func (p *Plugin) ClearStorage() *model.AppError {
 	return p.API.KVDelete(KVKEY)
 }
+
+// ReadHistoryFromStorage reads the run history of the given schedule from the KVStore
+func (p *Plugin) ReadHistoryFromStorage(scheduleID string) []RunRecord {
+	history, _ := p.readHistory(scheduleID)
+	return history
+}
+
+// readHistory reads the run history of the given schedule and the value it has been read from, which is nil if
+// nothing is stored
+func (p *Plugin) readHistory(scheduleID string) ([]RunRecord, []byte) {
+	defer p.metrics.observeStorage(storageOperationReadHistory, time.Now())
+	history := []RunRecord{}
+	kvData, err := p.API.KVGet(HISTORYKEYPREFIX + scheduleID)
+	if err != nil {
+		//do nothing.. we'll return an empty history then...
+	}
+	if kvData != nil {
+		json.Unmarshal(kvData, &history)
+	}
+
+	return history, kvData
+}
+
+// WriteHistoryToStorage writes the run history of the given schedule to storage if the stored value is still the
+// given old value, which is nil if nothing has been stored. It tells whether the history has been written.
+func (p *Plugin) WriteHistoryToStorage(scheduleID string, history []RunRecord, oldValue []byte) (bool, error) {
+	defer p.metrics.observeStorage(storageOperationWriteHistory, time.Now())
+	reqBodyBytes := new(bytes.Buffer)
+	json.NewEncoder(reqBodyBytes).Encode(history)
+	ok, appErr := p.API.KVSetWithOptions(HISTORYKEYPREFIX+scheduleID, reqBodyBytes.Bytes(), model.PluginKVSetOptions{Atomic: true, OldValue: oldValue})
+	if appErr != nil {
+		return false, appErr
+	}
+	return ok, nil
+}
+
+// ReadDueFromStorage reads the next run of the given schedule and its stored value, or nil if there is none
+func (p *Plugin) ReadDueFromStorage(scheduleID string) (*dueRecord, []byte) {
+	defer p.metrics.observeStorage(storageOperationReadDue, time.Now())
+	kvData, err := p.API.KVGet(DUEKEYPREFIX + scheduleID)
+	if err != nil || kvData == nil {
+		return nil, nil
+	}
+	record := &dueRecord{}
+	if err := json.Unmarshal(kvData, record); err != nil {
+		return nil, kvData
+	}
+	return record, kvData
+}
+
+// WriteDueToStorage writes the next run of the given schedule if the stored value is still the given old value,
+// which is nil if there is no stored value. It tells whether the record has been written.
+func (p *Plugin) WriteDueToStorage(scheduleID string, record *dueRecord, oldValue []byte) bool {
+	defer p.metrics.observeStorage(storageOperationWriteDue, time.Now())
+	value, _ := json.Marshal(record)
+	ok, appErr := p.API.KVSetWithOptions(DUEKEYPREFIX+scheduleID, value, model.PluginKVSetOptions{Atomic: true, OldValue: oldValue})
+	if appErr != nil {
+		p.recordError("Failed to store the next run of the scheduled message %s: %s", scheduleID, appErr.Error())
+		return false
+	}
+	return ok
+}
+
+// ClearDueFromStorage removes the next run of the given schedule from KVStorage
+func (p *Plugin) ClearDueFromStorage(scheduleID string) *model.AppError {
+	return p.API.KVDelete(DUEKEYPREFIX + scheduleID)
+}
+
+// ReadDeliveryFromStorage reads the delivery of the occurrence with the given key, or nil if it has not been posted
+func (p *Plugin) ReadDeliveryFromStorage(key string) *deliveryRecord {
+	defer p.metrics.observeStorage(storageOperationReadDelivery, time.Now())
+	kvData, err := p.API.KVGet(DELIVERYKEYPREFIX + key)
+	if err != nil || kvData == nil {
+		return nil
+	}
+	record := &deliveryRecord{}
+	if err := json.Unmarshal(kvData, record); err != nil {
+		return nil
+	}
+	return record
+}
+
+// WriteDeliveryToStorage writes the delivery of the occurrence with the given key, it expires after a week. It tells
+// whether the delivery has been written.
+func (p *Plugin) WriteDeliveryToStorage(key string, record *deliveryRecord) bool {
+	defer p.metrics.observeStorage(storageOperationWriteDelivery, time.Now())
+	value, _ := json.Marshal(record)
+	options := model.PluginKVSetOptions{ExpireInSeconds: int64(deliveryRecordExpiry / time.Second)}
+	if _, appErr := p.API.KVSetWithOptions(DELIVERYKEYPREFIX+key, value, options); appErr != nil {
+		p.recordError("Failed to store the delivery of the scheduled message %s: %s", key, appErr.Error())
+		return false
+	}
+	return true
+}
+
+// ClearDeliveryFromStorage removes the delivery of the occurrence with the given key from KVStorage
+func (p *Plugin) ClearDeliveryFromStorage(key string) *model.AppError {
+	return p.API.KVDelete(DELIVERYKEYPREFIX + key)
+}
+
+// ClearHistoryFromStorage removes the run history of the given schedule from KVStorage
+func (p *Plugin) ClearHistoryFromStorage(scheduleID string) *model.AppError {
+	return p.API.KVDelete(HISTORYKEYPREFIX + scheduleID)
+}
+
+// ReadBatchFromStorage reads the bulk import with the given ID, or nil if there is none
+func (p *Plugin) ReadBatchFromStorage(batchID string) *ImportBatch {
+	defer p.metrics.observeStorage(storageOperationReadBatch, time.Now())
+	kvData, err := p.API.KVGet(BATCHKEYPREFIX + batchID)
+	if err != nil || kvData == nil {
+		return nil
+	}
+	batch := &ImportBatch{}
+	if err := json.Unmarshal(kvData, batch); err != nil {
+		return nil
+	}
+	return batch
+}
+
+// WriteBatchToStorage writes the given bulk import to storage
+func (p *Plugin) WriteBatchToStorage(batch *ImportBatch) error {
+	defer p.metrics.observeStorage(storageOperationWriteBatch, time.Now())
+	value, _ := json.Marshal(batch)
+	if appErr := p.API.KVSet(BATCHKEYPREFIX+batch.ID, value); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// WriteAuditEntryToStorage stores the given audit entry under the next sequence number
+func (p *Plugin) WriteAuditEntryToStorage(entry AuditEntry) error {
+	defer p.metrics.observeStorage(storageOperationWriteAudit, time.Now())
+	sequence, err := p.nextAuditSequence()
+	if err != nil {
+		return err
+	}
+	reqBodyBytes := new(bytes.Buffer)
+	json.NewEncoder(reqBodyBytes).Encode(entry)
+	if appErr := p.API.KVSet(auditKey(sequence), reqBodyBytes.Bytes()); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// nextAuditSequence increments the number of audit entries and returns the sequence number of the new entry
+func (p *Plugin) nextAuditSequence() (int64, error) {
+	for attempt := 0; attempt < kvCompareAttempts; attempt++ {
+		kvData, appErr := p.API.KVGet(AUDITCOUNTKEY)
+		if appErr != nil {
+			return 0, appErr
+		}
+		var count int64
+		if kvData != nil {
+			if err := json.Unmarshal(kvData, &count); err != nil {
+				return 0, errors.Wrap(err, "invalid number of audit entries")
+			}
+		}
+		newValue, _ := json.Marshal(count + 1)
+		//another node may have recorded an entry in the meantime, which got the same sequence number
+		ok, appErr := p.API.KVSetWithOptions(AUDITCOUNTKEY, newValue, model.PluginKVSetOptions{Atomic: true, OldValue: kvData})
+		if appErr != nil {
+			return 0, appErr
+		}
+		if ok {
+			return count, nil
+		}
+	}
+	return 0, errors.New("too many concurrent audit entries")
+}
+
+// ReadAuditFromStorage passes the audit entries to the given function, from the most recent to the oldest one, until
+// it returns false. Only the visited entries are read from the KVStore.
+func (p *Plugin) ReadAuditFromStorage(visit func(entry AuditEntry) bool) {
+	defer p.metrics.observeStorage(storageOperationReadAudit, time.Now())
+	kvData, appErr := p.API.KVGet(AUDITCOUNTKEY)
+	if appErr != nil || kvData == nil {
+		return
+	}
+	var count int64
+	if err := json.Unmarshal(kvData, &count); err != nil {
+		return
+	}
+
+	for sequence := count - 1; sequence >= 0; sequence-- {
+		kvData, appErr := p.API.KVGet(auditKey(sequence))
+		if appErr != nil || kvData == nil {
+			//the entry has not been written yet, or writing it failed
+			continue
+		}
+		entry := AuditEntry{}
+		if err := json.Unmarshal(kvData, &entry); err != nil {
+			continue
+		}
+		if !visit(entry) {
+			return
+		}
+	}
+}
+
+// auditKey returns the key of the audit entry with the given sequence number, the keys sort like the numbers
+func auditKey(sequence int64) string {
+	return fmt.Sprintf("%s%013d", AUDITKEYPREFIX, sequence)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+// recordTransfer records the transfer of the given schedule to a new owner in its history. Future posts are made as
+// the new owner once the changed schedule has been stored.
+func (p *Plugin) recordTransfer(scheduleID string, note string) {
+	now := toMillis(time.Now())
+	p.appendRunRecord(scheduleID, RunRecord{
+		ScheduledAt: now,
+		ExecutedAt:  now,
+		Node:        nodeName(),
+		Trigger:     triggerTransfer,
+		Outcome:     outcomeSuccess,
+		Note:        note,
+	})
+}
+
+// getActiveUserByUsername returns the active user with the given username, the username may start with an @
+func (p *Plugin) getActiveUserByUsername(username string) (*model.User, error) {
+	username = strings.TrimPrefix(username, "@")
+	user, appErr := p.API.GetUserByUsername(username)
+	if appErr != nil {
+		return nil, errors.Errorf("there is no user @%s", username)
+	}
+	if user.DeleteAt != 0 {
+		return nil, errors.Errorf("the user @%s has been deactivated", username)
+	}
+	return user, nil
+}
+
+// describeTransfer returns the note recorded in the history when a schedule is transferred
+func describeTransfer(from string, to string, actor string) string {
+	return fmt.Sprintf("Transferred from @%s to @%s by @%s", from, to, actor)
+}